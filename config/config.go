@@ -0,0 +1,397 @@
+// Package config reads the configuration shared by "pomp" and "relays":
+// the wiring of the boards, the time zone, the zones of the garden and the safety limits.
+// Rewiring a board only needs a change of the file.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the whole configuration.
+type Config struct {
+	// Timezone is the time zone of the garden, DST how to resolve times at DST changes.
+	Timezone string `yaml:"timezone"`
+	DST      string `yaml:"dst"`
+	// Location is the position of the garden, used for the times relative to the sun.
+	Location Location `yaml:"location"`
+
+	Pomp   Pomp   `yaml:"pomp"`
+	Relays Relays `yaml:"relays"`
+
+	// Zones maps the name of every zone to the pin of its valve on the relays board.
+	Zones map[string]string `yaml:"zones"`
+	// FlowRates are the liters per minute of the zones, needed by the budgets in liters.
+	FlowRates map[string]float64 `yaml:"flow_rates"`
+
+	Safety Safety `yaml:"safety"`
+}
+
+// Location is a position on the earth in degrees, north and east are positive.
+// Both 0 means not configured.
+type Location struct {
+	Latitude  float64 `yaml:"latitude"`
+	Longitude float64 `yaml:"longitude"`
+}
+
+// Configured returns true if the location has been set.
+func (l Location) Configured() bool {
+	return l.Latitude != 0 || l.Longitude != 0
+}
+
+// Pomp is the wiring of the board with the pump and the water sensor.
+type Pomp struct {
+	PumpPin string `yaml:"pump_pin"`
+	Sensor  Sensor `yaml:"sensor"`
+}
+
+// Sensor is the MCP3008 which reads the water level.
+type Sensor struct {
+	SPISpeed int64 `yaml:"spi_speed"`
+	Channel  int   `yaml:"channel"`
+	// Interval between two readings.
+	Interval time.Duration `yaml:"interval"`
+
+	// Empty and Full are the raw values (0-1023) read with the tank empty and full,
+	// they calibrate the level in percent.
+	Empty int `yaml:"empty"`
+	Full  int `yaml:"full"`
+	// The level is low when it goes under Low and recovers when it goes over High (percent).
+	Low  float64 `yaml:"low"`
+	High float64 `yaml:"high"`
+	// Debounce is the number of consecutive samples needed to change the state of the level.
+	Debounce int `yaml:"debounce"`
+}
+
+// Relays is the wiring of the board with the valves.
+type Relays struct {
+	// Address is where "pomp" calls "relays", Listen where "relays" waits the calls.
+	Address string `yaml:"address"`
+	Listen  string `yaml:"listen"`
+	// PlusPin and MinusPin are the relays which control the direction of the current.
+	PlusPin  string `yaml:"plus_pin"`
+	MinusPin string `yaml:"minus_pin"`
+	// Pulse is how long the current is sent to a valve to move it.
+	Pulse time.Duration `yaml:"pulse"`
+}
+
+// Safety are the limits of the system.
+type Safety struct {
+	// MaxOpenZones is the maximum number of zones open at once, 0 means no limit.
+	MaxOpenZones int `yaml:"max_open_zones"`
+	// MaxSlot is the longest slot accepted by the schedule, 0 means no limit.
+	MaxSlot time.Duration `yaml:"max_slot"`
+	// MinGap is the shortest rest of the pump between two runs, 0 means no limit.
+	// Slots which overlap or follow each other without a pause are a single run.
+	// It's kept by the slots scheduled at the next free time, not by the other slots and the rules.
+	MinGap time.Duration `yaml:"min_gap"`
+
+	// Blackouts are the periods when watering is forbidden.
+	Blackouts []Blackout `yaml:"blackouts"`
+	// BlackoutPolicy tells what to do with a slot inside a blackout:
+	// reject it, or clip it to the part outside.
+	BlackoutPolicy string `yaml:"blackout_policy"`
+
+	// Budgets are the maximum water of a day or a week.
+	Budgets []Budget `yaml:"budgets"`
+
+	// CatchUp tells what to do with the slots missed or interrupted while pomp was down
+	// (skip, remaining or full), a slot can choose its own.
+	CatchUp string `yaml:"catch_up"`
+}
+
+// Budget is the maximum water of a zone, or of the whole garden, in a period.
+type Budget struct {
+	// Period is daily or weekly (from Monday).
+	Period string `yaml:"period"`
+	// Zone is the zone of the budget, all the garden if empty.
+	Zone string `yaml:"zone"`
+	// Minutes or Liters is the limit, only one of them.
+	// The minutes of the whole garden are the minutes of watering, whatever the zones open.
+	Minutes float64 `yaml:"minutes"`
+	Liters  float64 `yaml:"liters"`
+}
+
+// Blackout is a recurring period when watering is forbidden, for example a watering ban.
+type Blackout struct {
+	Name string `yaml:"name"`
+	// From and To are the hours (HH:MM) of the period, a To before From ends the day after.
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+	// Days are the week days when the period starts (mon, tue, ...), every day if empty.
+	Days []string `yaml:"days"`
+	// Months are the months when the period starts (1-12), every month if empty.
+	Months []int `yaml:"months"`
+}
+
+// weekdays are the names of the week days accepted by Blackout.Days.
+var weekdays = map[string]bool{"sun": true, "mon": true, "tue": true, "wed": true, "thu": true, "fri": true, "sat": true}
+
+// spiPins are the physical pins used by the SPI bus of the sensor.
+var spiPins = []string{"19", "21", "23", "24", "26"}
+
+// gpioPins are the physical pins of the Raspberry Pi header which can drive a relay.
+var gpioPins = map[string]bool{
+	"3": true, "5": true, "7": true, "8": true, "10": true, "11": true, "12": true, "13": true,
+	"15": true, "16": true, "18": true, "19": true, "21": true, "22": true, "23": true, "24": true,
+	"26": true, "27": true, "28": true, "29": true, "31": true, "32": true, "33": true, "35": true,
+	"36": true, "37": true, "38": true, "40": true,
+}
+
+// Default returns the configuration of the original wiring.
+func Default() *Config {
+	return &Config{
+		Timezone: "Europe/Berlin",
+		DST:      "earlier",
+		Pomp: Pomp{
+			PumpPin: "7",
+			Sensor: Sensor{
+				SPISpeed: 1350000,
+				Channel:  2,
+				Interval: 250 * time.Millisecond,
+				Empty:    0,
+				Full:     1023,
+				Low:      20,
+				High:     30,
+				Debounce: 4,
+			},
+		},
+		Relays: Relays{
+			Address:  "raspy0w:50051",
+			Listen:   ":50051",
+			PlusPin:  "11",
+			MinusPin: "13",
+			Pulse:    500 * time.Millisecond,
+		},
+		Zones: map[string]string{"1": "15"},
+		Safety: Safety{
+			BlackoutPolicy: "reject",
+			CatchUp:        "skip",
+		},
+	}
+}
+
+// Load reads the file at path over the Default configuration.
+// Unknown keys are errors, so a typo doesn't go unnoticed.
+// The configuration is not validated: call Validate after any change.
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open config: %v", err)
+	}
+	defer f.Close()
+
+	cfg := Default()
+	// The zones of the file replace the default ones.
+	cfg.Zones = nil
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err = dec.Decode(cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("unable to read config '%s': %v", path, err)
+	}
+	if cfg.Zones == nil {
+		cfg.Zones = Default().Zones
+	}
+	return cfg, nil
+}
+
+// ZoneNames returns the sorted names of the zones.
+func (c *Config) ZoneNames() []string {
+	names := make([]string, 0, len(c.Zones))
+	for name := range c.Zones {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks the configuration and returns all the problems found.
+func (c *Config) Validate() error {
+	var errs []error
+	addErr := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	// A time zone which can't be loaded (for example tzdata is missing) is not an error:
+	// pomp warns about it and runs the schedule in UTC.
+	if c.Timezone == "" {
+		addErr("timezone: must not be empty")
+	}
+	switch c.DST {
+	case "earlier", "later", "reject":
+	default:
+		addErr("dst: must be earlier, later or reject, got '%s'", c.DST)
+	}
+
+	if l := c.Location; l.Latitude < -90 || l.Latitude > 90 || l.Longitude < -180 || l.Longitude > 180 {
+		addErr("location: latitude must be between -90 and 90 and longitude between -180 and 180, got %v and %v", l.Latitude, l.Longitude)
+	}
+
+	// Pomp board.
+	pomp := newPinSet("pomp")
+	for _, pin := range spiPins {
+		pomp.use(pin, "the SPI bus of the sensor")
+	}
+	pomp.use(c.Pomp.PumpPin, "pump_pin")
+	errs = append(errs, pomp.errs...)
+
+	s := c.Pomp.Sensor
+	if s.SPISpeed <= 0 {
+		addErr("pomp.sensor.spi_speed: must be positive, got %d", s.SPISpeed)
+	}
+	if s.Channel < 0 || s.Channel > 7 {
+		addErr("pomp.sensor.channel: must be between 0 and 7, got %d", s.Channel)
+	}
+	if s.Interval <= 0 {
+		addErr("pomp.sensor.interval: must be positive, got %v", s.Interval)
+	}
+	if s.Empty < 0 || s.Empty > 1023 || s.Full < 0 || s.Full > 1023 || s.Empty == s.Full {
+		addErr("pomp.sensor.empty and full: must be different values between 0 and 1023, got %d and %d", s.Empty, s.Full)
+	}
+	if s.Low < 0 || s.High > 100 || s.Low >= s.High {
+		addErr("pomp.sensor.low and high: must be 0 <= low < high <= 100, got %v and %v", s.Low, s.High)
+	}
+	if s.Debounce < 1 {
+		addErr("pomp.sensor.debounce: must be at least 1, got %d", s.Debounce)
+	}
+
+	// Relays board.
+	relays := newPinSet("relays")
+	relays.use(c.Relays.PlusPin, "plus_pin")
+	relays.use(c.Relays.MinusPin, "minus_pin")
+	if len(c.Zones) == 0 {
+		addErr("zones: at least a zone is needed")
+	}
+	for _, name := range c.ZoneNames() {
+		if name == "" || name == "*" || strings.ContainsAny(name, ", \t") {
+			addErr("zones: invalid zone name '%s'", name)
+		}
+		relays.use(c.Zones[name], "zone '"+name+"'")
+	}
+	errs = append(errs, relays.errs...)
+
+	if c.Relays.Address == "" {
+		addErr("relays.address: must not be empty")
+	}
+	if c.Relays.Listen == "" {
+		addErr("relays.listen: must not be empty")
+	}
+	if c.Relays.Pulse <= 0 {
+		addErr("relays.pulse: must be positive, got %v", c.Relays.Pulse)
+	}
+
+	for _, name := range c.ZoneNames() {
+		if _, ok := c.FlowRates[name]; !ok {
+			continue
+		}
+		if c.FlowRates[name] <= 0 {
+			addErr("flow_rates: zone '%s' must be positive, got %v", name, c.FlowRates[name])
+		}
+	}
+	for name := range c.FlowRates {
+		if _, ok := c.Zones[name]; !ok {
+			addErr("flow_rates: unknown zone '%s'", name)
+		}
+	}
+
+	// Safety limits.
+	if c.Safety.MaxOpenZones < 0 {
+		addErr("safety.max_open_zones: must not be negative, got %d", c.Safety.MaxOpenZones)
+	}
+	if c.Safety.MaxSlot < 0 {
+		addErr("safety.max_slot: must not be negative, got %v", c.Safety.MaxSlot)
+	}
+	if c.Safety.MinGap < 0 {
+		addErr("safety.min_gap: must not be negative, got %v", c.Safety.MinGap)
+	}
+	switch c.Safety.BlackoutPolicy {
+	case "reject", "clip":
+	default:
+		addErr("safety.blackout_policy: must be reject or clip, got '%s'", c.Safety.BlackoutPolicy)
+	}
+	switch c.Safety.CatchUp {
+	case "skip", "remaining", "full":
+	default:
+		addErr("safety.catch_up: must be skip, remaining or full, got '%s'", c.Safety.CatchUp)
+	}
+	for i, b := range c.Safety.Blackouts {
+		name := fmt.Sprintf("safety.blackouts[%d]", i)
+		if b.Name != "" {
+			name += " '" + b.Name + "'"
+		}
+		from, errFrom := time.Parse("15:04", b.From)
+		to, errTo := time.Parse("15:04", b.To)
+		if errFrom != nil || errTo != nil {
+			addErr("%s: from and to must be HH:MM, got '%s' and '%s'", name, b.From, b.To)
+		} else if from.Equal(to) {
+			addErr("%s: from and to must be different, got %s", name, b.From)
+		}
+		for _, d := range b.Days {
+			if !weekdays[d] {
+				addErr("%s: unknown week day '%s'", name, d)
+			}
+		}
+		for _, m := range b.Months {
+			if m < 1 || m > 12 {
+				addErr("%s: months must be between 1 and 12, got %d", name, m)
+			}
+		}
+	}
+
+	for i, b := range c.Safety.Budgets {
+		name := fmt.Sprintf("safety.budgets[%d]", i)
+		if b.Period != "daily" && b.Period != "weekly" {
+			addErr("%s: period must be daily or weekly, got '%s'", name, b.Period)
+		}
+		if b.Minutes < 0 || b.Liters < 0 || (b.Minutes > 0) == (b.Liters > 0) {
+			addErr("%s: one of minutes and liters must be positive, got %v and %v", name, b.Minutes, b.Liters)
+		}
+		if _, ok := c.Zones[b.Zone]; b.Zone != "" && !ok {
+			addErr("%s: unknown zone '%s'", name, b.Zone)
+		}
+		if b.Liters > 0 {
+			for _, zone := range c.ZoneNames() {
+				if _, ok := c.FlowRates[zone]; (b.Zone == "" || b.Zone == zone) && !ok {
+					addErr("%s: liters need the flow rate of zone '%s'", name, zone)
+				}
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%v", errors.Join(errs...))
+	}
+	return nil
+}
+
+// pinSet keeps the pins used on a board and the errors of their assignment.
+type pinSet struct {
+	board string
+	used  map[string]string
+	errs  []error
+}
+
+func newPinSet(board string) *pinSet {
+	return &pinSet{board: board, used: make(map[string]string)}
+}
+
+// use assigns pin to user, recording an error if the pin is invalid or already used.
+func (ps *pinSet) use(pin, user string) {
+	if !gpioPins[pin] {
+		ps.errs = append(ps.errs, fmt.Errorf("%s: %s: '%s' is not a GPIO pin of the header", ps.board, user, pin))
+		return
+	}
+	if other, ok := ps.used[pin]; ok {
+		ps.errs = append(ps.errs, fmt.Errorf("%s: pin %s assigned to both %s and %s", ps.board, pin, other, user))
+		return
+	}
+	ps.used[pin] = user
+}
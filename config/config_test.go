@@ -0,0 +1,117 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefault(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Errorf("Default().Validate() = %v, want nil", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	cfg, err := Load("example.yaml")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if err = cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if got := strings.Join(cfg.ZoneNames(), ","); got != "back,front" {
+		t.Errorf("ZoneNames() = %v, want back,front", got)
+	}
+	if cfg.Pomp.Sensor.Interval != 250*time.Millisecond {
+		t.Errorf("interval = %v, want 250ms", cfg.Pomp.Sensor.Interval)
+	}
+	if cfg.Safety.MaxSlot != 2*time.Hour {
+		t.Errorf("max_slot = %v, want 2h", cfg.Safety.MaxSlot)
+	}
+	if len(cfg.Safety.Blackouts) != 1 || cfg.Safety.Blackouts[0].From != "10:00" || len(cfg.Safety.Blackouts[0].Months) != 3 {
+		t.Errorf("blackouts = %+v, want the summer ban", cfg.Safety.Blackouts)
+	}
+	if !cfg.Location.Configured() || cfg.Location.Latitude != 52.52 {
+		t.Errorf("location = %+v, want 52.52 13.405", cfg.Location)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err = os.WriteFile(path, []byte("pomp:\n  pump_pinn: \"7\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Load(path); err == nil {
+		t.Errorf("Load() with an unknown key want error, got nil")
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		wantErr string
+	}{
+		{"default", func(c *Config) {}, ""},
+		{"pump on the SPI bus", func(c *Config) { c.Pomp.PumpPin = "19" }, "pin 19 assigned to both the SPI bus of the sensor and pump_pin"},
+		{"pump not a GPIO", func(c *Config) { c.Pomp.PumpPin = "6" }, "'6' is not a GPIO pin"},
+		{"duplicate valve", func(c *Config) { c.Zones = map[string]string{"a": "15", "b": "15"} }, "pin 15 assigned to both zone 'a' and zone 'b'"},
+		{"valve on a polarity relay", func(c *Config) { c.Zones = map[string]string{"a": "11"} }, "pin 11 assigned to both plus_pin and zone 'a'"},
+		{"same pin on different boards", func(c *Config) { c.Zones = map[string]string{"a": "7"} }, ""},
+		{"no zones", func(c *Config) { c.Zones = nil }, "at least a zone"},
+		{"invalid zone", func(c *Config) { c.Zones = map[string]string{"a,b": "15"} }, "invalid zone name"},
+		{"channel", func(c *Config) { c.Pomp.Sensor.Channel = 8 }, "channel"},
+		{"interval", func(c *Config) { c.Pomp.Sensor.Interval = 0 }, "interval"},
+		{"calibration", func(c *Config) { c.Pomp.Sensor.Full = c.Pomp.Sensor.Empty }, "empty and full"},
+		{"inverted calibration", func(c *Config) { c.Pomp.Sensor.Empty, c.Pomp.Sensor.Full = 1023, 0 }, ""},
+		{"hysteresis", func(c *Config) { c.Pomp.Sensor.Low = 40 }, "low and high"},
+		{"debounce", func(c *Config) { c.Pomp.Sensor.Debounce = 0 }, "debounce"},
+		{"timezone", func(c *Config) { c.Timezone = "" }, "timezone"},
+		{"timezone not loaded", func(c *Config) { c.Timezone = "Mars/Olympus" }, ""},
+		{"dst", func(c *Config) { c.DST = "never" }, "dst"},
+		{"location", func(c *Config) { c.Location = Location{Latitude: 52.52, Longitude: 13.405} }, ""},
+		{"latitude", func(c *Config) { c.Location = Location{Latitude: 91, Longitude: 13.405} }, "location"},
+		{"longitude", func(c *Config) { c.Location = Location{Latitude: 52.52, Longitude: -181} }, "location"},
+		{"max zones", func(c *Config) { c.Safety.MaxOpenZones = -1 }, "max_open_zones"},
+		{"min gap", func(c *Config) { c.Safety.MinGap = -time.Minute }, "min_gap"},
+		{"blackout", func(c *Config) {
+			c.Safety.Blackouts = []Blackout{{Name: "ban", From: "10:00", To: "18:00", Days: []string{"mon"}, Months: []int{6, 7, 8}}}
+		}, ""},
+		{"blackout over midnight", func(c *Config) { c.Safety.Blackouts = []Blackout{{From: "22:00", To: "06:00"}} }, ""},
+		{"blackout hours", func(c *Config) { c.Safety.Blackouts = []Blackout{{Name: "ban", From: "10", To: "18:00"}} }, "'ban': from and to must be HH:MM"},
+		{"blackout empty", func(c *Config) { c.Safety.Blackouts = []Blackout{{From: "10:00", To: "10:00"}} }, "must be different"},
+		{"blackout day", func(c *Config) {
+			c.Safety.Blackouts = []Blackout{{From: "10:00", To: "18:00", Days: []string{"monday"}}}
+		}, "unknown week day"},
+		{"blackout month", func(c *Config) { c.Safety.Blackouts = []Blackout{{From: "10:00", To: "18:00", Months: []int{13}}} }, "months"},
+		{"blackout policy", func(c *Config) { c.Safety.BlackoutPolicy = "ignore" }, "blackout_policy"},
+		{"catch up", func(c *Config) { c.Safety.CatchUp = "later" }, "catch_up"},
+		{"flow rate", func(c *Config) { c.FlowRates = map[string]float64{"1": 0} }, "must be positive"},
+		{"flow rate of unknown zone", func(c *Config) { c.FlowRates = map[string]float64{"2": 6} }, "flow_rates: unknown zone '2'"},
+		{"budget", func(c *Config) {
+			c.FlowRates = map[string]float64{"1": 6}
+			c.Safety.Budgets = []Budget{{Period: "daily", Minutes: 60}, {Period: "weekly", Zone: "1", Liters: 500}}
+		}, ""},
+		{"budget period", func(c *Config) { c.Safety.Budgets = []Budget{{Period: "monthly", Minutes: 60}} }, "period"},
+		{"budget limit", func(c *Config) { c.Safety.Budgets = []Budget{{Period: "daily", Minutes: 60, Liters: 500}} }, "one of minutes and liters"},
+		{"budget zone", func(c *Config) { c.Safety.Budgets = []Budget{{Period: "daily", Zone: "2", Minutes: 60}} }, "unknown zone '2'"},
+		{"budget without flow", func(c *Config) { c.Safety.Budgets = []Budget{{Period: "daily", Liters: 500}} }, "flow rate of zone '1'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			tt.change(c)
+			err := c.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
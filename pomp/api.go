@@ -0,0 +1,640 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiServer exposes the scheduler and the status of the system over HTTP.
+type apiServer struct {
+	scheduler *waterTimeManager
+	status    *systemStatus
+	events    *bus
+}
+
+// slotRequest is the body used to create a slot.
+// Times use the same format of the console, in the time zone of the garden.
+// Soak like "8m/15m" splits the slot in pulses: end - start is the time the water runs.
+// CatchUp is the policy if the slot is missed while pomp is down (skip, remaining or full).
+type slotRequest struct {
+	Start   string   `json:"start"`
+	End     string   `json:"end"`
+	Zones   []string `json:"zones,omitempty"`
+	Soak    string   `json:"soak,omitempty"`
+	CatchUp string   `json:"catch_up,omitempty"`
+}
+
+// runRequest is the body used to water now: the duration is like "10m",
+// the policy is preempt (default) or shift.
+type runRequest struct {
+	Zones    []string `json:"zones,omitempty"`
+	Duration string   `json:"duration"`
+	Policy   string   `json:"policy,omitempty"`
+}
+
+// freeRequest is the body used to schedule at the next free time: the duration is like "20m",
+// the time is searched from after (same format of the console), now if it's empty.
+// The same fields are the parameters of the query (zones separated by commas).
+type freeRequest struct {
+	Zones    []string `json:"zones,omitempty"`
+	Duration string   `json:"duration"`
+	After    string   `json:"after,omitempty"`
+}
+
+// pauseRequest is the body used to pause the schedule: for some days or until a time
+// (same format of the console), without both until the schedule is resumed.
+type pauseRequest struct {
+	Days   int    `json:"days,omitempty"`
+	Until  string `json:"until,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// stopResponse is the number of slots stopped manually.
+type stopResponse struct {
+	Stopped int `json:"stopped"`
+}
+
+// slotResponse is a slot of the schedule.
+type slotResponse struct {
+	ID        uint64    `json:"id"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Zones     []string  `json:"zones"`
+	Started   bool      `json:"started"`
+	WillStart string    `json:"will_start,omitempty"`
+	Pulses    *pulses   `json:"pulses,omitempty"`
+}
+
+// pulses is the progress of a slot split in pulses.
+type pulses struct {
+	Soak    string `json:"soak"`
+	Current int    `json:"current"`
+	Total   int    `json:"total"`
+	Soaking bool   `json:"soaking"`
+}
+
+// statusResponse is the status of the whole system.
+type statusResponse struct {
+	Schedule  []*slotResponse `json:"schedule"`
+	Rules     []string        `json:"rules"`
+	Pause     *pauseResponse  `json:"pause"`
+	Cycle     cycleResponse   `json:"cycle"`
+	Pump      pumpResponse    `json:"pump"`
+	Zones     []string        `json:"zones"`
+	Sensor    sensorResponse  `json:"sensor"`
+	Fault     *faultResponse  `json:"fault"`
+	LastFault *faultResponse  `json:"last_fault"`
+}
+
+// cycleResponse is the state of the irrigation cycle, the history only on /cycle.
+type cycleResponse struct {
+	State   string               `json:"state"`
+	Since   time.Time            `json:"since"`
+	History []transitionResponse `json:"history,omitempty"`
+}
+
+type transitionResponse struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason"`
+}
+
+type pauseResponse struct {
+	Since  time.Time  `json:"since"`
+	Until  *time.Time `json:"until,omitempty"`
+	Reason string     `json:"reason"`
+}
+
+type pumpResponse struct {
+	On      bool      `json:"on"`
+	Changed time.Time `json:"changed"`
+}
+
+type sensorResponse struct {
+	Value int       `json:"value"`
+	Level float64   `json:"level"`
+	Low   bool      `json:"low"`
+	Read  time.Time `json:"read"`
+}
+
+type faultResponse struct {
+	Code   string    `json:"code"`
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+	Value  int       `json:"value"`
+}
+
+// budgetResponse is a budget in its current period, the water is in the unit of the budget.
+type budgetResponse struct {
+	Period    string    `json:"period"`
+	Zone      string    `json:"zone,omitempty"`
+	Unit      string    `json:"unit"`
+	Limit     float64   `json:"limit"`
+	Used      float64   `json:"used"`
+	Planned   float64   `json:"planned"`
+	Remaining float64   `json:"remaining"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+}
+
+// runResponse is a record of the history, the actual start and end only if the slot ran.
+type runResponse struct {
+	Slot         uint64     `json:"slot"`
+	Zones        []string   `json:"zones"`
+	PlannedStart time.Time  `json:"planned_start"`
+	PlannedEnd   time.Time  `json:"planned_end"`
+	Start        *time.Time `json:"start,omitempty"`
+	End          *time.Time `json:"end,omitempty"`
+	Outcome      string     `json:"outcome"`
+	StopReason   string     `json:"stop_reason,omitempty"`
+	Reason       string     `json:"reason,omitempty"`
+	PumpOn       string     `json:"pump_on"`
+	SensorMin    *int       `json:"sensor_min,omitempty"`
+	SensorMax    *int       `json:"sensor_max,omitempty"`
+}
+
+// errorResponse is the body returned with every error.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// newAPIHandler returns the http.Handler of the api.
+func newAPIHandler(scheduler *waterTimeManager, status *systemStatus, events *bus) http.Handler {
+	api := &apiServer{scheduler: scheduler, status: status, events: events}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slots", api.handleSlots)
+	mux.HandleFunc("/slots/", api.handleSlot)
+	mux.HandleFunc("/status", api.handleStatus)
+	mux.HandleFunc("/fault/ack", api.handleFaultAck)
+	mux.HandleFunc("/cycle", api.handleCycle)
+	mux.HandleFunc("/run", api.handleRun)
+	mux.HandleFunc("/stop", api.handleStop)
+	mux.HandleFunc("/pause", api.handlePause)
+	mux.HandleFunc("/resume", api.handleResume)
+	mux.HandleFunc("/budget", api.handleBudget)
+	mux.HandleFunc("/history", api.handleHistory)
+	mux.HandleFunc("/events", api.handleEvents)
+	mux.HandleFunc("/free", api.handleFree)
+	return mux
+}
+
+// handleSlots lists (GET) and creates (POST) slots.
+func (api *apiServer) handleSlots(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, api.slots())
+
+	case http.MethodPost:
+		req := &slotRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unable to decode the body: %v", err))
+			return
+		}
+
+		row := req.Start + " - " + req.End
+		if len(req.Zones) > 0 {
+			row += " " + strings.Join(req.Zones, ",")
+		}
+		if req.Soak != "" {
+			row += " soak " + req.Soak
+		}
+		if req.CatchUp != "" {
+			row += " catchup " + req.CatchUp
+		}
+		wt, err := newWaterTime(row)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unable to parse time: %v", err))
+			return
+		}
+
+		if _, err = api.scheduler.Append(wt); err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+		slot := &slotResponse{ID: wt.id, Start: wt.start, End: wt.end, Zones: wt.zones}
+		if wt.soak != nil {
+			slot.Pulses = &pulses{Soak: wt.soak.String(), Total: len(wt.pulses())}
+		}
+		writeJSON(w, http.StatusCreated, slot)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// handleSlot deletes (DELETE) a slot.
+func (api *apiServer) handleSlot(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/slots/"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unable to parse id: %v", err))
+		return
+	}
+
+	switch r.Method {
+
+	case http.MethodDelete:
+		if err = api.scheduler.Remove(id); err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// handleRun starts (POST) a manual run now.
+func (api *apiServer) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	req := &runRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unable to decode the body: %v", err))
+		return
+	}
+	d, err := time.ParseDuration(req.Duration)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unable to parse duration: %v", err))
+		return
+	}
+	policy, err := parseRunPolicy(req.Policy)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var zones []string
+	if len(req.Zones) > 0 {
+		if zones, err = parseZones(strings.Join(req.Zones, ",")); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if f := api.status.activeFault(); f != nil {
+		writeError(w, http.StatusConflict, fmt.Errorf("fault '%s' active, acknowledge it first", f.code))
+		return
+	}
+
+	wt, err := api.scheduler.RunNow(zones, d, policy)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, &slotResponse{ID: wt.id, Start: wt.start, End: wt.end, Zones: wt.zones, Started: true})
+}
+
+// handleStop ends (POST) the running slots, the future ones are kept.
+func (api *apiServer) handleStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	stopped, err := api.scheduler.StopNow()
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, &stopResponse{Stopped: stopped})
+}
+
+// handlePause pauses (POST) the schedule and returns the pause.
+func (api *apiServer) handlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	req := &pauseRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unable to decode the body: %v", err))
+		return
+	}
+
+	var until time.Time
+	switch {
+	case req.Days < 0 || req.Days > 0 && req.Until != "":
+		writeError(w, http.StatusBadRequest, fmt.Errorf("days must be positive and can't be used with until"))
+		return
+	case req.Days > 0:
+		until = api.scheduler.clock.Now().In(siteTZ.loc).AddDate(0, 0, req.Days)
+	case req.Until != "":
+		var err error
+		if until, err = siteTZ.parse(parseTimeConst, req.Until); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unable to parse until: %v", err))
+			return
+		}
+	}
+	if req.Reason == "" {
+		req.Reason = "manual"
+	}
+
+	if err := api.scheduler.Pause(until, req.Reason); err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newPauseResponse(api.scheduler.Paused()))
+}
+
+// handleResume resumes (POST) the schedule.
+func (api *apiServer) handleResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	if err := api.scheduler.Resume(); err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleBudget returns (GET) the remaining water of every budget.
+func (api *apiServer) handleBudget(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	resp := make([]*budgetResponse, 0)
+	for _, b := range api.scheduler.Budgets() {
+		resp = append(resp, &budgetResponse{
+			Period:    string(b.budget.period),
+			Zone:      b.budget.zone,
+			Unit:      b.budget.unit(),
+			Limit:     b.budget.limit,
+			Used:      b.used,
+			Planned:   b.planned,
+			Remaining: b.remaining,
+			From:      b.from,
+			To:        b.to,
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleHistory returns (GET) the history of the slots planned from the day "from" to the day "to"
+// included (like 2018-08-21), the last week without them.
+func (api *apiServer) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	from, to, err := historyRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"), api.scheduler.clock.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	resp := make([]*runResponse, 0)
+	for _, rec := range api.scheduler.History(from, to) {
+		run := &runResponse{
+			Slot:         rec.Slot,
+			Zones:        rec.Zones,
+			PlannedStart: rec.PlannedStart,
+			PlannedEnd:   rec.PlannedEnd,
+			Outcome:      string(rec.Outcome),
+			StopReason:   string(rec.StopReason),
+			Reason:       rec.Reason,
+			PumpOn:       rec.PumpOn.String(),
+		}
+		if !rec.Start.IsZero() {
+			start, end := rec.Start, rec.End
+			run.Start, run.End = &start, &end
+		}
+		if rec.SensorMin >= 0 {
+			min, max := rec.SensorMin, rec.SensorMax
+			run.SensorMin, run.SensorMax = &min, &max
+		}
+		resp = append(resp, run)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleStatus returns (GET) the status of the system.
+func (api *apiServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	state := api.status.snapshot()
+	cycleState, since := api.status.cycle.current()
+	resp := &statusResponse{
+		Cycle:    cycleResponse{State: string(cycleState), Since: since},
+		Schedule: make([]*slotResponse, 0),
+		Rules:    api.scheduler.Rules(),
+		Pause:    newPauseResponse(api.scheduler.Paused()),
+		Pump:     pumpResponse{On: state.pumpOn, Changed: state.pumpChanged},
+		Zones:    state.zones,
+		Sensor:   sensorResponse{Value: state.sensorValue, Level: state.sensorLevel, Low: state.waterLow, Read: state.sensorRead},
+	}
+	for _, s := range api.scheduler.PrintStatus() {
+		resp.Schedule = append(resp.Schedule, newSlotResponse(s))
+	}
+	resp.Fault = newFaultResponse(state.fault)
+	resp.LastFault = newFaultResponse(state.lastFault)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleCycle returns (GET) the state of the cycle and its history.
+func (api *apiServer) handleCycle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	state, since := api.status.cycle.current()
+	resp := &cycleResponse{State: string(state), Since: since, History: make([]transitionResponse, 0)}
+	for _, t := range api.status.cycle.transitions() {
+		resp.History = append(resp.History, transitionResponse{From: string(t.from), To: string(t.to), At: t.at, Reason: t.reason})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleFaultAck acknowledges (POST) the active fault and returns it.
+func (api *apiServer) handleFaultAck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	f, err := ackFault(api.events, api.status)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newFaultResponse(f))
+}
+
+// newFaultResponse returns the faultResponse of a fault, nil if there isn't.
+func newFaultResponse(f *fault) *faultResponse {
+	if f == nil {
+		return nil
+	}
+	return &faultResponse{Code: string(f.code), Reason: f.reason, At: f.at, Value: f.value}
+}
+
+// newPauseResponse returns the pauseResponse of a pause, nil if there isn't.
+func newPauseResponse(p *pause) *pauseResponse {
+	if p == nil {
+		return nil
+	}
+	resp := &pauseResponse{Since: p.since, Reason: p.reason}
+	if !p.until.IsZero() {
+		resp.Until = &p.until
+	}
+	return resp
+}
+
+// handleFree finds (GET) the next free time, or schedules (POST) a slot there.
+func (api *apiServer) handleFree(w http.ResponseWriter, r *http.Request) {
+	req := &freeRequest{}
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Duration, req.After = q.Get("duration"), q.Get("after")
+		if z := q.Get("zones"); z != "" {
+			req.Zones = strings.Split(z, ",")
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unable to decode the body: %v", err))
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	d, err := time.ParseDuration(req.Duration)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unable to parse duration: %v", err))
+		return
+	}
+	var zones []string
+	if len(req.Zones) > 0 {
+		if zones, err = parseZones(strings.Join(req.Zones, ",")); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	var after time.Time
+	if req.After != "" {
+		if after, err = siteTZ.parse(parseTimeConst, req.After); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unable to parse after: %v", err))
+			return
+		}
+	}
+
+	var wt *waterTime
+	code := http.StatusOK
+	if r.Method == http.MethodPost {
+		wt, err = api.scheduler.AppendFree(zones, d, after)
+		code = http.StatusCreated
+	} else {
+		wt, err = api.scheduler.NextFree(zones, d, after)
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, code, &slotResponse{ID: wt.id, Start: wt.start, End: wt.end, Zones: wt.zones})
+}
+
+// handleEvents streams (GET) the slots as server-sent events,
+// when the client connects and at every change of the schedule.
+func (api *apiServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+		return
+	}
+
+	changes := api.scheduler.Subscribe()
+	defer api.scheduler.Unsubscribe(changes)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for {
+		data, err := json.Marshal(api.slots())
+		if err != nil {
+			log.Printf("unable to encode the slots: %v", err)
+			return
+		}
+		if _, err = fmt.Fprintf(w, "event: schedule\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-changes:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// slots returns all the slots of the schedule.
+func (api *apiServer) slots() []*slotResponse {
+	slots := make([]*slotResponse, 0)
+	for _, s := range api.scheduler.PrintStatus() {
+		slots = append(slots, newSlotResponse(s))
+	}
+	return slots
+}
+
+// newSlotResponse returns the slotResponse of a sumWaterTime.
+func newSlotResponse(s *sumWaterTime) *slotResponse {
+	slot := &slotResponse{ID: s.id, Start: s.start, End: s.end, Zones: s.zones, Started: s.started}
+	if !s.started {
+		slot.WillStart = s.willStart.String()
+	}
+	if s.soak != nil {
+		slot.Pulses = &pulses{Soak: s.soak.String(), Current: s.pulse, Total: s.pulses, Soaking: s.soaking}
+	}
+	return slot
+}
+
+// errorStatus returns the http status of an error of the scheduler.
+func errorStatus(err error) int {
+	switch err.(type) {
+	case *notFoundError:
+		return http.StatusNotFound
+	case *conflictError:
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+// writeJSON writes v as body of the response.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("unable to write the response: %v", err)
+	}
+}
+
+// writeError writes err as body of the response.
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, &errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_apiServer(t *testing.T) {
+
+	wtm := newWaterTimeManager()
+	status := newSystemStatus(realClock{})
+	status.setPump(true)
+	status.raiseFault(faultNoWater, "no water", 12)
+
+	server := httptest.NewServer(newAPIHandler(wtm, status, newBus()))
+	defer server.Close()
+
+	now := time.Now().In(siteTZ.loc)
+	slot := func(from, to time.Duration, zones ...string) string {
+		body, _ := json.Marshal(&slotRequest{
+			Start: now.Add(from).Format(parseTimeConst),
+			End:   now.Add(to).Format(parseTimeConst),
+			Zones: zones,
+		})
+		return string(body)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		wantCode int
+	}{
+		{"create", http.MethodPost, "/slots", slot(time.Hour, 2*time.Hour, "front"), http.StatusCreated},
+		{"create other zone", http.MethodPost, "/slots", slot(time.Hour, 2*time.Hour, "back"), http.StatusCreated},
+		{"create colliding", http.MethodPost, "/slots", slot(90*time.Minute, 3*time.Hour, "front"), http.StatusConflict},
+		{"create in the past", http.MethodPost, "/slots", slot(-time.Hour, time.Hour), http.StatusBadRequest},
+		{"create bad json", http.MethodPost, "/slots", "{", http.StatusBadRequest},
+		{"create bad time", http.MethodPost, "/slots", `{"start": "now", "end": "later"}`, http.StatusBadRequest},
+		{"list", http.MethodGet, "/slots", "", http.StatusOK},
+		{"wrong method", http.MethodPut, "/slots", "", http.StatusMethodNotAllowed},
+		{"delete", http.MethodDelete, "/slots/1", "", http.StatusNoContent},
+		{"delete again", http.MethodDelete, "/slots/1", "", http.StatusNotFound},
+		{"delete bad id", http.MethodDelete, "/slots/one", "", http.StatusBadRequest},
+		{"status", http.MethodGet, "/status", "", http.StatusOK},
+		{"ack fault", http.MethodPost, "/fault/ack", "", http.StatusOK},
+		{"ack without fault", http.MethodPost, "/fault/ack", "", http.StatusConflict},
+		{"ack wrong method", http.MethodGet, "/fault/ack", "", http.StatusMethodNotAllowed},
+		{"cycle", http.MethodGet, "/cycle", "", http.StatusOK},
+		{"run", http.MethodPost, "/run", `{"zones": ["front"], "duration": "10m"}`, http.StatusCreated},
+		{"run bad duration", http.MethodPost, "/run", `{"duration": "soon"}`, http.StatusBadRequest},
+		{"run bad policy", http.MethodPost, "/run", `{"duration": "10m", "policy": "later"}`, http.StatusBadRequest},
+		{"run wrong method", http.MethodGet, "/run", "", http.StatusMethodNotAllowed},
+		{"stop", http.MethodPost, "/stop", "", http.StatusOK},
+		{"stop without run", http.MethodPost, "/stop", "", http.StatusConflict},
+		{"pause", http.MethodPost, "/pause", `{"days": 3, "reason": "rain"}`, http.StatusOK},
+		{"pause bad days", http.MethodPost, "/pause", `{"days": -1}`, http.StatusBadRequest},
+		{"pause bad until", http.MethodPost, "/pause", `{"until": "tomorrow"}`, http.StatusBadRequest},
+		{"resume", http.MethodPost, "/resume", "", http.StatusNoContent},
+		{"resume not paused", http.MethodPost, "/resume", "", http.StatusConflict},
+		{"budget", http.MethodGet, "/budget", "", http.StatusOK},
+		{"history", http.MethodGet, "/history?from=2018-08-21&to=2018-08-22", "", http.StatusOK},
+		{"history last week", http.MethodGet, "/history", "", http.StatusOK},
+		{"history bad range", http.MethodGet, "/history?from=2018-08-22&to=2018-08-21", "", http.StatusBadRequest},
+		{"history bad day", http.MethodGet, "/history?from=yesterday", "", http.StatusBadRequest},
+		{"budget wrong method", http.MethodPost, "/budget", "", http.StatusMethodNotAllowed},
+		{"next free", http.MethodGet, "/free?duration=20m&zones=front", "", http.StatusOK},
+		{"next free after", http.MethodGet, "/free?duration=20m&after=" + url.QueryEscape(now.Add(time.Hour).Format(parseTimeConst)), "", http.StatusOK},
+		{"next free bad duration", http.MethodGet, "/free?duration=soon", "", http.StatusBadRequest},
+		{"schedule at next free", http.MethodPost, "/free", `{"duration": "20m", "zones": ["front"]}`, http.StatusCreated},
+		{"delete the slot at next free", http.MethodDelete, "/slots/4", "", http.StatusNoContent},
+		{"schedule at next free bad zones", http.MethodPost, "/free", `{"duration": "20m", "zones": [""]}`, http.StatusBadRequest},
+		{"free wrong method", http.MethodDelete, "/free", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, server.URL+tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("unable to create the request: %v", err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("unable to do the request: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("status code = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+		})
+	}
+
+	// The status contains the schedule and the hardware.
+	resp, err := http.Get(server.URL + "/status")
+	if err != nil {
+		t.Fatalf("unable to get the status: %v", err)
+	}
+	defer resp.Body.Close()
+	got := &statusResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(got); err != nil {
+		t.Fatalf("unable to decode the status: %v", err)
+	}
+	if len(got.Schedule) != 1 || got.Schedule[0].ID != 2 || fmt.Sprint(got.Schedule[0].Zones) != "[back]" {
+		t.Errorf("schedule = %+v, want only the slot 2 on back", got.Schedule)
+	}
+	if !got.Pump.On {
+		t.Errorf("pump = %+v, want on", got.Pump)
+	}
+	if got.Cycle.State != string(stateStopping) {
+		t.Errorf("cycle = %+v, want stopping (closing the valves) after the acknowledgement", got.Cycle)
+	}
+	if got.Fault != nil {
+		t.Errorf("fault = %+v, want nil after the acknowledgement", got.Fault)
+	}
+	if got.LastFault == nil || got.LastFault.Code != string(faultNoWater) || got.LastFault.Value != 12 {
+		t.Errorf("last fault = %+v, want no water", got.LastFault)
+	}
+}
+
+func Test_apiServer_events(t *testing.T) {
+
+	wtm := newWaterTimeManager()
+	server := httptest.NewServer(newAPIHandler(wtm, newSystemStatus(realClock{}), newBus()))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %s, want text/event-stream", ct)
+	}
+
+	events := bufio.NewReader(resp.Body)
+	next := func() []*slotResponse {
+		t.Helper()
+		slots := []*slotResponse{}
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatalf("unable to read the event: %v", err)
+			}
+			if data := strings.TrimPrefix(line, "data: "); data != line {
+				if err := json.Unmarshal([]byte(data), &slots); err != nil {
+					t.Fatalf("unable to decode the event: %v", err)
+				}
+				return slots
+			}
+		}
+	}
+
+	// The schedule when the client connects, then at every change.
+	if slots := next(); len(slots) != 0 {
+		t.Errorf("first event = %v, want no slots", slots)
+	}
+	now := time.Now().In(siteTZ.loc)
+	if _, err := wtm.Append(&waterTime{start: now.Add(time.Hour), end: now.Add(2 * time.Hour), zones: []string{"front"}}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if slots := next(); len(slots) != 1 || slots[0].ID != 1 {
+		t.Errorf("event after Append = %v, want slot 1", slots)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/tux-eithel/PIrrigation_system/config"
+)
+
+// blackoutPolicy tells what to do with a waterTime inside a blackout.
+type blackoutPolicy string
+
+const (
+	// blackoutReject rejects the waterTime.
+	blackoutReject blackoutPolicy = "reject"
+	// blackoutClip clips the waterTime to the part before the blackout,
+	// or after it if the waterTime starts inside.
+	blackoutClip blackoutPolicy = "clip"
+)
+
+// blackout is a recurring period when watering is forbidden.
+type blackout struct {
+	name string
+	// from and to are the wall clock hours of the period, in minutes from midnight.
+	// If to is before from the period ends the day after.
+	from, to int
+	// weekdays and months when the period starts, all if empty.
+	weekdays map[time.Weekday]bool
+	months   map[time.Month]bool
+}
+
+// blackoutWindow is an occurrence of a blackout.
+type blackoutWindow struct {
+	name       string
+	start, end time.Time
+}
+
+// String returns a human readable version of the blackoutWindow.
+func (w *blackoutWindow) String() string {
+	return fmt.Sprintf("blackout '%s' %s - %s", w.name, w.start.Format(parseTimeConst), w.end.Format(parseTimeConst))
+}
+
+// newBlackouts returns the blackouts of the configuration.
+// The configuration must be already validated.
+func newBlackouts(cfg []config.Blackout) []*blackout {
+	blackouts := make([]*blackout, 0, len(cfg))
+	for _, c := range cfg {
+		from, _ := time.Parse("15:04", c.From)
+		to, _ := time.Parse("15:04", c.To)
+		b := &blackout{
+			name:     c.Name,
+			from:     from.Hour()*60 + from.Minute(),
+			to:       to.Hour()*60 + to.Minute(),
+			weekdays: make(map[time.Weekday]bool),
+			months:   make(map[time.Month]bool),
+		}
+		for _, d := range c.Days {
+			b.weekdays[weekdayNames[d]] = true
+		}
+		for _, m := range c.Months {
+			b.months[time.Month(m)] = true
+		}
+		blackouts = append(blackouts, b)
+	}
+	return blackouts
+}
+
+// windows returns the occurrences of the blackout overlapping the range [from, to).
+func (b *blackout) windows(from, to time.Time) []*blackoutWindow {
+	windows := make([]*blackoutWindow, 0)
+
+	// Days are counted in UTC, so DST changes don't move them.
+	// The day before is needed for the periods ending the day after.
+	y, m, d := from.In(siteTZ.loc).Date()
+	first := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+	y, m, d = to.In(siteTZ.loc).Date()
+	last := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		if len(b.weekdays) > 0 && !b.weekdays[day.Weekday()] || len(b.months) > 0 && !b.months[day.Month()] {
+			continue
+		}
+		endDay := day
+		if b.to < b.from {
+			endDay = day.AddDate(0, 0, 1)
+		}
+		start, err := siteTZ.date(day.Year(), day.Month(), day.Day(), b.from/60, b.from%60, 0)
+		if err != nil {
+			log.Printf("blackout '%s' skips %s: %v", b.name, day.Format("2006-01-02"), err)
+			continue
+		}
+		end, err := siteTZ.date(endDay.Year(), endDay.Month(), endDay.Day(), b.to/60, b.to%60, 0)
+		if err != nil {
+			log.Printf("blackout '%s' skips %s: %v", b.name, day.Format("2006-01-02"), err)
+			continue
+		}
+		if start.Before(to) && from.Before(end) {
+			windows = append(windows, &blackoutWindow{name: b.name, start: start, end: end})
+		}
+	}
+	return windows
+}
+
+// withBlackouts forbids watering during the blackouts, a waterTime inside one is handled by policy.
+func withBlackouts(blackouts []*blackout, policy blackoutPolicy) managerOption {
+	return func(wtm *waterTimeManager) {
+		wtm.blackouts = blackouts
+		wtm.blackoutPolicy = policy
+	}
+}
+
+// blackoutWindows returns the occurrences of all the blackouts overlapping the range [from, to),
+// ordered by start.
+func (wtm *waterTimeManager) blackoutWindows(from, to time.Time) []*blackoutWindow {
+	windows := make([]*blackoutWindow, 0)
+	for _, b := range wtm.blackouts {
+		windows = append(windows, b.windows(from, to)...)
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].start.Before(windows[j].start) })
+	return windows
+}
+
+// activeBlackout returns the blackout window running at time now, nil if there isn't.
+// The blackouts never change, so it doesn't need the lock.
+func (wtm *waterTimeManager) activeBlackout(now time.Time) *blackoutWindow {
+	windows := wtm.blackoutWindows(now, now.Add(time.Nanosecond))
+	if len(windows) == 0 {
+		return nil
+	}
+	return windows[0]
+}
+
+// applyBlackouts checks that t is outside the blackouts.
+// With blackoutClip t is clipped to the part before the first blackout,
+// its start is moved after a blackout only if move is true.
+// It returns a conflictError if t can't be watered.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) applyBlackouts(t *waterTime, move bool) error {
+	start, end := t.start, t.end
+	for _, w := range wtm.blackoutWindows(t.start, t.end) {
+		if !w.end.After(start) {
+			continue
+		}
+		if wtm.blackoutPolicy != blackoutClip || !move && !w.start.After(start) {
+			return &conflictError{msg: fmt.Sprintf("time range %v-%v is inside the %v", t.start, t.end, w)}
+		}
+		if !w.start.After(start) {
+			start = w.end
+			continue
+		}
+		if w.start.Before(end) {
+			end = w.start
+		}
+		break
+	}
+
+	if !end.After(start) {
+		return &conflictError{msg: fmt.Sprintf("time range %v-%v is all inside the blackouts", t.start, t.end)}
+	}
+	if !start.Equal(t.start) || !end.Equal(t.end) {
+		log.Printf("time range %v-%v clipped to %v-%v by the blackouts", t.start, t.end, start, end)
+		t.start, t.end = start, end
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tux-eithel/PIrrigation_system/config"
+)
+
+func Test_blackout_windows(t *testing.T) {
+
+	// 2018-08-21 is a Tuesday.
+	day := func(d, h, m int) time.Time { return time.Date(2018, 8, d, h, m, 0, 0, siteTZ.loc) }
+
+	tests := []struct {
+		name     string
+		cfg      config.Blackout
+		from, to time.Time
+		want     []string
+	}{
+		{
+			"every day", config.Blackout{Name: "ban", From: "10:00", To: "18:00"}, day(21, 0, 0), day(23, 0, 0),
+			[]string{"2018-08-21 10:00:00 - 2018-08-21 18:00:00", "2018-08-22 10:00:00 - 2018-08-22 18:00:00"},
+		},
+		{
+			"partial overlap", config.Blackout{Name: "ban", From: "10:00", To: "18:00"}, day(21, 17, 0), day(22, 11, 0),
+			[]string{"2018-08-21 10:00:00 - 2018-08-21 18:00:00", "2018-08-22 10:00:00 - 2018-08-22 18:00:00"},
+		},
+		{
+			"over midnight", config.Blackout{Name: "night", From: "22:00", To: "06:00"}, day(21, 1, 0), day(21, 23, 0),
+			[]string{"2018-08-20 22:00:00 - 2018-08-21 06:00:00", "2018-08-21 22:00:00 - 2018-08-22 06:00:00"},
+		},
+		{
+			"week days", config.Blackout{Name: "ban", From: "10:00", To: "18:00", Days: []string{"wed"}}, day(20, 0, 0), day(27, 0, 0),
+			[]string{"2018-08-22 10:00:00 - 2018-08-22 18:00:00"},
+		},
+		{
+			"other months", config.Blackout{Name: "ban", From: "10:00", To: "18:00", Months: []int{6, 7}}, day(20, 0, 0), day(27, 0, 0),
+			[]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, w := range newBlackouts([]config.Blackout{tt.cfg})[0].windows(tt.from, tt.to) {
+				got = append(got, w.start.Format(parseTimeConst)+" - "+w.end.Format(parseTimeConst))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("windows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_waterTimeManager_applyBlackouts(t *testing.T) {
+
+	at := func(h, m int) time.Time { return time.Date(2018, 8, 21, h, m, 0, 0, siteTZ.loc) }
+	blackouts := newBlackouts([]config.Blackout{{Name: "ban", From: "10:00", To: "18:00"}, {Name: "lunch", From: "12:00", To: "13:00"}})
+
+	tests := []struct {
+		name       string
+		policy     blackoutPolicy
+		move       bool
+		start, end time.Time
+		want       string
+		wantErr    bool
+	}{
+		{"outside", blackoutReject, true, at(6, 0), at(7, 0), "06:00-07:00", false},
+		{"touching", blackoutReject, true, at(9, 0), at(10, 0), "09:00-10:00", false},
+		{"reject", blackoutReject, true, at(9, 0), at(11, 0), "", true},
+		{"clip the end", blackoutClip, true, at(9, 0), at(11, 0), "09:00-10:00", false},
+		{"clip the start", blackoutClip, true, at(17, 0), at(19, 0), "18:00-19:00", false},
+		{"clip the start without move", blackoutClip, false, at(17, 0), at(19, 0), "", true},
+		{"clip around", blackoutClip, true, at(9, 0), at(19, 0), "09:00-10:00", false},
+		{"all inside", blackoutClip, true, at(11, 0), at(14, 0), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wtm := newWaterTimeManager(withBlackouts(blackouts, tt.policy))
+			wt := &waterTime{start: tt.start, end: tt.end}
+			err := wtm.applyBlackouts(wt, tt.move)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyBlackouts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if _, ok := err.(*conflictError); !ok {
+					t.Errorf("applyBlackouts() error = %T, want *conflictError", err)
+				}
+				return
+			}
+			if got := wt.start.Format("15:04") + "-" + wt.end.Format("15:04"); got != tt.want {
+				t.Errorf("applyBlackouts() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_consumerSchedule_blackout(t *testing.T) {
+
+	start := time.Date(2018, 8, 21, 9, 0, 0, 0, siteTZ.loc)
+	blackouts := newBlackouts([]config.Blackout{{Name: "ban", From: "10:00", To: "18:00"}})
+	ct := newConsumerTest(start, withBlackouts(blackouts, blackoutReject))
+	fc, wtm := ct.fc, ct.wtm
+	// A slot saved before the blackout has been configured.
+	wtm.insert(&waterTime{start: start, end: start.Add(2 * time.Hour), zones: []string{"a"}, id: wtm.nextID()})
+	ct.run()
+	sub := ct.sub
+
+	fc.AdvanceTo(start)
+	waitEvent(t, sub, startZonesEvent{})
+
+	// The blackout begins before the end of the slot: it's stopped.
+	fc.WaitWaiters(1)
+	if next, _ := fc.Next(); !next.Equal(start.Add(time.Hour)) {
+		t.Fatalf("next timer at %v, want the blackout at %v", next, start.Add(time.Hour))
+	}
+	fc.AdvanceTo(start.Add(time.Hour))
+	if e := waitEvent(t, sub, stopEvent{}); e != (stopEvent{stopLocal}) {
+		t.Errorf("stop signal at the blackout = %v, want %v", e, stopLocal)
+	}
+
+	// Appending inside the blackout is rejected.
+	if _, err := wtm.Append(&waterTime{start: start.Add(3 * time.Hour), end: start.Add(4 * time.Hour)}); err == nil {
+		t.Errorf("Append() inside the blackout want error, got nil")
+	}
+
+	ct.stop()
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/tux-eithel/PIrrigation_system/config"
+)
+
+// budgetPeriod is the period of a budget, it starts at midnight of the site.
+type budgetPeriod string
+
+const (
+	budgetDaily budgetPeriod = "daily"
+	// budgetWeekly starts on Monday.
+	budgetWeekly budgetPeriod = "weekly"
+
+	// budgetEpsilon is the water under which a budget is considered exhausted,
+	// it absorbs the rounding of the durations.
+	budgetEpsilon = 1e-6
+)
+
+// budget is the maximum water of a zone, or of the whole garden, in every period.
+type budget struct {
+	period budgetPeriod
+	// zone of the budget, empty for the whole garden.
+	zone string
+	// limit is in liters if liters is true, otherwise in minutes.
+	// The minutes of the whole garden are the minutes of watering, whatever the zones open.
+	limit  float64
+	liters bool
+}
+
+// String returns a human readable version of the budget.
+func (b *budget) String() string {
+	zone := b.zone
+	if zone == "" {
+		zone = "all zones"
+	}
+	return fmt.Sprintf("%s budget of %s (%.1f %s)", b.period, zone, b.limit, b.unit())
+}
+
+// unit returns the unit of the budget.
+func (b *budget) unit() string {
+	if b.liters {
+		return "l"
+	}
+	return "min"
+}
+
+// newBudgets returns the budgets of the configuration.
+// The configuration must be already validated.
+func newBudgets(cfg []config.Budget) []*budget {
+	budgets := make([]*budget, 0, len(cfg))
+	for _, c := range cfg {
+		b := &budget{period: budgetPeriod(c.Period), zone: c.Zone, limit: c.Minutes}
+		if c.Liters > 0 {
+			b.limit, b.liters = c.Liters, true
+		}
+		budgets = append(budgets, b)
+	}
+	return budgets
+}
+
+// periodAt returns the range [start, end) of the period of the budget containing t.
+func (b *budget) periodAt(t time.Time) (time.Time, time.Time) {
+	y, m, d := t.In(siteTZ.loc).Date()
+	start := time.Date(y, m, d, 0, 0, 0, 0, siteTZ.loc)
+	if b.period == budgetDaily {
+		return start, start.AddDate(0, 0, 1)
+	}
+	start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+	return start, start.AddDate(0, 0, 7)
+}
+
+// water returns how much of the budget is used by zones open for d.
+func (b *budget) water(zones []string, d time.Duration, flows map[string]float64) float64 {
+	if b.zone == "" && !b.liters {
+		return d.Minutes()
+	}
+	used := 0.0
+	for _, z := range zones {
+		if b.zone != "" && z != b.zone {
+			continue
+		}
+		if b.liters {
+			used += flows[z] * d.Minutes()
+		} else {
+			used += d.Minutes()
+		}
+	}
+	return used
+}
+
+// dayUsage is the water delivered in a day of the site.
+type dayUsage struct {
+	// watering is the time with some zone open.
+	watering time.Duration
+	// zones is the time every zone has been open.
+	zones map[string]time.Duration
+}
+
+// used returns how much of the budget the day used.
+func (u *dayUsage) used(b *budget, flows map[string]float64) float64 {
+	if b.zone == "" && !b.liters {
+		return u.watering.Minutes()
+	}
+	used := 0.0
+	for z, d := range u.zones {
+		used += b.water([]string{z}, d, flows)
+	}
+	return used
+}
+
+// budgetStatus is the state of a budget in its current period.
+type budgetStatus struct {
+	budget   budget
+	from, to time.Time
+	// used is the water already delivered, planned the water of the queue still to deliver.
+	used, planned, remaining float64
+}
+
+// withBudgets limits the water of every period,
+// flows are the liters per minute of the zones needed by the budgets in liters.
+func withBudgets(budgets []*budget, flows map[string]float64) managerOption {
+	return func(wtm *waterTimeManager) {
+		wtm.budgets = budgets
+		wtm.flows = flows
+	}
+}
+
+// zoneNames returns the zones, all the known ones for a waterTime without zones.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) zoneNames(zones []string) []string {
+	if len(zones) > 0 && zones[0] != allZones {
+		return zones
+	}
+	set := make(map[string]bool)
+	for z := range wtm.zones {
+		set[z] = true
+	}
+	for z := range wtm.flows {
+		set[z] = true
+	}
+	names := make([]string, 0, len(set))
+	for z := range set {
+		names = append(names, z)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// usedWater returns how much of the budget has been delivered in [from, to),
+// also by the zones still open since the last heartbeat.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) usedWater(b *budget, from, to time.Time) float64 {
+	used := 0.0
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		if u, ok := wtm.usage[day.Format("2006-01-02")]; ok {
+			used += u.used(b, wtm.flows)
+		}
+	}
+
+	// The water of a running slot is added to the usage only at the next heartbeat.
+	start, end := wtm.aliveAt, wtm.clock.Now()
+	if start.Before(from) {
+		start = from
+	}
+	if end.After(to) {
+		end = to
+	}
+	if len(wtm.aliveZones) > 0 && end.After(start) {
+		used += b.water(wtm.zoneNames(wtm.aliveZones), end.Sub(start), wtm.flows)
+	}
+	return used
+}
+
+// plannedWater returns how much of the budget t uses in [from, to).
+// The caller must hold the lock.
+func (wtm *waterTimeManager) plannedWater(b *budget, t *waterTime, from, to time.Time) float64 {
+	return b.water(wtm.zoneNames(t.zones), t.runTimeIn(from, to), wtm.flows)
+}
+
+// checkBudgets checks that t, with the water delivered and the queue, stays inside the budgets
+// of every period it touches. Only the part of the slots after now is counted, the rest is delivered.
+// A time already in the queue (with the same id) is not counted twice.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) checkBudgets(t *waterTime) error {
+	now := wtm.clock.Now()
+	for _, b := range wtm.budgets {
+		for from, to := b.periodAt(t.start); from.Before(t.end); from, to = b.periodAt(to) {
+			start := from
+			if start.Before(now) {
+				start = now
+			}
+			need := wtm.plannedWater(b, t, start, to)
+			if need == 0 {
+				continue
+			}
+			taken := wtm.usedWater(b, from, to)
+			for _, o := range wtm.times {
+				if t.id == 0 || o.id != t.id {
+					taken += wtm.plannedWater(b, o, start, to)
+				}
+			}
+			if taken+need > b.limit+budgetEpsilon {
+				return &conflictError{msg: fmt.Sprintf("time range %v-%v needs %.1f %s of the %v from %s, only %.1f left",
+					t.start, t.end, need, b.unit(), b, from.Format("2006-01-02"), b.limit-taken)}
+			}
+		}
+	}
+	return nil
+}
+
+// addUsage adds the water delivered by zones open in [from, to) to the usage of the days.
+// Only the days still needed by the budgets are kept.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) addUsage(zones []string, from, to time.Time) {
+	if len(wtm.budgets) == 0 || len(zones) == 0 || !to.After(from) {
+		return
+	}
+
+	names := wtm.zoneNames(zones)
+	for start := from; start.Before(to); {
+		y, m, d := start.In(siteTZ.loc).Date()
+		end := time.Date(y, m, d+1, 0, 0, 0, 0, siteTZ.loc)
+		if end.After(to) {
+			end = to
+		}
+		day := start.In(siteTZ.loc).Format("2006-01-02")
+		u, ok := wtm.usage[day]
+		if !ok {
+			u = &dayUsage{zones: make(map[string]time.Duration)}
+			wtm.usage[day] = u
+		}
+		u.watering += end.Sub(start)
+		for _, z := range names {
+			u.zones[z] += end.Sub(start)
+		}
+		start = end
+	}
+
+	// A week is the longest period, older days aren't needed anymore.
+	oldest := to.In(siteTZ.loc).AddDate(0, 0, -7).Format("2006-01-02")
+	for day := range wtm.usage {
+		if day < oldest {
+			delete(wtm.usage, day)
+		}
+	}
+}
+
+// exhaustedBudget returns the first budget with no water left at time now for the zones of t,
+// nil if t can still water.
+// It's thread safe.
+func (wtm *waterTimeManager) exhaustedBudget(t *waterTime, now time.Time) *budget {
+	wtm.RLock()
+	defer wtm.RUnlock()
+
+	zones := wtm.zoneNames(t.zones)
+	for _, b := range wtm.budgets {
+		if b.water(zones, time.Minute, wtm.flows) == 0 {
+			continue
+		}
+		if from, to := b.periodAt(now); b.limit-wtm.usedWater(b, from, to) < budgetEpsilon {
+			return b
+		}
+	}
+	return nil
+}
+
+// budgetRunsOut returns when the first budget runs out keeping zones open from now,
+// zero if no budget limits them.
+// It's thread safe.
+func (wtm *waterTimeManager) budgetRunsOut(zones []string, now time.Time) time.Time {
+	wtm.RLock()
+	defer wtm.RUnlock()
+
+	if len(zones) == 0 {
+		return time.Time{}
+	}
+	names := wtm.zoneNames(zones)
+	var first time.Time
+	for _, b := range wtm.budgets {
+		rate := b.water(names, time.Minute, wtm.flows)
+		if rate == 0 {
+			continue
+		}
+		from, to := b.periodAt(now)
+		left := b.limit - wtm.usedWater(b, from, to)
+		if left < budgetEpsilon {
+			continue // The slots using it are already skipped.
+		}
+		// Rounded up, so the budget is exhausted when the consumer wakes up.
+		out := now.Add(time.Duration(left/rate*float64(time.Minute)) + 1)
+		if first.IsZero() || out.Before(first) {
+			first = out
+		}
+	}
+	return first
+}
+
+// Budgets returns the state of the budgets in the period containing now.
+// It's thread safe.
+func (wtm *waterTimeManager) Budgets() []*budgetStatus {
+	wtm.RLock()
+	defer wtm.RUnlock()
+
+	now := wtm.clock.Now()
+	budgets := make([]*budgetStatus, 0, len(wtm.budgets))
+	for _, b := range wtm.budgets {
+		s := &budgetStatus{budget: *b}
+		s.from, s.to = b.periodAt(now)
+		s.used = wtm.usedWater(b, s.from, s.to)
+		for _, t := range wtm.times {
+			s.planned += wtm.plannedWater(b, t, now, s.to)
+		}
+		s.remaining = b.limit - s.used
+		if s.remaining < 0 {
+			s.remaining = 0
+		}
+		budgets = append(budgets, s)
+	}
+	return budgets
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_budget_periodAt(t *testing.T) {
+	// 2018-08-22 is a Wednesday.
+	now := time.Date(2018, 8, 22, 15, 30, 0, 0, siteTZ.loc)
+	tests := []struct {
+		period   budgetPeriod
+		from, to time.Time
+	}{
+		{budgetDaily, time.Date(2018, 8, 22, 0, 0, 0, 0, siteTZ.loc), time.Date(2018, 8, 23, 0, 0, 0, 0, siteTZ.loc)},
+		{budgetWeekly, time.Date(2018, 8, 20, 0, 0, 0, 0, siteTZ.loc), time.Date(2018, 8, 27, 0, 0, 0, 0, siteTZ.loc)},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.period), func(t *testing.T) {
+			b := &budget{period: tt.period}
+			if from, to := b.periodAt(now); !from.Equal(tt.from) || !to.Equal(tt.to) {
+				t.Errorf("periodAt() = %v - %v, want %v - %v", from, to, tt.from, tt.to)
+			}
+		})
+	}
+}
+
+func Test_waterTimeManager_checkBudgets(t *testing.T) {
+
+	day := func(d, h, m int) time.Time { return time.Date(2018, 8, d, h, m, 0, 0, siteTZ.loc) }
+	store := newScheduleStore(filepath.Join(t.TempDir(), "schedule"))
+	wtm := newWaterTimeManager(
+		withClock(newFakeClock(day(21, 5, 0))),
+		withStore(store),
+		withZones([]string{"front", "back"}),
+		withBudgets([]*budget{
+			{period: budgetDaily, limit: 60},
+			{period: budgetWeekly, zone: "front", limit: 100, liters: true},
+		}, map[string]float64{"front": 5, "back": 4}),
+	)
+
+	// 10 minutes of front delivered yesterday, so 50 liters of the week are gone.
+	wtm.alive([]string{"front"}, day(20, 6, 0), day(20, 6, 10))
+	wtm.alive(nil, day(20, 6, 10), day(21, 5, 0))
+
+	tests := []struct {
+		name    string
+		start   time.Time
+		end     time.Time
+		zones   []string
+		wantErr bool
+	}{
+		{"front", day(21, 6, 0), day(21, 6, 5), []string{"front"}, false},
+		{"front over the week", day(21, 7, 0), day(21, 7, 10), []string{"front"}, true},
+		{"back", day(21, 7, 0), day(21, 7, 40), []string{"back"}, false},
+		{"back over the day", day(21, 8, 0), day(21, 8, 20), []string{"back"}, true},
+		{"back the day after", day(22, 6, 0), day(22, 6, 20), []string{"back"}, false},
+		{"all zones", day(23, 6, 0), day(23, 6, 3), nil, false},
+		{"all zones over the week", day(24, 6, 0), day(24, 6, 3), nil, true},
+		{"front next week", day(27, 6, 0), day(27, 6, 15), []string{"front"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := wtm.Append(&waterTime{start: tt.start, end: tt.end, zones: tt.zones})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Append() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, ok := err.(*conflictError); err != nil && !ok {
+				t.Errorf("Append() error = %T, want a conflictError", err)
+			}
+		})
+	}
+
+	// A slot moved is not counted twice.
+	if err := wtm.Update(1, &waterTime{start: day(21, 6, 0), end: day(21, 6, 7), zones: []string{"front"}}); err != nil {
+		t.Errorf("Update() error = %v", err)
+	}
+
+	got := wtm.Budgets()
+	if len(got) != 2 {
+		t.Fatalf("Budgets() = %d budgets, want 2", len(got))
+	}
+	// Week of front: 50 liters used, 35 and 15 planned.
+	if week := got[1]; week.used != 50 || week.planned != 50 || week.remaining != 50 {
+		t.Errorf("weekly budget = used %v, planned %v, remaining %v, want 50, 50, 50", week.used, week.planned, week.remaining)
+	}
+
+	// The usage is saved with the schedule.
+	loaded := newWaterTimeManager(withClock(wtm.clock), withStore(store), withBudgets(wtm.budgets, wtm.flows))
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if week := loaded.Budgets()[1]; week.used != 50 {
+		t.Errorf("loaded weekly budget used = %v, want 50", week.used)
+	}
+
+	// A running slot uses the budget also before the next heartbeat.
+	fc := newFakeClock(day(21, 6, 0))
+	running := newWaterTimeManager(withClock(fc), withBudgets([]*budget{{period: budgetDaily, limit: 30}}, nil))
+	running.alive([]string{"back"}, day(21, 6, 0), day(21, 6, 0))
+	fc.Advance(20 * time.Minute)
+	if _, err := running.Append(&waterTime{start: day(21, 7, 0), end: day(21, 7, 15), zones: []string{"back"}}); err == nil {
+		t.Errorf("Append() over the water of the running slot want error, got nil")
+	}
+	if _, err := running.Append(&waterTime{start: day(21, 7, 0), end: day(21, 7, 10), zones: []string{"back"}}); err != nil {
+		t.Errorf("Append() error = %v", err)
+	}
+}
+
+func Test_consumerSchedule_budget(t *testing.T) {
+
+	start := time.Date(2018, 8, 21, 6, 0, 0, 0, siteTZ.loc)
+	ct := newConsumerTest(start, withBudgets([]*budget{{period: budgetDaily, limit: 10}}, nil))
+	fc, wtm := ct.fc, ct.wtm
+	wtm.insert(&waterTime{start: start, end: start.Add(30 * time.Minute), zones: []string{"a"}, id: wtm.nextID()})
+	ct.run()
+	sub := ct.sub
+
+	fc.AdvanceTo(start)
+	waitEvent(t, sub, startZonesEvent{})
+
+	// The slot is stopped when the 10 minutes of the day are delivered.
+	fc.WaitWaiters(1)
+	next, _ := fc.Next()
+	if want := start.Add(10 * time.Minute); next.Sub(want) < 0 || next.Sub(want) > time.Microsecond {
+		t.Errorf("next change = %v, want %v", next, want)
+	}
+	fc.AdvanceTo(next)
+	if e := waitEvent(t, sub, stopEvent{}); e != (stopEvent{stopLocal}) {
+		t.Errorf("stop signal = %v, want %v", e, stopLocal)
+	}
+	fc.WaitWaiters(1)
+	if b := wtm.Budgets()[0]; b.remaining != 0 || math.Abs(b.used-10) > budgetEpsilon {
+		t.Errorf("budget = used %v, remaining %v, want 10 and 0", b.used, b.remaining)
+	}
+
+	ct.stop()
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// event is a message of the bus.
+type event interface {
+	// safety events are never dropped, also if the subscriber is slow.
+	safety() bool
+}
+
+// startZonesEvent asks the remote robots to open the zones: it starts the cycle.
+type startZonesEvent struct{ zones []string }
+
+// changeZonesEvent asks the remote robots to change the zones open while the system is running.
+type changeZonesEvent struct{ zones []string }
+
+// startPumpEvent asks to start the pump, after the valves confirmed.
+type startPumpEvent struct{}
+
+// startSensorEvent asks to start reading the water sensor, after the pump started.
+type startSensorEvent struct{}
+
+// stopEvent stops the workers, see StopSignal.
+type stopEvent struct{ signal StopSignal }
+
+// remoteFailureEvent is published when the remote robots fail to open or change the valves:
+// the slots running are stopped.
+type remoteFailureEvent struct{ err error }
+
+func (startZonesEvent) safety() bool     { return false }
+func (changeZonesEvent) safety() bool    { return false }
+func (startPumpEvent) safety() bool      { return false }
+func (startSensorEvent) safety() bool    { return false }
+func (stopEvent) safety() bool           { return true }
+func (remoteFailureEvent) safety() bool  { return true }
+
+// defaultBufferSize is the number of not safety events a subscriber can keep.
+const defaultBufferSize = 16
+
+// bus delivers the events to all the subscribers.
+// Publish never blocks: every subscriber has its own queue.
+type bus struct {
+	subs map[*subscription]bool
+	sync.Mutex
+}
+
+// newBus returns an empty bus.
+func newBus() *bus {
+	return &bus{subs: make(map[*subscription]bool)}
+}
+
+// subscription receives the events of a bus on C, in the order they are published.
+// It keeps up to size not safety events: when it's full the new ones are dropped.
+// Safety events are always kept.
+type subscription struct {
+	// C delivers the events, it's closed by unsubscribe.
+	// It's nil for a polled subscription.
+	C <-chan event
+
+	name    string
+	size    int
+	queue   []event
+	normal  int // not safety events in the queue
+	dropped int
+	c       chan event
+	wake    chan struct{}
+	done    chan struct{}
+	mu      sync.Mutex
+}
+
+// subscribe returns a new subscription, name is used in the logs.
+// size is the number of not safety events kept, defaultBufferSize if it's not positive.
+// It's thread safe.
+func (b *bus) subscribe(name string, size int) *subscription {
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	c := make(chan event)
+	s := &subscription{
+		C:    c,
+		name: name,
+		size: size,
+		c:    c,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	go s.deliver()
+
+	b.Lock()
+	defer b.Unlock()
+	b.subs[s] = true
+	return s
+}
+
+// subscribePolled returns a new subscription without C: its events are taken with poll.
+// It lets a single goroutine run many subscribers, as the simulation does.
+// It's thread safe.
+func (b *bus) subscribePolled(name string, size int) *subscription {
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	s := &subscription{
+		name: name,
+		size: size,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	b.subs[s] = true
+	return s
+}
+
+// unsubscribe removes the subscription and closes its channel.
+// Events not delivered yet are lost.
+// It's thread safe.
+func (b *bus) unsubscribe(s *subscription) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.subs[s] {
+		delete(b.subs, s)
+		close(s.done)
+	}
+}
+
+// publish sends e to all the subscribers, without waiting them.
+// It's thread safe.
+func (b *bus) publish(e event) {
+	b.Lock()
+	defer b.Unlock()
+
+	for s := range b.subs {
+		s.push(e)
+	}
+}
+
+// push adds e to the queue of the subscription.
+func (s *subscription) push(e event) {
+	s.mu.Lock()
+	if !e.safety() {
+		if s.normal >= s.size {
+			s.dropped++
+			s.mu.Unlock()
+			log.Printf("subscriber '%s' is slow, event %T dropped (%d so far)", s.name, e, s.dropped)
+			return
+		}
+		s.normal++
+	}
+	s.queue = append(s.queue, e)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default: // The subscription has already to look at the queue.
+	}
+}
+
+// deliver moves the events from the queue to C, until unsubscribe.
+func (s *subscription) deliver() {
+	defer close(s.c)
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			s.mu.Unlock()
+			select {
+			case <-s.wake:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		e := s.queue[0]
+		s.mu.Unlock()
+
+		select {
+		case s.c <- e:
+		case <-s.done:
+			return
+		}
+
+		s.mu.Lock()
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		if !e.safety() {
+			s.normal--
+		}
+		s.mu.Unlock()
+	}
+}
+
+// poll takes the next event of a polled subscription, false if the queue is empty.
+// It's thread safe.
+func (s *subscription) poll() (event, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queue) == 0 {
+		return nil, false
+	}
+	e := s.queue[0]
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+	if !e.safety() {
+		s.normal--
+	}
+	return e, true
+}
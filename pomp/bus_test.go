@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testEvent is an event of the bus tests, a safety one if safe is true.
+type testEvent struct {
+	n    int
+	safe bool
+}
+
+func (e testEvent) safety() bool { return e.safe }
+
+func Test_bus_publish(t *testing.T) {
+	b := newBus()
+	first := b.subscribe("first", 0)
+	second := b.subscribe("second", 0)
+
+	b.publish(startZonesEvent{[]string{"a"}})
+	b.publish(startPumpEvent{})
+	b.publish(stopEvent{stopLocal})
+
+	// Every subscriber receives all the events, in order.
+	for _, s := range []*subscription{first, second} {
+		for i, want := range []string{"main.startZonesEvent", "main.startPumpEvent", "main.stopEvent"} {
+			select {
+			case e := <-s.C:
+				if got := fmt.Sprintf("%T", e); got != want {
+					t.Errorf("%s event #%d = %s, want %s", s.name, i, got, want)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%s timeout waiting event #%d", s.name, i)
+			}
+		}
+	}
+
+	b.unsubscribe(first)
+	b.unsubscribe(second)
+	if _, ok := <-first.C; ok {
+		t.Errorf("channel open after unsubscribe")
+	}
+}
+
+func Test_bus_slowSubscriber(t *testing.T) {
+	b := newBus()
+	s := b.subscribe("slow", 2)
+	defer b.unsubscribe(s)
+
+	// Nobody reads: publish doesn't block, the normal events over the buffer are dropped
+	// but the safety events are always kept.
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			b.publish(testEvent{n: i})
+		}
+		b.publish(stopEvent{stopFault})
+		b.publish(testEvent{n: 10, safe: true})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("publish blocked on a slow subscriber")
+	}
+
+	var got []event
+	for len(got) < 4 {
+		select {
+		case e := <-s.C:
+			got = append(got, e)
+		case <-time.After(time.Second):
+			t.Fatalf("timeout, received %v", got)
+		}
+	}
+	want := []event{
+		testEvent{n: 0},
+		testEvent{n: 1},
+		stopEvent{stopFault},
+		testEvent{n: 10, safe: true},
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event #%d = %v, want %v", i, got[i], want[i])
+		}
+	}
+	if s.dropped != 3 {
+		t.Errorf("dropped = %d, want 3", s.dropped)
+	}
+}
+
+func Test_bus_poll(t *testing.T) {
+	b := newBus()
+	s := b.subscribePolled("polled", 1)
+	defer b.unsubscribe(s)
+
+	if e, ok := s.poll(); ok {
+		t.Fatalf("poll() = %v on an empty queue", e)
+	}
+
+	// The events are taken in order, with the same limits of the subscriptions.
+	b.publish(testEvent{n: 0})
+	b.publish(testEvent{n: 1})
+	b.publish(stopEvent{stopFault})
+	want := []event{testEvent{n: 0}, stopEvent{stopFault}}
+	for i := range want {
+		if e, ok := s.poll(); !ok || e != want[i] {
+			t.Errorf("poll() #%d = %v, %v, want %v", i, e, ok, want[i])
+		}
+	}
+	if e, ok := s.poll(); ok {
+		t.Errorf("poll() = %v after the last event", e)
+	}
+}
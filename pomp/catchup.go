@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// catchUpPolicy tells what to do with a waterTime missed or interrupted while pomp was down.
+type catchUpPolicy string
+
+const (
+	// catchUpSkip loses the water missed, a waterTime still running goes on until its end.
+	catchUpSkip catchUpPolicy = "skip"
+	// catchUpRemaining runs the water not delivered at the next free time.
+	catchUpRemaining catchUpPolicy = "remaining"
+	// catchUpFull runs the whole waterTime again at the next free time.
+	catchUpFull catchUpPolicy = "full"
+)
+
+// defaultHeartbeat is how often pomp saves that the schedule is running while a waterTime is active.
+const defaultHeartbeat = time.Minute
+
+// parseCatchUpPolicy returns the catchUpPolicy of s.
+func parseCatchUpPolicy(s string) (catchUpPolicy, error) {
+	switch p := catchUpPolicy(s); p {
+	case catchUpSkip, catchUpRemaining, catchUpFull:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown catch-up policy '%s', must be %s, %s or %s", s, catchUpSkip, catchUpRemaining, catchUpFull)
+}
+
+// withCatchUp uses policy for the waterTimes without their own catch-up policy.
+func withCatchUp(policy catchUpPolicy) managerOption {
+	return func(wtm *waterTimeManager) {
+		wtm.catchUp = policy
+	}
+}
+
+// withHeartbeat saves every d that the schedule is running while a waterTime is active,
+// so after a restart the water missed is known within d.
+func withHeartbeat(d time.Duration) managerOption {
+	return func(wtm *waterTimeManager) {
+		wtm.heartbeat = d
+	}
+}
+
+// withHistory records in h the decisions of the manager.
+func withHistory(h *runHistory) managerOption {
+	return func(wtm *waterTimeManager) {
+		wtm.history = h
+	}
+}
+
+// alive records the water delivered by zones open since last for the budgets,
+// and that the schedule was running at time now.
+// Only the running state is saved, not the whole schedule.
+// It's thread safe.
+func (wtm *waterTimeManager) alive(zones []string, last, now time.Time) {
+	wtm.Lock()
+	defer wtm.Unlock()
+
+	wtm.addUsage(zones, last, now)
+	wtm.aliveAt = now
+	wtm.aliveZones = append([]string(nil), zones...)
+	wtm.persistState()
+}
+
+// catchUpMissed applies the catch-up policies to the waterTimes which should have watered
+// between the last time the schedule was running and now.
+// A moved waterTime keeps its id, the ones which can't be moved are left as they are.
+// Every decision is recorded in the history.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) catchUpMissed(now time.Time) {
+	if wtm.aliveAt.IsZero() || !now.After(wtm.aliveAt) {
+		return
+	}
+
+	missed := make([]*waterTime, 0)
+	for _, t := range wtm.times {
+		if t.runTimeIn(wtm.aliveAt, now) > 0 {
+			missed = append(missed, t)
+		}
+	}
+
+	for _, t := range missed {
+		policy := t.catchUp
+		if policy == "" {
+			policy = wtm.catchUp
+		}
+		rec := &runRecord{At: now, Slot: t.id, Zones: t.zones, PlannedStart: t.start, PlannedEnd: t.end, Outcome: outcomeMissed, SensorMin: -1, SensorMax: -1}
+
+		d := t.runTime()
+		if policy == catchUpRemaining {
+			d -= t.runTimeIn(t.start, wtm.aliveAt)
+		}
+		if policy == catchUpSkip {
+			rec.Reason = fmt.Sprintf("%v of water missed while the schedule was down, skipped", t.runTimeIn(wtm.aliveAt, now))
+		} else if moved, err := wtm.nextFree(t, d, now, 0); err != nil {
+			rec.Reason = fmt.Sprintf("unable to catch up %v (%s): %v", d, policy, err)
+		} else {
+			wtm.times[wtm.find(t.id)] = moved
+			sort.Slice(wtm.times, func(i, j int) bool { return wtm.times[i].start.Before(wtm.times[j].start) })
+			rec.Outcome = outcomeRescheduled
+			rec.Reason = fmt.Sprintf("catch up %v (%s), now %v", d, policy, moved)
+		}
+		log.Printf("slot %v %s: %s", t, rec.Outcome, rec.Reason)
+		wtm.history.record(rec)
+	}
+}
+
+// nextFree returns a copy of t which runs for d at the first time after now
+// outside the blackouts and without collisions, within the horizon of the manager from the clock:
+// after it the rules are not expanded yet.
+// The pump rests at least gap before and after the run, 0 means no rest.
+// Only the starts at now, at the end of a waterTime (also plus the gap) or of a blackout are tried.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) nextFree(t *waterTime, d time.Duration, now time.Time, gap time.Duration) (*waterTime, error) {
+	wall := d
+	if t.soak != nil {
+		wall = t.soak.wallTime(d)
+	}
+
+	limit := wtm.clock.Now().Add(wtm.horizon)
+	starts := []time.Time{now}
+	for _, o := range wtm.times {
+		if o.id != t.id && o.end.After(now) {
+			starts = append(starts, o.end)
+		}
+		if rested := o.end.Add(gap); gap > 0 && o.id != t.id && rested.After(now) {
+			starts = append(starts, rested)
+		}
+	}
+	for _, w := range wtm.blackoutWindows(now, limit) {
+		starts = append(starts, w.end)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	for _, start := range starts {
+		if start.After(limit) {
+			break
+		}
+		moved := &waterTime{start: start, end: start.Add(wall), id: t.id, zones: t.zones, soak: t.soak, catchUp: t.catchUp}
+		// The blackouts must not cut it: with blackoutClip it's rejected if it changes.
+		if err := wtm.applyBlackouts(moved, false); err != nil || moved.end.Sub(moved.start) != wall {
+			continue
+		}
+		if wtm.checkRange(moved) == nil && wtm.checkGap(moved, gap) == nil {
+			return moved, nil
+		}
+	}
+	return nil, &conflictError{msg: fmt.Sprintf("no free time for %v in the next %v", wall, wtm.horizon)}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_newWaterTime_catchUp(t *testing.T) {
+	tests := []struct {
+		row     string
+		want    catchUpPolicy
+		wantErr bool
+	}{
+		{"2018-08-21 06:00:00 - 2018-08-21 06:20:00", "", false},
+		{"2018-08-21 06:00:00 - 2018-08-21 06:20:00 catchup full", catchUpFull, false},
+		{"2018-08-21 06:00:00 - 2018-08-21 06:20:00 front soak 8m/5m catchup remaining", catchUpRemaining, false},
+		{"2018-08-21 06:00:00 - 2018-08-21 06:20:00 catchup later", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.row, func(t *testing.T) {
+			got, err := newWaterTime(tt.row)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newWaterTime() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.catchUp != tt.want {
+				t.Errorf("catchUp = %v, want %v", got.catchUp, tt.want)
+			}
+		})
+	}
+}
+
+func Test_waterTimeManager_catchUpMissed(t *testing.T) {
+
+	at := func(h, m int) time.Time { return time.Date(2018, 8, 21, h, m, 0, 0, siteTZ.loc) }
+	dir := t.TempDir()
+	store := newScheduleStore(filepath.Join(dir, "schedule"))
+
+	// pomp goes down at 06:05, during the first slot.
+	before := newWaterTimeManager(withStore(store), withClock(newFakeClock(at(5, 0))))
+	for _, wt := range []*waterTime{
+		{start: at(6, 0), end: at(6, 20), zones: []string{"a"}, catchUp: catchUpRemaining},
+		{start: at(6, 30), end: at(6, 40), zones: []string{"b"}, catchUp: catchUpFull},
+		{start: at(6, 40), end: at(6, 50), zones: []string{"a"}},
+		{start: at(6, 50), end: at(7, 30), zones: []string{"c"}, catchUp: catchUpFull},
+		{start: at(8, 0), end: at(8, 10), zones: []string{"a"}, catchUp: catchUpFull},
+	} {
+		wt.id = before.nextID()
+		before.insert(wt)
+	}
+	before.persist()
+	saved, err := os.ReadFile(store.path)
+	if err != nil {
+		t.Fatalf("unable to read the schedule = %v", err)
+	}
+	before.alive([]string{"a"}, at(6, 0), at(6, 5))
+	// The heartbeat saves only the running state.
+	if after, _ := os.ReadFile(store.path); string(after) != string(saved) {
+		t.Errorf("alive() saved the schedule:\n%s\nwant\n%s", after, saved)
+	}
+
+	// It's back at 07:00.
+	history := newRunHistory(filepath.Join(dir, "history"))
+	wtm := newWaterTimeManager(withStore(store), withClock(newFakeClock(at(7, 0))), withHistory(history))
+	if err := wtm.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	want := map[uint64][2]time.Time{
+		1: {at(7, 0), at(7, 15)}, // The 15 minutes not delivered.
+		2: {at(7, 0), at(7, 10)}, // The whole slot.
+		4: {at(7, 0), at(7, 40)}, // The whole slot again, also if it's still running.
+		5: {at(8, 0), at(8, 10)}, // Not missed.
+	}
+	got := wtm.List()
+	if len(got) != len(want) {
+		t.Fatalf("List() = %v, want %d times", got, len(want))
+	}
+	for _, wt := range got {
+		if w, ok := want[wt.id]; !ok || !wt.start.Equal(w[0]) || !wt.end.Equal(w[1]) {
+			t.Errorf("time %v, want %v - %v", &wt, w[0], w[1])
+		}
+	}
+
+	// Every decision is in the history, also after a restart.
+	loaded := newRunHistory(filepath.Join(dir, "history"))
+	if err := loaded.load(); err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	records := loaded.Records(at(0, 0), at(23, 0))
+	wantOutcomes := map[uint64]runOutcome{1: outcomeRescheduled, 2: outcomeRescheduled, 3: outcomeMissed, 4: outcomeRescheduled}
+	if len(records) != len(wantOutcomes) {
+		t.Fatalf("Records() = %+v, want %d records", records, len(wantOutcomes))
+	}
+	for _, r := range records {
+		if r.Outcome != wantOutcomes[r.Slot] {
+			t.Errorf("slot %d outcome = %s, want %s (%s)", r.Slot, r.Outcome, wantOutcomes[r.Slot], r.Reason)
+		}
+	}
+}
+
+func Test_waterTimeManager_nextFree(t *testing.T) {
+
+	start := time.Date(2018, 8, 21, 6, 0, 0, 0, siteTZ.loc)
+	wtm := newWaterTimeManager(withClock(newFakeClock(start)))
+	wtm.horizon = 24 * time.Hour
+	wtm.insert(&waterTime{start: start.Add(20 * time.Hour), end: start.Add(30 * time.Hour), zones: []string{"a"}, id: wtm.nextID()})
+
+	// The search starts later than the clock, but it stops at the horizon from the clock.
+	if got, err := wtm.nextFree(&waterTime{zones: []string{"a"}}, 2*time.Hour, start.Add(19*time.Hour), 0); err == nil {
+		t.Errorf("nextFree() = %v, want no free time within the horizon", got)
+	}
+	if got, err := wtm.nextFree(&waterTime{zones: []string{"b"}}, time.Hour, start.Add(19*time.Hour), 0); err != nil || !got.start.Equal(start.Add(19*time.Hour)) {
+		t.Errorf("nextFree() = %v, %v, want start %v", got, err, start.Add(19*time.Hour))
+	}
+}
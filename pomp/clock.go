@@ -0,0 +1,166 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// clock is the source of time of the scheduler.
+// It makes possible to run the scheduler with a fake time.
+type clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) clockTimer
+}
+
+// clockTimer is a timer returned by clock.AfterFunc.
+type clockTimer interface {
+	Stop() bool
+}
+
+// realClock is the clock of the system.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+func (realClock) AfterFunc(d time.Duration, f func()) clockTimer {
+	return time.AfterFunc(d, f)
+}
+
+// scaledClock is a clock which runs speed times faster than the system clock.
+// It's used to fast-forward the schedule on the real hardware.
+type scaledClock struct {
+	start time.Time
+	speed float64
+}
+
+// newScaledClock returns a scaledClock which starts now.
+func newScaledClock(speed float64) *scaledClock {
+	return &scaledClock{start: time.Now(), speed: speed}
+}
+
+func (c *scaledClock) Now() time.Time {
+	return c.start.Add(time.Duration(float64(time.Since(c.start)) * c.speed))
+}
+
+func (c *scaledClock) AfterFunc(d time.Duration, f func()) clockTimer {
+	return time.AfterFunc(c.real(d), f)
+}
+
+// real returns the system duration of a scaled duration.
+func (c *scaledClock) real(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / c.speed)
+}
+
+// fakeClock is a clock which moves only when Advance is called.
+// It lets tests run whole irrigation days in milliseconds.
+type fakeClock struct {
+	now     time.Time
+	waiters []*fakeTimer
+	// changed is broadcast every time a waiter is added.
+	changed *sync.Cond
+	sync.Mutex
+}
+
+// fakeTimer is a waiter of the fakeClock.
+type fakeTimer struct {
+	clock *fakeClock
+	when  time.Time
+	f     func()
+}
+
+// newFakeClock returns a fakeClock which starts at now.
+func newFakeClock(now time.Time) *fakeClock {
+	c := &fakeClock{now: now}
+	c.changed = sync.NewCond(c)
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) clockTimer {
+	t := &fakeTimer{clock: c, f: f}
+	c.add(t, d)
+	return t
+}
+
+// add adds a waiter which expires after d.
+func (c *fakeClock) add(t *fakeTimer, d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	t.when = c.now.Add(d)
+	c.waiters = append(c.waiters, t)
+	sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].when.Before(c.waiters[j].when) })
+	c.changed.Broadcast()
+}
+
+// Stop removes the timer from the clock.
+// It returns false if the timer already expired or has been stopped.
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.Lock()
+	defer c.Unlock()
+
+	for i, w := range c.waiters {
+		if w == t {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock forward by d, firing in order all the waiters expired.
+// Functions of AfterFunc are called synchronously.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.AdvanceTo(c.Now().Add(d))
+}
+
+// AdvanceTo moves the clock forward until t, firing in order all the waiters expired.
+func (c *fakeClock) AdvanceTo(t time.Time) {
+	for {
+		c.Lock()
+		if len(c.waiters) == 0 || c.waiters[0].when.After(t) {
+			if t.After(c.now) {
+				c.now = t
+			}
+			c.Unlock()
+			return
+		}
+
+		w := c.waiters[0]
+		c.waiters = c.waiters[1:]
+		if w.when.After(c.now) {
+			c.now = w.when
+		}
+		c.Unlock()
+
+		w.f()
+	}
+}
+
+// Next returns the time of the first waiter, false if there are no waiters.
+func (c *fakeClock) Next() (time.Time, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.waiters) == 0 {
+		return time.Time{}, false
+	}
+	return c.waiters[0].when, true
+}
+
+// WaitWaiters blocks until the clock has at least n waiters.
+// It's useful to know when goroutines are waiting the clock.
+func (c *fakeClock) WaitWaiters(n int) {
+	c.Lock()
+	defer c.Unlock()
+
+	for len(c.waiters) < n {
+		c.changed.Wait()
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// readConsole reads the commands from in and applies them to the scheduler.
+// It returns when in is closed.
+func readConsole(scheduler *waterTimeManager, status *systemStatus, events *bus, in io.Reader) {
+	buff := bufio.NewReader(in)
+	for {
+		fmt.Printf("Inserisci data inizio e fine separate da ' - ' (h per l'elenco dei comandi): ")
+		text, err := buff.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+
+		cmd, args := text, ""
+		if i := strings.Index(text, " "); i > 0 {
+			cmd, args = text[:i], strings.TrimSpace(text[i+1:])
+		}
+
+		switch cmd {
+
+		// Command which prints the available commands
+		case "h":
+			fmt.Println("Comandi:")
+			fmt.Println("  <inizio> - <fine> [zone]   aggiunge una schedulazione (es. 2018-08-21 10:49:00 - 2018-08-21 11:00:00 front,back)")
+			fmt.Println("    [soak <acqua>/<pausa>]   divide l'irrigazione in impulsi (es. ... front soak 8m/15m)")
+			fmt.Println("    [catchup <politica>]     se pomp è spento: skip, remaining (il resto) o full (tutta) al primo orario libero")
+			fmt.Println("  p                          stampa la schedulazione corrente")
+			fmt.Println("  s                          stampa lo stato di pompa, valvole e sensore")
+			fmt.Println("  r <regola>                 aggiunge una regola ricorrente (es. mon/wed/fri at 19:30 for 15m zones front)")
+			fmt.Println("                             l'ora può essere relativa al sole: sunrise, sunset, dawn, dusk (es. every day at sunrise-30m for 20m)")
+			fmt.Println("  c <id>                     cancella una schedulazione")
+			fmt.Println("  u <id> <inizio> - <fine>   modifica una schedulazione")
+			fmt.Println("  a                          conferma l'errore attivo e riprende le schedulazioni")
+			fmt.Println("  t                          stampa le ultime transizioni del ciclo di irrigazione")
+			fmt.Println("  m <durata> [zone] [shift]  irriga subito (es. m 10m 2), le schedulazioni in conflitto sono tagliate o spostate (shift)")
+			fmt.Println("  f <durata> [zone]          aggiunge una schedulazione al primo orario libero (es. f 20m front)")
+			fmt.Println("  x                          ferma l'irrigazione in corso, le schedulazioni future restano")
+			fmt.Println("  z [giorni] [motivo]        sospende le schedulazioni, per alcuni giorni o fino a 'g' (es. z 3 pioggia)")
+			fmt.Println("  g                          riprende le schedulazioni sospese")
+			fmt.Println("  b                          stampa l'acqua rimasta nei budget giornalieri e settimanali")
+			fmt.Println("  l [da] [a]                 stampa lo storico delle irrigazioni, dell'ultima settimana o tra due giorni (es. l 2018-08-21 2018-08-22)")
+
+		// Command wich prints the current schedule status
+		case "p":
+			fmt.Println("Stato schedulazione:")
+
+			for _, s := range scheduler.PrintStatus() {
+				var stato string
+				if s.started {
+					stato = "in corso"
+					if s.soak != nil && s.soaking {
+						stato = fmt.Sprintf("in pausa dopo l'impulso %d di %d", s.pulse, s.pulses)
+					} else if s.soak != nil {
+						stato = fmt.Sprintf("impulso %d di %d", s.pulse, s.pulses)
+					}
+				} else {
+					stato = fmt.Sprintf("inizierà tra %v", s.willStart)
+				}
+				fmt.Printf("[%d] Inizio %s, Fine %s, Zone %s: %s\n",
+					s.id,
+					s.start.Format("02/01/2006 15:04"),
+					s.end.Format("02/01/2006 15:04"),
+					zonesString(s.zones),
+					stato)
+			}
+			for _, r := range scheduler.Rules() {
+				fmt.Printf("Regola: %s\n", r)
+			}
+			if p := scheduler.Paused(); p != nil {
+				fmt.Printf("Schedulazioni sospese: %v\n", p)
+			}
+
+		// Command which prints the remaining water of the budgets
+		case "b":
+			budgets := scheduler.Budgets()
+			if len(budgets) == 0 {
+				fmt.Println("nessun budget configurato")
+			}
+			for _, b := range budgets {
+				periodo, zone := "giornaliero", b.budget.zone
+				if b.budget.period == budgetWeekly {
+					periodo = "settimanale"
+				}
+				if zone == "" {
+					zone = "tutte le zone"
+				}
+				fmt.Printf("Budget %s di %s: restano %.1f di %.1f %s (usati %.1f, previsti %.1f) fino al %s\n",
+					periodo, zone, b.remaining, b.budget.limit, b.budget.unit(), b.used, b.planned, b.to.Format("02/01/2006 15:04"))
+			}
+
+		// Command which prints the history, like "l 2018-08-21 2018-08-22"
+		case "l":
+			days := strings.Fields(args)
+			days = append(days, "", "")
+			from, to, err := historyRange(days[0], days[1], scheduler.clock.Now())
+			if err != nil {
+				fmt.Printf("unable to read the history: %v, skip...\n", err)
+				continue
+			}
+			for _, r := range scheduler.History(from, to) {
+				fmt.Printf("[%d] %s - %s, Zone %s: %s", r.Slot, r.PlannedStart.Format("02/01/2006 15:04"), r.PlannedEnd.Format("15:04"), zonesString(r.Zones), r.Outcome)
+				if r.StopReason != "" {
+					fmt.Printf(" (%s)", r.StopReason)
+				}
+				if !r.Start.IsZero() {
+					fmt.Printf(", irrigato %s - %s, pompa accesa %v", r.Start.Format("15:04:05"), r.End.Format("15:04:05"), r.PumpOn.Round(time.Second))
+				}
+				if r.SensorMin >= 0 {
+					fmt.Printf(", sensore %d-%d", r.SensorMin, r.SensorMax)
+				}
+				if r.Reason != "" {
+					fmt.Printf(": %s", r.Reason)
+				}
+				fmt.Println()
+			}
+
+		// Command which prints the status of the hardware
+		case "s":
+			state := status.snapshot()
+			cycleState, since := status.cycle.current()
+			fmt.Printf("Ciclo: %s (dal %s)\n", cycleState, since.Format("02/01/2006 15:04:05"))
+			pompa := "spenta"
+			if state.pumpOn {
+				pompa = "accesa"
+			}
+			fmt.Printf("Pompa: %s (dal %s)\n", pompa, state.pumpChanged.Format("02/01/2006 15:04:05"))
+			fmt.Printf("Zone aperte: %v\n", state.zones)
+			fmt.Printf("Sensore: %d, livello %.1f%% (letto il %s)\n", state.sensorValue, state.sensorLevel, state.sensorRead.Format("02/01/2006 15:04:05"))
+			if state.waterLow {
+				fmt.Println("Livello dell'acqua basso!")
+			}
+			if f := state.fault; f != nil {
+				fmt.Printf("ERRORE ATTIVO: %s (%s), valore %d (il %s), conferma con 'a'\n", f.code, f.reason, f.value, f.at.Format("02/01/2006 15:04:05"))
+			}
+			if f := state.lastFault; f != nil {
+				fmt.Printf("Ultimo errore: %s, valore %d (il %s)\n", f.reason, f.value, f.at.Format("02/01/2006 15:04:05"))
+			}
+
+		// Command which prints the history of the cycle
+		case "t":
+			for _, t := range status.cycle.transitions() {
+				fmt.Printf("%s: %s -> %s (%s)\n", t.at.Format("02/01/2006 15:04:05"), t.from, t.to, t.reason)
+			}
+
+		// Command which adds a recurring rule, like "r mon/wed/fri at 19:30 for 15m"
+		case "r":
+			r, err := parseWaterRule(args, scheduler.clock.Now().In(siteTZ.loc))
+			if err != nil {
+				fmt.Printf("unable to parse rule: %v, skip...\n", err)
+				continue
+			}
+			added, err := scheduler.AddRule(r)
+			if err != nil {
+				fmt.Printf("unable to add rule: %v, skip...\n", err)
+				continue
+			}
+			fmt.Printf("rule '%s' added %d times\n", r, added)
+
+		// Command which waters now, like "m 10m front,back shift"
+		case "m":
+			fields := strings.Fields(args)
+			if len(fields) == 0 || len(fields) > 3 {
+				fmt.Println("missing duration, skip...")
+				continue
+			}
+			d, err := time.ParseDuration(fields[0])
+			if err != nil {
+				fmt.Printf("unable to parse duration: %v, skip...\n", err)
+				continue
+			}
+			var zones []string
+			policy := policyPreempt
+			for _, f := range fields[1:] {
+				if p, err := parseRunPolicy(f); err == nil {
+					policy = p
+					continue
+				}
+				if zones, err = parseZones(f); err != nil {
+					fmt.Printf("unable to parse zones: %v, skip...\n", err)
+					break
+				}
+			}
+			if err != nil {
+				continue
+			}
+			if f := status.activeFault(); f != nil {
+				fmt.Printf("errore '%s' attivo, conferma con 'a' prima di irrigare\n", f.code)
+				continue
+			}
+			wt, err := scheduler.RunNow(zones, d, policy)
+			if err != nil {
+				fmt.Printf("unable to run now: %v\n", err)
+				continue
+			}
+			fmt.Printf("irrigazione manuale %v avviata\n", wt)
+
+		// Command which schedules at the next free time, like "f 20m front,back"
+		case "f":
+			fields := strings.Fields(args)
+			if len(fields) == 0 || len(fields) > 2 {
+				fmt.Println("missing duration, skip...")
+				continue
+			}
+			d, err := time.ParseDuration(fields[0])
+			if err != nil {
+				fmt.Printf("unable to parse duration: %v, skip...\n", err)
+				continue
+			}
+			var zones []string
+			if len(fields) == 2 {
+				if zones, err = parseZones(fields[1]); err != nil {
+					fmt.Printf("unable to parse zones: %v, skip...\n", err)
+					continue
+				}
+			}
+			wt, err := scheduler.AppendFree(zones, d, time.Time{})
+			if err != nil {
+				fmt.Printf("unable to find a free time: %v\n", err)
+				continue
+			}
+			fmt.Printf("schedulazione %d aggiunta: %s - %s\n", wt.id, wt.start.Format("02/01/2006 15:04"), wt.end.Format("02/01/2006 15:04"))
+
+		// Command which stops the running slots
+		case "x":
+			stopped, err := scheduler.StopNow()
+			if err != nil {
+				fmt.Printf("unable to stop: %v\n", err)
+				continue
+			}
+			fmt.Printf("%d schedulazioni fermate\n", stopped)
+
+		// Command which pauses the schedule, like "z 3 pioggia"
+		case "z":
+			var until time.Time
+			reason := "manual"
+			fields := strings.SplitN(args, " ", 2)
+			if days, err := strconv.Atoi(fields[0]); err == nil {
+				if days <= 0 {
+					fmt.Println("days must be positive, skip...")
+					continue
+				}
+				until = scheduler.clock.Now().In(siteTZ.loc).AddDate(0, 0, days)
+				args = ""
+				if len(fields) == 2 {
+					args = strings.TrimSpace(fields[1])
+				}
+			}
+			if args != "" {
+				reason = args
+			}
+			if err := scheduler.Pause(until, reason); err != nil {
+				fmt.Printf("unable to pause: %v\n", err)
+				continue
+			}
+			fmt.Printf("schedulazioni sospese: %v\n", scheduler.Paused())
+
+		// Command which resumes the schedule
+		case "g":
+			if err := scheduler.Resume(); err != nil {
+				fmt.Printf("unable to resume: %v\n", err)
+				continue
+			}
+			fmt.Println("schedulazioni riprese")
+
+		// Command which acknowledges the active fault
+		case "a":
+			f, err := ackFault(events, status)
+			if err != nil {
+				fmt.Printf("unable to acknowledge: %v\n", err)
+				continue
+			}
+			fmt.Printf("errore '%s' confermato, le schedulazioni riprendono\n", f.code)
+
+		// Command which cancels a time, like "c 3"
+		case "c":
+			id, err := strconv.ParseUint(args, 10, 64)
+			if err != nil {
+				fmt.Printf("unable to parse id: %v, skip...\n", err)
+				continue
+			}
+			if err = scheduler.Remove(id); err != nil {
+				fmt.Printf("unable to cancel: %v\n", err)
+			}
+
+		// Command which updates a time, like "u 3 2018-08-21 10:49:00 - 2018-08-21 11:00:00"
+		case "u":
+			fields := strings.SplitN(args, " ", 2)
+			if len(fields) != 2 {
+				fmt.Println("missing id or time, skip...")
+				continue
+			}
+			id, err := strconv.ParseUint(fields[0], 10, 64)
+			if err != nil {
+				fmt.Printf("unable to parse id: %v, skip...\n", err)
+				continue
+			}
+			t, err := newWaterTime(fields[1])
+			if err != nil {
+				fmt.Printf("unable to parse time: %v, skip...\n", err)
+				continue
+			}
+			if err = scheduler.Update(id, t); err != nil {
+				fmt.Printf("unable to update: %v\n", err)
+			}
+
+		default:
+			t, err := newWaterTime(text)
+			if err != nil {
+				fmt.Printf("unable to parse time: %v, skip...\n", err)
+				continue
+			}
+			_, err = scheduler.Append(t)
+			if err != nil {
+				fmt.Printf("this time collide with other times: %v\n", err)
+				continue
+			}
+		}
+	}
+}
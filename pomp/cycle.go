@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// cycleState is a state of the irrigation cycle.
+type cycleState string
+
+const (
+	// stateIdle: pump off, valves closed.
+	stateIdle cycleState = "idle"
+	// stateOpeningValves: the remote robots are opening the valves.
+	stateOpeningValves cycleState = "opening_valves"
+	// statePumpStarting: the valves confirmed, the pump is starting.
+	statePumpStarting cycleState = "pump_starting"
+	// stateRunning: pump on, valves open.
+	stateRunning cycleState = "running"
+	// stateStopping: the pump is stopping, then the valves are closed.
+	// After an acknowledged fault the pump is already off: only the valves are closed.
+	stateStopping cycleState = "stopping"
+	// stateFault: pump off, valves open, until the fault is acknowledged and the valves are closed.
+	stateFault cycleState = "fault"
+)
+
+// cycleTransitions are the valid transitions of the cycle.
+// A fault can happen in every state, except during another fault.
+var cycleTransitions = map[cycleState][]cycleState{
+	stateIdle:          {stateOpeningValves, stateFault},
+	stateOpeningValves: {statePumpStarting, stateStopping, stateIdle, stateFault},
+	statePumpStarting:  {stateRunning, stateStopping, stateFault},
+	stateRunning:       {stateStopping, stateFault},
+	stateStopping:      {stateIdle, stateFault},
+	stateFault:         {stateStopping},
+}
+
+// maxCycleHistory is the number of transitions kept in the history.
+const maxCycleHistory = 100
+
+// transition is a change of state of the cycle.
+type transition struct {
+	from, to cycleState
+	at       time.Time
+	reason   string
+}
+
+// invalidTransitionError is returned when a transition is not allowed.
+type invalidTransitionError struct {
+	from, to cycleState
+}
+
+func (e *invalidTransitionError) Error() string {
+	return fmt.Sprintf("invalid transition from '%s' to '%s'", e.from, e.to)
+}
+
+// cycle is the state machine of the irrigation cycle:
+// the workers ask it to move, it rejects the invalid transitions.
+type cycle struct {
+	state   cycleState
+	since   time.Time
+	history []transition
+	// clock gives the time of the transitions.
+	clock clock
+	sync.RWMutex
+}
+
+// newCycle returns a cycle in stateIdle, which reads the time from clk.
+func newCycle(clk clock) *cycle {
+	return &cycle{state: stateIdle, since: clk.Now(), clock: clk}
+}
+
+// to moves the cycle in the state next, if the transition is valid.
+// It's thread safe.
+func (c *cycle) to(next cycleState, reason string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.move(next, reason)
+}
+
+// toFrom moves the cycle in the state next only if the current state is from.
+// It's thread safe.
+func (c *cycle) toFrom(from, next cycleState, reason string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.state != from {
+		return &invalidTransitionError{from: c.state, to: next}
+	}
+	return c.move(next, reason)
+}
+
+// move changes the state and records the transition.
+// The caller must hold the lock.
+func (c *cycle) move(next cycleState, reason string) error {
+	valid := false
+	for _, s := range cycleTransitions[c.state] {
+		if s == next {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return &invalidTransitionError{from: c.state, to: next}
+	}
+
+	now := c.clock.Now()
+	c.history = append(c.history, transition{from: c.state, to: next, at: now, reason: reason})
+	if len(c.history) > maxCycleHistory {
+		c.history = c.history[len(c.history)-maxCycleHistory:]
+	}
+	c.state = next
+	c.since = now
+	return nil
+}
+
+// current returns the current state and when it started.
+// It's thread safe.
+func (c *cycle) current() (cycleState, time.Time) {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.state, c.since
+}
+
+// transitions returns a copy of the history, the oldest first.
+// It's thread safe.
+func (c *cycle) transitions() []transition {
+	c.RLock()
+	defer c.RUnlock()
+
+	return append([]transition(nil), c.history...)
+}
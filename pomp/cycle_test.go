@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tux-eithel/PIrrigation_system/config"
+)
+
+func Test_cycle_to(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []cycleState
+		wantErr bool
+	}{
+		{"full cycle", []cycleState{stateOpeningValves, statePumpStarting, stateRunning, stateStopping, stateIdle}, false},
+		{"valves not opened", []cycleState{stateOpeningValves, stateIdle}, false},
+		{"stopped while opening", []cycleState{stateOpeningValves, stateStopping, stateIdle}, false},
+		{"fault and ack", []cycleState{stateOpeningValves, statePumpStarting, stateRunning, stateFault, stateStopping, stateIdle}, false},
+		{"pump before valves", []cycleState{statePumpStarting}, true},
+		{"running before pump", []cycleState{stateOpeningValves, stateRunning}, true},
+		{"stop when idle", []cycleState{stateStopping}, true},
+		{"fault twice", []cycleState{stateFault, stateFault}, true},
+		{"start during fault", []cycleState{stateFault, stateOpeningValves}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCycle(realClock{})
+			var err error
+			for _, s := range tt.steps {
+				if err = c.to(s, "test"); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("want error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && len(c.transitions()) != len(tt.steps) {
+				t.Errorf("history len = %d, want %d", len(c.transitions()), len(tt.steps))
+			}
+		})
+	}
+}
+
+func Test_cycle_history(t *testing.T) {
+	c := newCycle(realClock{})
+	for i := 0; i < maxCycleHistory; i++ {
+		c.to(stateOpeningValves, fmt.Sprint(i))
+		c.to(stateIdle, fmt.Sprint(i))
+	}
+	h := c.transitions()
+	if len(h) != maxCycleHistory {
+		t.Fatalf("history len = %d, want %d", len(h), maxCycleHistory)
+	}
+	if last := h[len(h)-1]; last.to != stateIdle || last.reason != fmt.Sprint(maxCycleHistory-1) {
+		t.Errorf("last transition = %+v", last)
+	}
+}
+
+// waitState waits until the cycle is in state.
+func waitState(t *testing.T, c *cycle, state cycleState) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if s, _ := c.current(); s == state {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s, _ := c.current()
+	t.Fatalf("cycle in state '%s', want '%s'", s, state)
+}
+
+// waitZones waits until the status shows zones open.
+func waitZones(t *testing.T, status *systemStatus, zones ...string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if sameZones(status.snapshot().zones, zones) {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("zones %v, want %v", status.snapshot().zones, zones)
+}
+
+func Test_cycle_workers(t *testing.T) {
+	remote, fake := newTestRemoteRobots(t, "back", "front")
+	status := newSystemStatus(realClock{})
+	p := &simPump{}
+	cfg := config.Default().Pomp.Sensor
+	cfg.Interval = time.Millisecond
+
+	events := newBus()
+	wg := &sync.WaitGroup{}
+	wg.Add(3)
+	go workRemoteRobots("remote", remote, events, status, wg)
+	go workRelay("relay", p, events, status, wg)
+	go workMCP("mcp", newSimSensor(512), cfg, events, status, wg)
+
+	// The pump doesn't start before the valves confirm.
+	events.publish(startPumpEvent{})
+	time.Sleep(10 * time.Millisecond)
+	if p.on {
+		t.Fatalf("pump started in state idle")
+	}
+
+	events.publish(startZonesEvent{[]string{"front"}})
+	waitState(t, status.cycle, stateRunning)
+	if !p.on || !fake.open["front"] || fake.open["back"] {
+		t.Errorf("running with pump %v and valves %v", p.on, fake.open)
+	}
+
+	events.publish(stopEvent{stopLocal})
+	waitState(t, status.cycle, stateIdle)
+	if p.on || fake.open["front"] {
+		t.Errorf("idle with pump %v and valves %v", p.on, fake.open)
+	}
+
+	// After a fault the valves are open until the acknowledgement.
+	raiseFault(events, status, faultNoWater, "no water", 12)
+	waitState(t, status.cycle, stateFault)
+	waitZones(t, status, allZones)
+	if _, err := ackFault(events, status); err != nil {
+		t.Fatalf("ackFault() error = %v", err)
+	}
+	waitState(t, status.cycle, stateIdle)
+	if p.on || fake.open["front"] || fake.open["back"] || len(status.snapshot().zones) > 0 {
+		t.Errorf("idle after the acknowledgement with pump %v, valves %v and zones %v", p.on, fake.open, status.snapshot().zones)
+	}
+
+	want := []cycleState{stateOpeningValves, statePumpStarting, stateRunning, stateStopping, stateIdle, stateFault, stateStopping, stateIdle}
+	got := status.cycle.transitions()
+	if len(got) != len(want) {
+		t.Fatalf("transitions = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].to != want[i] {
+			t.Errorf("transition %d = %s, want %s", i, got[i].to, want[i])
+		}
+	}
+
+	events.publish(stopEvent{stopAndQuit})
+	wg.Wait()
+}
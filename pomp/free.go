@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// withMinGap lets the pump rest at least d between two runs.
+// waterTimes which overlap or follow each other without a pause are a single run.
+func withMinGap(d time.Duration) managerOption {
+	return func(wtm *waterTimeManager) {
+		wtm.minGap = d
+	}
+}
+
+// checkGap checks that the run of t starts at least gap after the end of the run before
+// and ends at least gap before the start of the run after.
+// It's checked only when a time is placed at the next free time: Append and the rules
+// don't look at the rest of the pump.
+// A time already in the queue (with the same id) is not compared with itself.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) checkGap(t *waterTime, gap time.Duration) error {
+	if gap <= 0 {
+		return nil
+	}
+
+	// The run of t: the pump doesn't stop until all the times linked to it end.
+	start, end := t.start, t.end
+	for grown := true; grown; {
+		grown = false
+		for _, o := range wtm.times {
+			if t.id != 0 && o.id == t.id || o.start.After(end) || o.end.Before(start) {
+				continue
+			}
+			if o.start.Before(start) {
+				start, grown = o.start, true
+			}
+			if o.end.After(end) {
+				end, grown = o.end, true
+			}
+		}
+	}
+
+	for _, o := range wtm.times {
+		if t.id != 0 && o.id == t.id {
+			continue
+		}
+		if rest := start.Sub(o.end); rest > 0 && rest < gap {
+			return &conflictError{msg: fmt.Sprintf("time range %v-%v starts %v after the run ending at %v, min gap %v", t.start, t.end, rest, o.end, gap)}
+		}
+		if rest := o.start.Sub(end); rest > 0 && rest < gap {
+			return &conflictError{msg: fmt.Sprintf("time range %v-%v ends %v before the run starting at %v, min gap %v", t.start, t.end, rest, o.start, gap)}
+		}
+	}
+	return nil
+}
+
+// NextFree returns the earliest waterTime of the zones (all if empty) which runs for d
+// after from (or now, if it's later), outside the blackouts, respecting the rules of the queue
+// and the min gap of the pump.
+// It only reads the queue: the rules are not expanded, so a time after the end
+// of their expansion is not free.
+// It's thread safe.
+func (wtm *waterTimeManager) NextFree(zones []string, d time.Duration, from time.Time) (*waterTime, error) {
+	wtm.RLock()
+	defer wtm.RUnlock()
+
+	return wtm.free(zones, d, from)
+}
+
+// AppendFree adds a waterTime of the zones (all if empty) which runs for d at the next free time
+// after from (or now, if it's later), see NextFree. The rules are expanded before the search.
+// It notify the changes to the subscribers.
+// It's thread safe.
+func (wtm *waterTimeManager) AppendFree(zones []string, d time.Duration, from time.Time) (*waterTime, error) {
+	wtm.Lock()
+	defer wtm.Unlock()
+
+	// The window must not collide with the rules until the horizon.
+	changed := wtm.expandRules(wtm.clock.Now()) > 0
+	wt, err := wtm.free(zones, d, from)
+	if err == nil {
+		wt.id = wtm.nextID()
+		wtm.insert(wt)
+		log.Printf("slot %v scheduled at the next free time", wt)
+		changed = true
+	}
+	if changed {
+		wtm.persist()
+
+		// Notify listeners that the queue is changed
+		wtm.changes.notify()
+	}
+	return wt, err
+}
+
+// free returns the first waterTime of the zones which runs for d after from.
+// The queue is not changed.
+// The caller must hold the lock (also only for reading).
+func (wtm *waterTimeManager) free(zones []string, d time.Duration, from time.Time) (*waterTime, error) {
+	if d <= 0 {
+		return nil, fmt.Errorf("duration must be positive, got %v", d)
+	}
+	if err := wtm.checkZones(zones); err != nil {
+		return nil, err
+	}
+
+	now := wtm.clock.Now().In(siteTZ.loc)
+	if from.Before(now) {
+		from = now
+	}
+	wt, err := wtm.nextFree(&waterTime{zones: zones}, d, from.In(siteTZ.loc), wtm.minGap)
+	if err != nil {
+		return nil, err
+	}
+	// After its expansion a rule could collide with the window.
+	for _, r := range wtm.rules {
+		if r.expanded.Before(wt.end) {
+			return nil, &conflictError{msg: fmt.Sprintf("no free time for %v until the rule '%s' is expanded after %v", d, r, r.expanded)}
+		}
+	}
+	return wt, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tux-eithel/PIrrigation_system/config"
+)
+
+func Test_waterTimeManager_checkGap(t *testing.T) {
+
+	start := time.Date(2018, 8, 21, 6, 0, 0, 0, siteTZ.loc)
+	at := func(m int) time.Time { return start.Add(time.Duration(m) * time.Minute) }
+
+	tests := []struct {
+		name     string
+		from, to int
+		zones    []string
+		wantErr  bool
+	}{
+		{"far after", 50, 60, []string{"c"}, false},
+		{"too close after", 35, 45, []string{"c"}, true},
+		{"too close before", 0, 5, []string{"c"}, true},
+		{"right after", 30, 40, []string{"c"}, false},
+		{"same run", 15, 25, []string{"c"}, false},
+		// The run is 10-30, the pump rests only 5 minutes after it.
+		{"run joined by another slot", 0, 5, []string{"a"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wtm := newWaterTimeManager(withClock(newFakeClock(start.Add(-time.Hour))), withMinGap(10*time.Minute))
+			wtm.insert(&waterTime{start: at(10), end: at(20), zones: []string{"a"}, id: wtm.nextID()})
+			wtm.insert(&waterTime{start: at(15), end: at(30), zones: []string{"b"}, id: wtm.nextID()})
+
+			err := wtm.checkGap(&waterTime{start: at(tt.from), end: at(tt.to), zones: tt.zones}, 10*time.Minute)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkGap() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_waterTimeManager_NextFree(t *testing.T) {
+
+	start := time.Date(2018, 8, 21, 6, 0, 0, 0, siteTZ.loc)
+	at := func(h, m int) time.Time { return time.Date(2018, 8, 21, h, m, 0, 0, siteTZ.loc) }
+	blackouts := newBlackouts([]config.Blackout{{Name: "ban", From: "10:00", To: "18:00"}})
+
+	tests := []struct {
+		name    string
+		zones   []string
+		d       time.Duration
+		from    time.Time
+		minGap  time.Duration
+		want    time.Time
+		wantErr bool
+	}{
+		{"now", []string{"c"}, 20 * time.Minute, time.Time{}, 0, at(6, 0), false},
+		{"after the slot of the zone", []string{"a"}, 20 * time.Minute, time.Time{}, 0, at(6, 30), false},
+		{"too long for the hole", []string{"a"}, 40 * time.Minute, time.Time{}, 0, at(8, 0), false},
+		{"after the blackout", []string{"a"}, 20 * time.Minute, at(9, 50), 0, at(18, 0), false},
+		{"with the min gap", []string{"c"}, 20 * time.Minute, at(8, 5), 15 * time.Minute, at(8, 15), false},
+		{"from the past", []string{"c"}, 20 * time.Minute, at(5, 0), 0, at(6, 0), false},
+		{"not positive", []string{"c"}, 0, time.Time{}, 0, time.Time{}, true},
+		{"longer than the horizon", []string{"c"}, 3 * 24 * time.Hour, time.Time{}, 0, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wtm := newWaterTimeManager(withClock(newFakeClock(start)), withBlackouts(blackouts, blackoutReject), withMinGap(tt.minGap))
+			wtm.insert(&waterTime{start: at(6, 0), end: at(6, 30), zones: []string{"a"}, id: wtm.nextID()})
+			wtm.insert(&waterTime{start: at(7, 0), end: at(8, 0), zones: []string{"a", "b"}, id: wtm.nextID()})
+			changes := wtm.Subscribe()
+			defer wtm.Unsubscribe(changes)
+
+			got, err := wtm.NextFree(tt.zones, tt.d, tt.from)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NextFree() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !got.start.Equal(tt.want) || got.end.Sub(got.start) != tt.d {
+				t.Errorf("NextFree() = %v, want %v for %v", got, tt.want, tt.d)
+			}
+			if len(wtm.List()) != 2 {
+				t.Errorf("NextFree() changed the queue: %v", wtm.List())
+			}
+			select {
+			case <-changes:
+				t.Errorf("NextFree() notified a change")
+			default:
+			}
+		})
+	}
+}
+
+func Test_waterTimeManager_NextFree_rules(t *testing.T) {
+
+	start := time.Date(2018, 8, 21, 6, 0, 0, 0, siteTZ.loc)
+	fc := newFakeClock(start)
+	wtm := newWaterTimeManager(withClock(fc))
+	r, err := parseWaterRule("every day at 07:00 for 1h zones a", start)
+	if err != nil {
+		t.Fatalf("unable to parse the rule = %v", err)
+	}
+	if _, err = wtm.AddRule(r); err != nil {
+		t.Fatalf("AddRule() error = %v", err)
+	}
+	expanded := len(wtm.List())
+	fc.Advance(2 * time.Hour)
+
+	// The rule is expanded until 2018-08-28 06:00, the horizon when it has been added.
+	day := time.Date(2018, 8, 28, 5, 30, 0, 0, siteTZ.loc)
+	if got, err := wtm.NextFree([]string{"a"}, time.Hour, day.AddDate(0, 0, -1)); err != nil || !got.start.Equal(day.AddDate(0, 0, -1)) {
+		t.Errorf("NextFree() = %v, %v, want start %v", got, err, day.AddDate(0, 0, -1))
+	}
+	if _, err := wtm.NextFree([]string{"a"}, time.Hour, day); err == nil {
+		t.Errorf("NextFree() after the expansion of the rule, want error")
+	}
+	if len(wtm.List()) != expanded {
+		t.Errorf("NextFree() expanded the rule: %v", wtm.List())
+	}
+
+	// AppendFree expands the rule before the search.
+	got, err := wtm.AppendFree([]string{"a"}, time.Hour, day)
+	if err != nil {
+		t.Fatalf("AppendFree() error = %v", err)
+	}
+	if !got.start.Equal(day) || len(wtm.List()) != expanded+2 {
+		t.Errorf("AppendFree() = %v, want start %v and the rule expanded: %v", got, day, wtm.List())
+	}
+}
+
+func Test_waterTimeManager_AppendFree(t *testing.T) {
+
+	start := time.Date(2018, 8, 21, 6, 0, 0, 0, siteTZ.loc)
+	wtm := newWaterTimeManager(withClock(newFakeClock(start)), withMinGap(10*time.Minute))
+	changes := wtm.Subscribe()
+	defer wtm.Unsubscribe(changes)
+
+	// Only the next free time keeps the min gap, Append doesn't look at it.
+	if _, err := wtm.Append(&waterTime{start: start, end: start.Add(10 * time.Minute), zones: []string{"front"}}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if _, err := wtm.Append(&waterTime{start: start.Add(15 * time.Minute), end: start.Add(25 * time.Minute), zones: []string{"front"}}); err != nil {
+		t.Fatalf("Append() without the min gap error = %v", err)
+	}
+	// The pump rests only 5 minutes in the run made by Append: the free time is after its min gap.
+	for _, want := range []time.Time{start.Add(35 * time.Minute), start.Add(45 * time.Minute)} {
+		got, err := wtm.AppendFree([]string{"front"}, 10*time.Minute, time.Time{})
+		if err != nil {
+			t.Fatalf("AppendFree() error = %v", err)
+		}
+		if !got.start.Equal(want) || got.id == 0 {
+			t.Errorf("AppendFree() = %v (id %d), want start %v", got, got.id, want)
+		}
+	}
+	if len(wtm.List()) != 4 {
+		t.Errorf("List() = %v, want 4 times", wtm.List())
+	}
+	select {
+	case <-changes:
+	default:
+		t.Errorf("changes not notified")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+
+	"github.com/tux-eithel/PIrrigation_system/config"
+	"gobot.io/x/gobot"
+	"gobot.io/x/gobot/drivers/gpio"
+	"gobot.io/x/gobot/drivers/spi"
+	"gobot.io/x/gobot/platforms/raspi"
+)
+
+// pump starts and stops the pump.
+type pump interface {
+	Start() error
+	Stop() error
+}
+
+// analogSensor reads a value from a channel of an analog to digital converter.
+// *spi.MCP3008Driver satisfies it.
+type analogSensor interface {
+	Read(channel int) (int, error)
+}
+
+// hardware is the local hardware used by pomp.
+type hardware struct {
+	pump   pump
+	sensor analogSensor
+	// robots are started and stopped with the program.
+	robots gobot.Robots
+}
+
+// newPiHardware returns the hardware of the Raspberry Pi wired as cfg.
+func newPiHardware(cfg config.Pomp) *hardware {
+	// Create the reaspberry.
+	r := raspi.NewAdaptor()
+
+	// Create the relay/led.
+	// It's functions are On/Off/Toggle
+	relay := gpio.NewRelayDriver(r, cfg.PumpPin)
+	//relay := gpio.NewLedDriver(r, "35")
+	robotRelay := gobot.NewRobot("Relay Pompa",
+		[]gobot.Connection{r},
+		[]gobot.Device{relay},
+	)
+
+	// Create the MCP driver.
+	// This driver is useful to read some analogic.
+	mcp := spi.NewMCP3008Driver(r, spi.WithSpeed(cfg.Sensor.SPISpeed))
+	robotAcqua := gobot.NewRobot("Sensore Acqua",
+		[]gobot.Connection{r},
+		[]gobot.Device{mcp},
+	)
+	//mcp.interval = 200 * time.Millisecond
+
+	return &hardware{
+		pump:   &relayPump{relay: relay},
+		sensor: mcp,
+		robots: gobot.Robots{robotAcqua, robotRelay},
+	}
+}
+
+// newSimHardware returns a simulated hardware, which runs everywhere.
+func newSimHardware() *hardware {
+	return &hardware{
+		pump:   &simPump{},
+		sensor: newSimSensor(512),
+	}
+}
+
+// relayPump is a pump started by a relay.
+// A relay is closed when you set "HIGH" the pin, so the pump runs with the pin "LOW".
+type relayPump struct {
+	relay *gpio.RelayDriver
+}
+
+// Start starts the pump.
+func (p *relayPump) Start() error {
+	return p.relay.Off()
+}
+
+// Stop stops the pump.
+func (p *relayPump) Stop() error {
+	return p.relay.On()
+}
+
+// simPump is a simulated pump: it only logs.
+type simPump struct {
+	on bool
+	sync.Mutex
+}
+
+// Start starts the pump.
+func (p *simPump) Start() error {
+	p.Lock()
+	defer p.Unlock()
+
+	p.on = true
+	log.Println("simulated pump: on")
+	return nil
+}
+
+// Stop stops the pump.
+func (p *simPump) Stop() error {
+	p.Lock()
+	defer p.Unlock()
+
+	p.on = false
+	log.Println("simulated pump: off")
+	return nil
+}
+
+// simSensor is a simulated analog sensor.
+// It returns the value with a small noise, or err if set.
+type simSensor struct {
+	value int
+	noise int
+	err   error
+	sync.Mutex
+}
+
+// newSimSensor returns a simSensor which reads value.
+func newSimSensor(value int) *simSensor {
+	return &simSensor{value: value, noise: 5}
+}
+
+// Read returns the simulated value.
+// It's thread safe.
+func (s *simSensor) Read(channel int) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.err != nil {
+		return 0, s.err
+	}
+	if channel < 0 || channel > 7 {
+		return 0, errors.New("invalid channel")
+	}
+	value := s.value
+	if s.noise > 0 {
+		value += rand.Intn(2*s.noise+1) - s.noise
+	}
+	if value < 0 {
+		value = 0
+	}
+	if value > 1023 {
+		value = 1023
+	}
+	return value, nil
+}
+
+// set changes the simulated value and the error returned by Read.
+// It's thread safe.
+func (s *simSensor) set(value int, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.value = value
+	s.err = err
+}
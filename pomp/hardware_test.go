@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tux-eithel/PIrrigation_system/config"
+)
+
+func Test_simSensor_Read(t *testing.T) {
+	s := newSimSensor(512)
+	for i := 0; i < 100; i++ {
+		v, err := s.Read(2)
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if v < 512-s.noise || v > 512+s.noise {
+			t.Fatalf("Read() = %d, want 512±%d", v, s.noise)
+		}
+	}
+
+	if _, err := s.Read(8); err == nil {
+		t.Errorf("Read(8) want error, got nil")
+	}
+
+	s.set(100, errors.New("broken"))
+	if _, err := s.Read(2); err == nil {
+		t.Errorf("Read() want error, got nil")
+	}
+}
+
+func Test_readsFromMCP(t *testing.T) {
+	s := newSimSensor(512)
+	s.noise = 0
+	cfg := config.Default().Pomp.Sensor
+	fc := newFakeClock(time.Date(2018, 8, 21, 6, 0, 0, 0, time.UTC))
+	var events []sensorEvent
+	stop := readsFromMCP(s, cfg, fc, func(e sensorEvent) { events = append(events, e) })
+
+	// The first value is read at once, every value is a reading.
+	if len(events) != 1 {
+		t.Fatalf("events = %v, want a reading", events)
+	}
+	if r, ok := events[0].(levelReadingEvent); !ok || r.raw != 512 {
+		t.Fatalf("event = %T %v, want levelReadingEvent 512", events[0], events[0])
+	}
+
+	// The level goes low, then recovers: the change comes after the debounce, at every interval of the clock.
+	for _, step := range []struct {
+		raw  int
+		want sensorEvent
+	}{{100, levelLowEvent{}}, {512, levelRecoveredEvent{}}} {
+		s.set(step.raw, nil)
+		events = nil
+		var got sensorEvent
+		for i := 0; i < 10 && got == nil; i++ {
+			fc.Advance(cfg.Interval)
+			for _, e := range events {
+				if _, ok := e.(levelReadingEvent); !ok {
+					got = e
+				}
+			}
+		}
+		var raw int
+		switch a := got.(type) {
+		case levelLowEvent:
+			raw = a.raw
+		case levelRecoveredEvent:
+			raw = a.raw
+		}
+		if reflect.TypeOf(got) != reflect.TypeOf(step.want) || raw != step.raw {
+			t.Errorf("event = %T %v, want %T %d", got, got, step.want, step.raw)
+		}
+	}
+
+	// After stop the sensor isn't read anymore.
+	stop()
+	events = nil
+	fc.Advance(10 * cfg.Interval)
+	if _, waiting := fc.Next(); waiting || len(events) > 0 {
+		t.Errorf("events = %v after stop, waiting %v", events, waiting)
+	}
+}
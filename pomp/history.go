@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// runOutcome is what happened to a slot.
+type runOutcome string
+
+const (
+	// outcomeMissed is a slot (or a part of it) not watered while pomp was down.
+	outcomeMissed runOutcome = "missed"
+	// outcomeRescheduled is a slot moved by the catch-up policy.
+	outcomeRescheduled runOutcome = "rescheduled"
+	// outcomeCompleted is a slot watered until its end.
+	outcomeCompleted runOutcome = "completed"
+	// outcomeStopped is a slot stopped before its end.
+	outcomeStopped runOutcome = "stopped"
+	// outcomeSkipped is a slot which never started.
+	outcomeSkipped runOutcome = "skipped"
+	// outcomeRejected is an occurrence of a rule never scheduled, because of a blackout or a collision.
+	outcomeRejected runOutcome = "rejected"
+)
+
+// runRecord is an entry of the run history.
+type runRecord struct {
+	// At is when the record has been written.
+	At           time.Time  `json:"at"`
+	Slot         uint64     `json:"slot"`
+	Zones        []string   `json:"zones,omitempty"`
+	PlannedStart time.Time  `json:"planned_start"`
+	PlannedEnd   time.Time  `json:"planned_end"`
+	Outcome      runOutcome `json:"outcome"`
+	Reason       string     `json:"reason,omitempty"`
+	// Start and End are the actual run, zero if the slot didn't run.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// StopReason is why the run stopped, or why the slot didn't start.
+	StopReason stopReason    `json:"stop_reason,omitempty"`
+	PumpOn     time.Duration `json:"pump_on"`
+	// SensorMin and SensorMax are the values read during the run, -1 without readings.
+	SensorMin int `json:"sensor_min"`
+	SensorMax int `json:"sensor_max"`
+}
+
+// maxHistoryRecords is how many records the history keeps, years of a few slots a day.
+const maxHistoryRecords = 5000
+
+// runHistory keeps the history of the slots, appended to a file.
+// Records are never modified, so a line is written once and a crash loses at most the last one.
+// Only the last max records are kept: when the file has twice as many lines it's rewritten
+// with the records kept.
+type runHistory struct {
+	// path of the file, empty to keep the history only in memory.
+	path    string
+	records []*runRecord
+	// max is the number of records kept, lines the number of lines of the file.
+	max   int
+	lines int
+	sync.Mutex
+}
+
+// newRunHistory returns a runHistory which appends to the file at path.
+func newRunHistory(path string) *runHistory {
+	return &runHistory{path: path, records: make([]*runRecord, 0), max: maxHistoryRecords}
+}
+
+// load reads the records from the file.
+// A missing file returns no records, lines which can't be decoded are logged and skipped.
+// It's thread safe.
+func (h *runHistory) load() error {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.path == "" {
+		return nil
+	}
+	f, err := os.Open(h.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to open history file: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		r := &runRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			log.Printf("history file '%s' line %d is corrupted, skip...: %v", h.path, line, err)
+			continue
+		}
+		h.records = append(h.records, r)
+		h.lines++
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("history file '%s' read interrupted, using records read so far: %v", h.path, err)
+	}
+	h.prune()
+	return nil
+}
+
+// prune drops the oldest records over max, and rewrites the file when it has twice as many lines.
+// The caller must hold the lock.
+func (h *runHistory) prune() {
+	if h.max <= 0 || len(h.records) <= h.max {
+		return
+	}
+	h.records = append([]*runRecord(nil), h.records[len(h.records)-h.max:]...)
+	if h.path == "" || h.lines < 2*h.max {
+		return
+	}
+	if err := h.save(); err != nil {
+		log.Printf("unable to compact the history: %v", err)
+	}
+}
+
+// save rewrites the file with the records kept.
+// The file is first written in a temporary file and then renamed,
+// so a crash during the write leaves the old file in place.
+// The caller must hold the lock.
+func (h *runHistory) save() error {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, r := range h.records {
+		if err := enc.Encode(r); err != nil {
+			return fmt.Errorf("unable to encode record: %v", err)
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(h.path), filepath.Base(h.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(buf.Bytes()); err == nil {
+		err = tmp.Sync()
+	}
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write temporary file: %v", err)
+	}
+
+	if err = os.Rename(tmp.Name(), h.path); err != nil {
+		return fmt.Errorf("unable to replace history file: %v", err)
+	}
+	h.lines = len(h.records)
+	return nil
+}
+
+// record adds r to the history. Errors are only logged, the history is kept in memory.
+// A nil history records nothing.
+// It's thread safe.
+func (h *runHistory) record(r *runRecord) {
+	if h == nil {
+		return
+	}
+	h.Lock()
+	defer h.Unlock()
+
+	h.records = append(h.records, r)
+	defer h.prune()
+	if h.path == "" {
+		return
+	}
+
+	line, err := json.Marshal(r)
+	if err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+			_, err = f.Write(append(line, '\n'))
+			if errClose := f.Close(); err == nil {
+				err = errClose
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("unable to save the history: %v", err)
+		return
+	}
+	h.lines++
+}
+
+// Records returns a copy of the records of the slots planned to start in the range [from, to).
+// It's thread safe.
+func (h *runHistory) Records(from, to time.Time) []runRecord {
+	h.Lock()
+	defer h.Unlock()
+
+	records := make([]runRecord, 0)
+	for _, r := range h.records {
+		if !r.PlannedStart.Before(from) && r.PlannedStart.Before(to) {
+			records = append(records, *r)
+		}
+	}
+	return records
+}
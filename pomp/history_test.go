@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_runHistory_prune(t *testing.T) {
+
+	start := time.Date(2018, 8, 21, 6, 0, 0, 0, siteTZ.loc)
+	path := filepath.Join(t.TempDir(), "history")
+	h := newRunHistory(path)
+	h.max = 3
+
+	for i := 0; i < 5; i++ {
+		h.record(&runRecord{Slot: uint64(i + 1), PlannedStart: start.Add(time.Duration(i) * time.Hour), Outcome: outcomeCompleted})
+	}
+	if got := h.Records(start, start.AddDate(0, 0, 1)); len(got) != 3 || got[0].Slot != 3 {
+		t.Errorf("Records() = %+v, want the last 3", got)
+	}
+	// The file is rewritten only when it has twice the records kept.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read the history = %v", err)
+	}
+	if n := bytes.Count(data, []byte("\n")); n != 5 {
+		t.Errorf("history file has %d lines, want 5", n)
+	}
+	h.record(&runRecord{Slot: 6, PlannedStart: start.Add(5 * time.Hour), Outcome: outcomeCompleted})
+	if data, _ = os.ReadFile(path); bytes.Count(data, []byte("\n")) != 3 {
+		t.Errorf("history file not compacted:\n%s", data)
+	}
+
+	loaded := newRunHistory(path)
+	loaded.max = 3
+	if err := loaded.load(); err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if got := loaded.Records(start, start.AddDate(0, 0, 1)); len(got) != 3 || got[0].Slot != 4 || got[2].Slot != 6 {
+		t.Errorf("Records() after load = %+v, want slots 4-6", got)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"github.com/tux-eithel/PIrrigation_system/config"
+)
+
+// reading is a value of the sensor, the payload of the level events.
+type reading struct {
+	// raw value of the chip, 0-1023
+	raw int
+	// level in percent, calibrated
+	level float64
+}
+
+// sensorEvent is a result of the readings of the sensor, passed by readsFromMCP to its handler.
+// It never goes on the bus: the handler raises the faults, which publish the stopEvent.
+type sensorEvent interface {
+	sensorEvent()
+}
+
+// levelReadingEvent is a value read from the sensor.
+type levelReadingEvent struct{ reading }
+
+// levelLowEvent is a level gone under the low threshold.
+type levelLowEvent struct{ reading }
+
+// levelRecoveredEvent is a level gone back over the high threshold.
+type levelRecoveredEvent struct{ reading }
+
+// readErrorEvent is a read of the sensor failed.
+type readErrorEvent struct{ err error }
+
+func (levelReadingEvent) sensorEvent()   {}
+func (levelLowEvent) sensorEvent()       {}
+func (levelRecoveredEvent) sensorEvent() {}
+func (readErrorEvent) sensorEvent()      {}
+
+// levelDetector turns the raw values of the sensor into levelLowEvent and levelRecoveredEvent.
+// The level is low when it stays under low for debounce consecutive samples,
+// and recovers when it stays over high for debounce consecutive samples:
+// values between low and high don't change the state (hysteresis).
+type levelDetector struct {
+	empty, full int
+	low, high   float64
+	debounce    int
+
+	// isLow is the current state of the level
+	isLow bool
+	// count is the number of consecutive samples against the current state
+	count int
+}
+
+// newLevelDetector returns a levelDetector configured as cfg.
+// The level starts as not low.
+func newLevelDetector(cfg config.Sensor) *levelDetector {
+	return &levelDetector{
+		empty:    cfg.Empty,
+		full:     cfg.Full,
+		low:      cfg.Low,
+		high:     cfg.High,
+		debounce: cfg.Debounce,
+	}
+}
+
+// calibrate returns the level in percent of a raw value.
+func (ld *levelDetector) calibrate(raw int) float64 {
+	level := float64(raw-ld.empty) * 100 / float64(ld.full-ld.empty)
+	if level < 0 {
+		return 0
+	}
+	if level > 100 {
+		return 100
+	}
+	return level
+}
+
+// update adds a raw value and returns its reading and the event
+// levelLowEvent or levelRecoveredEvent if the state has changed, nil otherwise.
+func (ld *levelDetector) update(raw int) (reading, sensorEvent) {
+	r := reading{raw: raw, level: ld.calibrate(raw)}
+
+	against := r.level < ld.low
+	if ld.isLow {
+		against = r.level > ld.high
+	}
+	if !against {
+		ld.count = 0
+		return r, nil
+	}
+
+	ld.count++
+	if ld.count < ld.debounce {
+		return r, nil
+	}
+
+	ld.count = 0
+	ld.isLow = !ld.isLow
+	if ld.isLow {
+		return r, levelLowEvent{r}
+	}
+	return r, levelRecoveredEvent{r}
+}
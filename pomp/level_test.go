@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tux-eithel/PIrrigation_system/config"
+)
+
+func Test_levelDetector_calibrate(t *testing.T) {
+	tests := []struct {
+		name        string
+		empty, full int
+		raw         int
+		want        float64
+	}{
+		{"empty", 100, 900, 100, 0},
+		{"half", 100, 900, 500, 50},
+		{"full", 100, 900, 900, 100},
+		{"under empty", 100, 900, 50, 0},
+		{"over full", 100, 900, 1000, 100},
+		{"inverted", 900, 100, 300, 75},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ld := &levelDetector{empty: tt.empty, full: tt.full}
+			if got := ld.calibrate(tt.raw); got != tt.want {
+				t.Errorf("calibrate(%d) = %v, want %v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_levelDetector_update(t *testing.T) {
+	// Raw values are percent: low under 20, recovered over 30, after 3 samples.
+	cfg := config.Sensor{Empty: 0, Full: 100, Low: 20, High: 30, Debounce: 3}
+
+	tests := []struct {
+		name string
+		raws []int
+		want []sensorEvent
+	}{
+		{"steady", []int{50, 50, 50, 50}, []sensorEvent{nil, nil, nil, nil}},
+		{"low after debounce", []int{10, 10, 10, 10}, []sensorEvent{nil, nil, levelLowEvent{}, nil}},
+		{"noise is debounced", []int{10, 10, 50, 10, 10}, []sensorEvent{nil, nil, nil, nil, nil}},
+		{"hysteresis", []int{10, 10, 10, 25, 25, 25, 25}, []sensorEvent{nil, nil, levelLowEvent{}, nil, nil, nil, nil}},
+		{"recovered", []int{10, 10, 10, 40, 40, 40}, []sensorEvent{nil, nil, levelLowEvent{}, nil, nil, levelRecoveredEvent{}}},
+		{"rising level is not low", []int{50, 90, 100, 100}, []sensorEvent{nil, nil, nil, nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ld := newLevelDetector(cfg)
+			for i, raw := range tt.raws {
+				r, got := ld.update(raw)
+				if reflect.TypeOf(got) != reflect.TypeOf(tt.want[i]) {
+					t.Errorf("update(%d) #%d = %T, want %T", raw, i, got, tt.want[i])
+				}
+				if r.raw != raw || r.level != float64(raw) {
+					t.Errorf("update(%d) #%d reading = %v", raw, i, r)
+				}
+			}
+		})
+	}
+}
@@ -1,152 +1,215 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
+	"flag"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
+	"time"
 
-	"gobot.io/x/gobot"
-	"gobot.io/x/gobot/drivers/gpio"
-	"gobot.io/x/gobot/drivers/spi"
-	"gobot.io/x/gobot/platforms/raspi"
+	"github.com/tux-eithel/PIrrigation_system/config"
 )
 
 type (
 	StopSignal string
 )
 
+// StopSignal is the payload of stopEvent.
+// stopAndQuit stops and exits the workers.
 const (
-	startRelay        = "START_RELAY"
-	startMCP          = "START_MCP"
-	startRemoteRobots = "START_REMOTE_ROBOTS"
-
-	// stopWorkers events accept booleans.
-	// If is true, then it will stop and exit the worker
-	stopWorkers = "STOP_WORKERS"
-
-	stopRemote  StopSignal = "STOP_REMOTE"
+	// stopLocal stops the cycle: first the pump, then stopRemote closes the valves.
 	stopLocal   StopSignal = "STOP_LOCAL"
+	stopRemote  StopSignal = "STOP_REMOTE"
 	stopAndQuit StopSignal = "STOP_QUIT"
+
+	// stopFault brings the system in the safe state after a fault:
+	// pump off and valves open. Workers keep running.
+	stopFault StopSignal = "STOP_FAULT"
 )
 
 func main() {
 
-	// Create a generic gobot.Eventer.
-	// This eventer is useful to send events between workers.
-	genericEventer := gobot.NewEventer()
-	genericEventer.AddEvent(startRelay)
-	genericEventer.AddEvent(startMCP)
-	genericEventer.AddEvent(stopWorkers)
+	schedulePath := flag.String("schedule", "schedule.json", "file where the schedule is saved")
+	historyPath := flag.String("history", "history.json", "file where the history of the slots is saved")
+	timeZone := flag.String("tz", defaultTimeZone, "time zone of the garden")
+	maxZones := flag.Int("max-zones", 0, "maximum number of zones open at once (0 means no limit)")
+	speed := flag.Float64("speed", 1, "run the schedule this many times faster than the real time (fast-forward)")
+	httpAddr := flag.String("http", "127.0.0.1:8080", "address of the http api, only local by default (empty to disable it)")
+	dstName := flag.String("dst", string(dstEarlier), "how to resolve times at DST changes: earlier, later or reject")
+	relaysAddr := flag.String("relays", "raspy0w:50051", "address of the gRPC server of the relays")
+	sim := flag.Bool("sim", false, "use a simulated hardware instead of the Raspberry Pi")
+	configPath := flag.String("config", "", "configuration file (see config/example.yaml), flags set on the command line win over it")
+	simulateFor := flag.Duration("simulate", 0, "simulate the schedule for this long (like 168h) on a virtual clock, print the timeline and exit")
+	flag.Parse()
+
+	// The configuration: the file, if any, then the flags set on the command line.
+	cfg := config.Default()
+	var err error
+	if *configPath != "" {
+		cfg, err = config.Load(*configPath)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "tz":
+			cfg.Timezone = *timeZone
+		case "dst":
+			cfg.DST = *dstName
+		case "max-zones":
+			cfg.Safety.MaxOpenZones = *maxZones
+		case "relays":
+			cfg.Relays.Address = *relaysAddr
+		}
+	})
+	if err = cfg.Validate(); err != nil {
+		log.Fatalln(err)
+	}
+
+	// Every time of the schedule is read in the time zone of the garden.
+	policy, err := parseDSTPolicy(cfg.DST)
+	if err != nil {
+		log.Fatalln("invalid configuration:", err)
+	}
+	siteTZ, err = newSiteTimeZone(cfg.Timezone, policy)
+	if err != nil {
+		log.Printf("WARNING: %v", err)
+	}
+	log.Printf("schedule time zone: %s", siteTZ)
+	if l := cfg.Location; l.Configured() {
+		siteSun = &solarSite{latitude: l.Latitude, longitude: l.Longitude}
+		log.Printf("garden position: %v, %v", l.Latitude, l.Longitude)
+	}
+
+	// The policies of the configuration, shared with the simulation.
+	policies := []managerOption{
+		withMaxOpenZones(cfg.Safety.MaxOpenZones),
+		withMaxSlot(cfg.Safety.MaxSlot),
+		withMinGap(cfg.Safety.MinGap),
+		withBlackouts(newBlackouts(cfg.Safety.Blackouts), blackoutPolicy(cfg.Safety.BlackoutPolicy)),
+		withBudgets(newBudgets(cfg.Safety.Budgets), cfg.FlowRates),
+		withCatchUp(catchUpPolicy(cfg.Safety.CatchUp)),
+	}
+	var zones []string
+	if *configPath != "" {
+		// Only the zones of the configuration can be scheduled.
+		zones = cfg.ZoneNames()
+		policies = append(policies, withZones(zones))
+	}
+
+	// A dry run: the schedule runs on a virtual clock and a simulated hardware.
+	if *simulateFor != 0 {
+		// The logs of the workers would hide the timeline.
+		log.SetOutput(io.Discard)
+		if err = simulate(policies, zones, cfg.Pomp.Sensor, *schedulePath, time.Now(), *simulateFor, os.Stdout); err != nil {
+			log.SetOutput(os.Stderr)
+			log.Fatalln("unable to simulate the schedule:", err)
+		}
+		return
+	}
+
+	// Create the bus of the typed events.
+	// This bus is useful to send events between workers.
+	events := newBus()
 
-	// Instance the time scheduler
-	scheduler := newWaterTimeManager()
+	// Instance the time scheduler and reload the schedule saved on disk
+	history := newRunHistory(*historyPath)
+	if err := history.load(); err != nil {
+		log.Printf("unable to load the history, start with an empty one: %v", err)
+	}
+	var schedulerClock clock = realClock{}
+	if *speed != 1 {
+		if *speed <= 0 {
+			log.Fatalln("invalid configuration: speed must be positive")
+		}
+		log.Printf("WARNING: fast-forward, the schedule runs %v times faster", *speed)
+		schedulerClock = newScaledClock(*speed)
+	}
+	opts := append([]managerOption{
+		withStore(newScheduleStore(*schedulePath)),
+		withClock(schedulerClock),
+		withHeartbeat(defaultHeartbeat),
+		withHistory(history),
+	}, policies...)
+	scheduler := newWaterTimeManager(opts...)
+	if err := scheduler.Load(); err != nil {
+		log.Printf("unable to load the schedule, start with an empty one: %v", err)
+	}
 
-	if ok := initRemoteRobots(); !ok {
-		log.Fatalln("unable to start remote Robots")
+	remote, err := initRemoteRobots(cfg.Relays.Address)
+	if err != nil {
+		log.Fatalln("unable to start remote Robots:", err)
 	}
 
+	// The status of the hardware, shared by workers, console and api.
+	status := newSystemStatus(schedulerClock)
+
 	// The quit channel closes all the workers.
 	waitRobots := &sync.WaitGroup{}
 
 	waitRobots.Add(1)
-	go workRemoteRobots("remote relays", genericEventer, waitRobots)
+	go workRemoteRobots("remote relays", remote, events, status, waitRobots)
 
 	waitRobots.Add(1)
-	go consumerSchedule(scheduler, genericEventer, waitRobots)
-
-	// Create the reaspberry.
-	r := raspi.NewAdaptor()
-
-	// Create the relay/led.
-	// It's functions are On/Off/Toggle
-	relay := gpio.NewRelayDriver(r, "7")
-	//relay := gpio.NewLedDriver(r, "35")
-	robotRelay := gobot.NewRobot("Relay Pompa",
-		[]gobot.Connection{r},
-		[]gobot.Device{relay},
-	)
-	err := relay.On()
+	go consumerSchedule(scheduler, events, status, waitRobots)
+
+	// The local hardware: the Raspberry Pi or a simulated one.
+	var hw *hardware
+	if *sim {
+		log.Println("WARNING: simulated hardware")
+		hw = newSimHardware()
+	} else {
+		hw = newPiHardware(cfg.Pomp)
+	}
+
+	err = hw.pump.Stop()
 	if err != nil {
 		log.Fatalln("unable to set HIGH the Realy Pompa:", err)
 	}
 	waitRobots.Add(1)
-	go workRelay(robotRelay.Name, relay, genericEventer, waitRobots)
-
-	// Create the MCP driver.
-	// This driver is useful to read some analogic.
-	mcp := spi.NewMCP3008Driver(r, spi.WithSpeed(1350000))
-	robotAcqua := gobot.NewRobot("Sensore Acqua",
-		[]gobot.Connection{r},
-		[]gobot.Device{mcp},
-	)
-	//mcp.interval = 200 * time.Millisecond
+	go workRelay("Relay Pompa", hw.pump, events, status, waitRobots)
+
 	waitRobots.Add(1)
-	go workMCP(robotAcqua.Name, mcp, genericEventer, waitRobots)
+	go workMCP("Sensore Acqua", hw.sensor, cfg.Pomp.Sensor, events, status, waitRobots)
 
 	// Starts all the robots!
-	robots := gobot.Robots{robotAcqua, robotRelay}
-	err = robots.Start(false) // We pass "false" as parameter so we can manually stop the robots.
+	err = hw.robots.Start(false) // We pass "false" as parameter so we can manually stop the robots.
 	if err != nil {
 		log.Fatalln("Unable to start robots:", err)
 	}
 
-	// Function to read data.
-	// In the future this will be an http handler.
-	go func() {
-		buff := bufio.NewReader(os.Stdin)
-		for {
-			fmt.Printf("Inserisci data inizio e fine separate da ' - ' (p per la schedulazione corrente): ")
-			text, _ := buff.ReadString('\n')
-
-			if text == "\n" {
-				continue
-			}
-
-			// Command wich prints the current schedule status
-			if text[:len(text)-1] == "p" {
-				fmt.Println("Stato schedulazione:")
-
-				for _, s := range scheduler.PrintStatus() {
-					var stato string
-					if s.started {
-						stato = "in corso"
-					} else {
-						stato = fmt.Sprintf("inizierà tra %v", s.willStart)
-					}
-					fmt.Printf("Inizio %s, Fine %s: %s\n",
-						s.start.Format("02/01/2006 15:04"),
-						s.end.Format("02/01/2006 15:04"),
-						stato)
-				}
-				continue
-			}
-
-			t, err := newWaterTime(text[:len(text)-1])
-			if err != nil {
-				fmt.Printf("unable to parse time: %v, skip...\n", err)
-				continue
-			}
-			_, err = scheduler.Append(t)
-			if err != nil {
-				fmt.Printf("this time collide with other times: %v\n", err)
-				continue
+	// Function to read data from the console.
+	go readConsole(scheduler, status, events, os.Stdin)
+
+	// The http api.
+	var server *http.Server
+	if *httpAddr != "" {
+		server = &http.Server{Addr: *httpAddr, Handler: newAPIHandler(scheduler, status, events)}
+		go func() {
+			log.Printf("http api listening on %s", *httpAddr)
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("unable to start the http api: %v", err)
 			}
-		}
-	}()
+		}()
+	}
 
 	// Wait the ctrl-c signal
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	<-c
-	genericEventer.Publish(stopWorkers, stopAndQuit)
+	if server != nil {
+		server.Close()
+	}
+	events.publish(stopEvent{stopAndQuit})
 
 	// Stop all the robots
 	log.Println("wait all robots closes...")
 	waitRobots.Wait()
-	err = robots.Stop()
+	err = hw.robots.Stop()
 	if err != nil {
 		log.Fatalln("Unable to stop robots:", err)
 	}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// runPolicy tells RunNow what to do with the slots colliding with a manual run.
+type runPolicy string
+
+const (
+	// policyPreempt cuts the colliding slots: the part inside the manual run is lost.
+	policyPreempt runPolicy = "preempt"
+	// policyShift moves the colliding slots after the manual run, keeping their duration.
+	// Later slots are moved too if needed.
+	policyShift runPolicy = "shift"
+)
+
+// parseRunPolicy returns the runPolicy of s, policyPreempt if it's empty.
+func parseRunPolicy(s string) (runPolicy, error) {
+	switch runPolicy(s) {
+	case "", policyPreempt:
+		return policyPreempt, nil
+	case policyShift:
+		return policyShift, nil
+	}
+	return "", fmt.Errorf("unknown policy '%s', must be %s or %s", s, policyPreempt, policyShift)
+}
+
+// RunNow adds a waterTime which starts now and lasts d, for the given zones (all if empty).
+// It's clipped or rejected by the blackouts, it never waits the end of a blackout.
+// The slots colliding with it are preempted or shifted as policy says:
+// if the result doesn't respect the rules of the queue nothing is changed.
+// It notify the changes to the subscribers.
+// It's thread safe.
+func (wtm *waterTimeManager) RunNow(zones []string, d time.Duration, policy runPolicy) (*waterTime, error) {
+	wtm.Lock()
+	defer wtm.Unlock()
+
+	if d <= 0 {
+		return nil, fmt.Errorf("duration must be positive, got %v", d)
+	}
+
+	now := wtm.clock.Now().In(siteTZ.loc)
+	run := &waterTime{start: now, end: now.Add(d), zones: zones}
+	if err := wtm.applyBlackouts(run, false); err != nil {
+		return nil, annotate(err, "unable to run now")
+	}
+
+	var times []*waterTime
+	switch policy {
+	case policyPreempt:
+		times = wtm.preempt(run, now)
+	case policyShift:
+		times = wtm.shift(run, now)
+	default:
+		return nil, fmt.Errorf("unknown policy '%s'", policy)
+	}
+
+	// The new queue is checked as a whole, the old one is kept if it's not valid.
+	old := wtm.times
+	unchanged := make(map[*waterTime]bool)
+	for _, t := range old {
+		unchanged[t] = true
+	}
+	wtm.times = times
+	if err := wtm.checkRange(run); err != nil {
+		wtm.times = old
+		return nil, annotate(err, "unable to run now")
+	}
+	for _, t := range times {
+		err := wtm.checkRange(t)
+		if w := wtm.blackoutWindows(t.start, t.end); err == nil && !unchanged[t] && len(w) > 0 {
+			err = &conflictError{msg: fmt.Sprintf("it would be moved inside the %v", w[0])}
+		}
+		if err != nil {
+			wtm.times = old
+			return nil, annotate(err, fmt.Sprintf("unable to %s schedule %d", policy, t.id))
+		}
+	}
+
+	run.id = wtm.nextID()
+	wtm.insert(run)
+	wtm.persist()
+
+	// Notify listeners that the queue is changed
+	wtm.changes.notify()
+	return run, nil
+}
+
+// preempt returns a copy of the queue where the slots colliding with run
+// are cut at its end, or removed if they end before.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) preempt(run *waterTime, now time.Time) []*waterTime {
+	times := make([]*waterTime, 0, len(wtm.times))
+	for _, t := range wtm.times {
+		if !t.sharesZones(run) || !t.overlaps(run) {
+			times = append(times, t)
+			continue
+		}
+		if !t.end.After(run.end) {
+			log.Printf("slot %v preempted by the manual run", t)
+			continue
+		}
+		// Times in the queue are never modified, consumerSchedule could be using the old one.
+		cut := &waterTime{start: run.end, end: t.end, id: t.id, zones: t.zones, soak: t.soak, catchUp: t.catchUp}
+		log.Printf("slot %v preempted by the manual run, now %v", t, cut)
+		times = append(times, cut)
+	}
+	return times
+}
+
+// shift returns a copy of the queue where the slots colliding with run are moved after it,
+// and the later slots colliding with the moved ones are moved too.
+// A running slot moves only the part still to do.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) shift(run *waterTime, now time.Time) []*waterTime {
+	placed := []*waterTime{run}
+	times := make([]*waterTime, 0, len(wtm.times))
+	for _, t := range wtm.times {
+		start := t.start
+		if start.Before(now) {
+			start = now
+		}
+		moved := &waterTime{start: start, end: t.end, id: t.id, zones: t.zones, soak: t.soak, catchUp: t.catchUp}
+
+		// The queue is ordered by start, so the slots are only moved forward.
+		for collides := true; collides; {
+			collides = false
+			for _, p := range placed {
+				if moved.sharesZones(p) && moved.overlaps(p) {
+					moved.end = p.end.Add(moved.end.Sub(moved.start))
+					moved.start = p.end
+					collides = true
+				}
+			}
+		}
+
+		if moved.start.Equal(start) {
+			moved = t
+		} else {
+			log.Printf("slot %v shifted by the manual run, now %v", t, moved)
+		}
+		placed = append(placed, moved)
+		times = append(times, moved)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].start.Before(times[j].start) })
+	return times
+}
+
+// StopNow ends the running slots, the future ones are kept.
+// consumerSchedule stops the cycle after the notification, as at the end of a slot.
+// It returns the number of slots stopped.
+// It's thread safe.
+func (wtm *waterTimeManager) StopNow() (int, error) {
+	wtm.Lock()
+	defer wtm.Unlock()
+
+	now := wtm.clock.Now()
+	times := make([]*waterTime, 0, len(wtm.times))
+	for _, t := range wtm.times {
+		if t.active(now) {
+			log.Printf("slot %v stopped manually", t)
+			continue
+		}
+		times = append(times, t)
+	}
+
+	stopped := len(wtm.times) - len(times)
+	if stopped == 0 {
+		return 0, &conflictError{msg: "nothing is running"}
+	}
+	wtm.times = times
+	wtm.persist()
+
+	// Notify listeners that the queue is changed
+	wtm.changes.notify()
+	return stopped, nil
+}
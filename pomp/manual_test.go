@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_waterTimeManager_RunNow(t *testing.T) {
+
+	start := time.Date(2018, 8, 21, 6, 0, 0, 0, siteTZ.loc)
+	at := func(m int) time.Time { return start.Add(time.Duration(m) * time.Minute) }
+
+	// slot is a waterTime in minutes from start, compared as string.
+	slot := func(id uint64, from, to int, zones ...string) string {
+		return (&waterTime{start: at(from), end: at(to), id: id, zones: zones}).String()
+	}
+
+	tests := []struct {
+		name    string
+		zones   []string
+		d       time.Duration
+		policy  runPolicy
+		max     int
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "no conflict", zones: []string{"c"}, d: 10 * time.Minute, policy: policyPreempt,
+			want: []string{slot(1, -10, 20, "a"), slot(5, 0, 10, "c"), slot(2, 15, 30, "b"), slot(3, 30, 40, "a"), slot(4, 40, 50, "a")},
+		},
+		{
+			name: "preempt", zones: []string{"a"}, d: 35 * time.Minute, policy: policyPreempt,
+			want: []string{slot(5, 0, 35, "a"), slot(2, 15, 30, "b"), slot(3, 35, 40, "a"), slot(4, 40, 50, "a")},
+		},
+		{
+			name: "preempt all zones", d: 15 * time.Minute, policy: policyPreempt,
+			want: []string{slot(5, 0, 15), slot(1, 15, 20, "a"), slot(2, 15, 30, "b"), slot(3, 30, 40, "a"), slot(4, 40, 50, "a")},
+		},
+		{
+			name: "shift", zones: []string{"a"}, d: 10 * time.Minute, policy: policyShift,
+			want: []string{slot(5, 0, 10, "a"), slot(1, 10, 30, "a"), slot(2, 15, 30, "b"), slot(3, 30, 40, "a"), slot(4, 40, 50, "a")},
+		},
+		{
+			name: "shift cascade", zones: []string{"a"}, d: 20 * time.Minute, policy: policyShift,
+			want: []string{slot(5, 0, 20, "a"), slot(2, 15, 30, "b"), slot(1, 20, 40, "a"), slot(3, 40, 50, "a"), slot(4, 50, 60, "a")},
+		},
+		{
+			name: "shift over capacity", zones: []string{"a", "c"}, d: 20 * time.Minute, policy: policyShift, max: 2,
+			wantErr: true,
+		},
+		{
+			name: "not positive duration", zones: []string{"a"}, d: 0, policy: policyPreempt,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wtm := newWaterTimeManager(withClock(newFakeClock(start)), withMaxOpenZones(tt.max))
+
+			wtm.insert(&waterTime{start: at(-10), end: at(20), zones: []string{"a"}, id: wtm.nextID()})
+			wtm.insert(&waterTime{start: at(15), end: at(30), zones: []string{"b"}, id: wtm.nextID()})
+			wtm.insert(&waterTime{start: at(30), end: at(40), zones: []string{"a"}, id: wtm.nextID()})
+			wtm.insert(&waterTime{start: at(40), end: at(50), zones: []string{"a"}, id: wtm.nextID()})
+			before := fmt.Sprint(wtm.List())
+
+			_, err := wtm.RunNow(tt.zones, tt.d, tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RunNow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if got := fmt.Sprint(wtm.List()); got != before {
+					t.Errorf("queue changed after an error = %v, want %v", got, before)
+				}
+				return
+			}
+
+			got := make([]string, 0)
+			for _, wt := range wtm.List() {
+				got = append(got, wt.String())
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("queue = %v\nwant %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_waterTimeManager_StopNow(t *testing.T) {
+
+	start := time.Date(2018, 8, 21, 6, 0, 0, 0, time.UTC)
+	wtm := newWaterTimeManager(withClock(newFakeClock(start)))
+
+	wtm.insert(&waterTime{start: start.Add(-time.Minute), end: start.Add(10 * time.Minute), zones: []string{"a"}, id: wtm.nextID()})
+	wtm.insert(&waterTime{start: start, end: start.Add(10 * time.Minute), zones: []string{"b"}, id: wtm.nextID()})
+	wtm.insert(&waterTime{start: start.Add(time.Hour), end: start.Add(2 * time.Hour), zones: []string{"a"}, id: wtm.nextID()})
+
+	stopped, err := wtm.StopNow()
+	if err != nil || stopped != 2 {
+		t.Fatalf("StopNow() = %d, %v, want 2", stopped, err)
+	}
+	if list := wtm.List(); len(list) != 1 || list[0].id != 3 {
+		t.Errorf("queue = %v, want only the future slot 3", list)
+	}
+
+	if _, err = wtm.StopNow(); err == nil {
+		t.Errorf("StopNow() without running slots want error, got nil")
+	}
+}
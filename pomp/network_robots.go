@@ -1,67 +1,241 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"sync"
+	"time"
 
-	"gobot.io/x/gobot"
+	"github.com/tux-eithel/PIrrigation_system/valves"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
+// remoteTimeout is the maximum time to wait the acknowledgement of the remote robots.
+// Moving a valve takes a pulse, so it's a bit long.
+const remoteTimeout = 30 * time.Second
+
+// remoteRobots is the client of the node which controls the valves.
+type remoteRobots struct {
+	conn   *grpc.ClientConn
+	client valves.ValvesClient
+}
+
 // initRemoteRobots initializes the remote worker.
-// It make ah http call (gRPC in future) and way the respose.
-func initRemoteRobots() bool {
-	return true
+// It connects to the gRPC server of the relays and checks its health.
+func initRemoteRobots(addr string) (*remoteRobots, error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to '%s': %v", addr, err)
+	}
+	rr := &remoteRobots{conn: conn, client: valves.NewValvesClient(conn)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+	resp, err := rr.client.Health(ctx, &valves.HealthRequest{})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to check health of '%s': %v", addr, err)
+	}
+	if !resp.GetOk() {
+		conn.Close()
+		return nil, fmt.Errorf("remote robots on '%s' not healthy: %s", addr, resp.GetMessage())
+	}
+	return rr, nil
 }
 
-func workRemoteRobots(robotName string, eventer gobot.Eventer, waitRobots *sync.WaitGroup) {
-	commands := eventer.Subscribe()
-	var err error
+// valvesDriver drives the valves of the zones: the remote robots, or the in memory valves of the simulation.
+type valvesDriver interface {
+	// doRemoteWork opens the valves of the zones and closes the others, allZones opens every valve.
+	doRemoteWork(zones []string) error
+	// stopRemoteWork closes all the valves.
+	stopRemoteWork() error
+	// openAll opens all the valves, the safe state when the system stops.
+	openAll() error
+	// close releases the driver.
+	close() error
+}
+
+// remoteWorker does the work of the remote robots.
+// The valves are opened from stateIdle and closed in stateStopping, after the pump.
+type remoteWorker struct {
+	name   string
+	remote valvesDriver
+	events *bus
+	status *systemStatus
+}
+
+// workRemoteRobots runs a remoteWorker on the events of the bus, until stopAndQuit.
+func workRemoteRobots(robotName string, remote valvesDriver, events *bus, status *systemStatus, waitRobots *sync.WaitGroup) {
+	commands := events.subscribe(robotName, 0)
 	defer waitRobots.Done()
 
-	for e := range commands {
-		switch e.Name {
+	w := &remoteWorker{name: robotName, remote: remote, events: events, status: status}
+	for ev := range commands.C {
+		if w.handle(ev) {
+			events.unsubscribe(commands)
+			return
+		}
+	}
+
+}
 
-		case startRemoteRobots: // Here we start remote robots.
-			// Try to start the remote robots.
-			err = doRemoteWork()
+// handle does the work of an event, it returns true when the worker quits.
+func (w *remoteWorker) handle(ev event) bool {
+	var err error
+	switch e := ev.(type) {
+
+	case startZonesEvent: // Here we start remote robots.
+		zones := e.zones
+		if err = w.status.cycle.to(stateOpeningValves, fmt.Sprintf("open zones %v", zones)); err != nil {
+			log.Printf("robot '%s' can't open the valves: %v\nThis schedule will be skipped...", w.name, err)
+			return false
+		}
+		// Try to start the remote robots.
+		err = w.remote.doRemoteWork(zones)
+		if err != nil {
+			log.Printf("unable to open zones %v on robot '%s': %v\nThis schedule will be skipped...", zones, w.name, err)
+			w.status.cycle.toFrom(stateOpeningValves, stateIdle, fmt.Sprintf("valves not opened: %v", err))
+			w.events.publish(remoteFailureEvent{err})
+			return false
+		}
+		w.status.setZones(zones)
+		// If everythings goes well we are going to start local robots.
+		if err = w.status.cycle.toFrom(stateOpeningValves, statePumpStarting, "valves confirmed"); err != nil {
+			// The cycle has been stopped meanwhile, the stop will close the valves.
+			log.Printf("robot '%s' opened the valves but %v\n", w.name, err)
+			return false
+		}
+		w.events.publish(startPumpEvent{})
+
+	case changeZonesEvent: // Here we change the valves opened by remote robots.
+		zones := e.zones
+		if state, _ := w.status.cycle.current(); state != statePumpStarting && state != stateRunning {
+			log.Printf("robot '%s' can't change the zones in state '%s', skip...", w.name, state)
+			return false
+		}
+		err = w.remote.doRemoteWork(zones)
+		if err != nil {
+			// We don't know which valves are open: for security reason we stop everything.
+			log.Printf("unable to change zones to %v on robot '%s': %v\nThe system will be stopped...", zones, w.name, err)
+			w.events.publish(stopEvent{stopLocal})
+			w.events.publish(remoteFailureEvent{err})
+		} else {
+			w.status.setZones(zones)
+		}
+
+	case stopEvent: // Here we stop remote robots.
+		statusExit := e.signal
+		if statusExit == stopAndQuit {
+			// Leave the valves in the safe state.
+			if err = w.remote.openAll(); err != nil {
+				log.Printf("unable to open all the valves on robot '%s': %v", w.name, err)
+			}
+			w.remote.close()
+			return true
+		}
+
+		// The pump is off, the valves can be closed.
+		if statusExit == stopRemote {
+			if state, _ := w.status.cycle.current(); state != stateStopping {
+				log.Printf("robot '%s' can't close the valves in state '%s', skip...", w.name, state)
+				return false
+			}
+			err = w.remote.stopRemoteWork()
 			if err != nil {
-				log.Printf("unable to '%s' on robot '%s': %v\nThis schedule will be skipped...", e.Name, robotName, err)
+				// Unable to close the valves: for security reason we stop the system.
+				log.Printf("unable to stop robot '%s': %v\n", w.name, err)
+				raiseFault(w.events, w.status, faultRemote, fmt.Sprintf("remote failure: %v", err), -1)
 			} else {
-				// If everythings goes well we are going to start local robots.
-				eventer.Publish(startRelay, struct{}{})
+				w.status.setZones(nil)
+				w.status.cycle.toFrom(stateStopping, stateIdle, "valves closed")
 			}
 
+		}
 
-		case stopWorkers: // Here we stop remote robots.
-			statusExit, ok := e.Data.(StopSignal)
-			if !ok || statusExit == stopAndQuit {
-				// TODO: add some remote command to shutdown ?
-				eventer.Unsubscribe(commands)
-				return
+		if statusExit == stopFault {
+			// The safe state: if the pump starts, water comes out without damaging it.
+			err = w.remote.openAll()
+			if err != nil {
+				log.Printf("unable to open all the valves on robot '%s': %v", w.name, err)
+			} else {
+				w.status.setZones([]string{allZones})
 			}
+		}
 
-			if statusExit == stopRemote {
-				// TODO: try to shutdown remote robots
-				err = stopRemoteWork()
-				if err != nil {
-					eventer.Publish(stopWorkers, stopLocal)
-				} else {
-					eventer.Publish(stopWorkers, stopAndQuit)
-				}
+	}
+	return false
+}
 
-			}
+// doRemoteWork opens the valves of the zones and closes the others.
+// The zone allZones opens every valve.
+// It returns when the remote robots have acknowledged the new state.
+func (rr *remoteRobots) doRemoteWork(zones []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
 
+	if len(zones) == 1 && zones[0] == allZones {
+		resp, err := rr.client.OpenAll(ctx, &valves.OpenAllRequest{})
+		if err != nil {
+			return err
 		}
+		return checkValves(resp, nil, true)
 	}
 
+	resp, err := rr.client.Open(ctx, &valves.OpenRequest{Valves: zones, Exclusive: true})
+	if err != nil {
+		return err
+	}
+	return checkValves(resp, zones, false)
 }
 
-func doRemoteWork() error {
-	// TODO: call using gRPC, the remote robots and wait the response
-	return nil
+// stopRemoteWork closes all the valves.
+func (rr *remoteRobots) stopRemoteWork() error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+
+	resp, err := rr.client.Close(ctx, &valves.CloseRequest{})
+	if err != nil {
+		return err
+	}
+	return checkValves(resp, nil, false)
+}
+
+// openAll opens all the valves, the safe state when the system stops.
+func (rr *remoteRobots) openAll() error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+
+	resp, err := rr.client.OpenAll(ctx, &valves.OpenAllRequest{})
+	if err != nil {
+		return err
+	}
+	return checkValves(resp, nil, true)
+}
+
+// close closes the connection to the remote robots.
+func (rr *remoteRobots) close() error {
+	return rr.conn.Close()
 }
 
-func stopRemoteWork() error {
-	// TODO: call using gRPC, the remote robots, and wait the response
+// checkValves checks that the state returned by the remote robots is the wanted one:
+// the valves of open are open, the others are open only if others is true.
+func checkValves(state *valves.ValvesState, open []string, others bool) error {
+	want := make(map[string]bool)
+	for _, v := range open {
+		want[v] = true
+	}
+
+	for _, v := range state.GetValves() {
+		expected := others || want[v.GetName()]
+		if v.GetOpen() != expected {
+			return fmt.Errorf("valve '%s' open=%v, want %v", v.GetName(), v.GetOpen(), expected)
+		}
+		delete(want, v.GetName())
+	}
+	for v := range want {
+		return fmt.Errorf("valve '%s' unknown to the remote robots", v)
+	}
 	return nil
 }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/tux-eithel/PIrrigation_system/valves"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeValves is an in memory valves.ValvesServer.
+type fakeValves struct {
+	valves.UnimplementedValvesServer
+	open map[string]bool
+	sync.Mutex
+}
+
+func (f *fakeValves) Open(ctx context.Context, req *valves.OpenRequest) (*valves.ValvesState, error) {
+	f.Lock()
+	defer f.Unlock()
+	if req.GetExclusive() {
+		for name := range f.open {
+			f.open[name] = false
+		}
+	}
+	for _, name := range req.GetValves() {
+		if _, ok := f.open[name]; ok {
+			f.open[name] = true
+		}
+	}
+	return f.state(), nil
+}
+
+func (f *fakeValves) Close(ctx context.Context, req *valves.CloseRequest) (*valves.ValvesState, error) {
+	f.Lock()
+	defer f.Unlock()
+	for name := range f.open {
+		f.open[name] = false
+	}
+	return f.state(), nil
+}
+
+func (f *fakeValves) OpenAll(ctx context.Context, req *valves.OpenAllRequest) (*valves.ValvesState, error) {
+	f.Lock()
+	defer f.Unlock()
+	for name := range f.open {
+		f.open[name] = true
+	}
+	return f.state(), nil
+}
+
+func (f *fakeValves) Health(ctx context.Context, req *valves.HealthRequest) (*valves.HealthResponse, error) {
+	return &valves.HealthResponse{Ok: true}, nil
+}
+
+func (f *fakeValves) state() *valves.ValvesState {
+	resp := &valves.ValvesState{}
+	for name, open := range f.open {
+		resp.Valves = append(resp.Valves, &valves.ValveState{Name: name, Open: open})
+	}
+	sort.Slice(resp.Valves, func(i, j int) bool { return resp.Valves[i].Name < resp.Valves[j].Name })
+	return resp
+}
+
+// newTestRemoteRobots returns a remoteRobots connected to a fakeValves with the given valves.
+func newTestRemoteRobots(t *testing.T, names ...string) (*remoteRobots, *fakeValves) {
+	fake := &fakeValves{open: make(map[string]bool)}
+	for _, name := range names {
+		fake.open[name] = false
+	}
+
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	valves.RegisterValvesServer(server, fake)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &remoteRobots{conn: conn, client: valves.NewValvesClient(conn)}, fake
+}
+
+func Test_remoteRobots_doRemoteWork(t *testing.T) {
+	tests := []struct {
+		name     string
+		zones    []string
+		wantOpen map[string]bool
+		wantErr  bool
+	}{
+		{"one zone", []string{"front"}, map[string]bool{"back": false, "front": true}, false},
+		{"all zones", []string{allZones}, map[string]bool{"back": true, "front": true}, false},
+		{"unknown zone", []string{"garage"}, map[string]bool{"back": false, "front": false}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr, fake := newTestRemoteRobots(t, "back", "front")
+			err := rr.doRemoteWork(tt.zones)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("want error %v, got %v", tt.wantErr, err)
+				return
+			}
+			for name, open := range tt.wantOpen {
+				if fake.open[name] != open {
+					t.Errorf("valve %s open = %v, want %v", name, fake.open[name], open)
+				}
+			}
+		})
+	}
+}
+
+func Test_remoteRobots_stopRemoteWork(t *testing.T) {
+	rr, fake := newTestRemoteRobots(t, "back", "front")
+	if err := rr.openAll(); err != nil {
+		t.Fatalf("openAll() error = %v", err)
+	}
+	if err := rr.stopRemoteWork(); err != nil {
+		t.Fatalf("stopRemoteWork() error = %v", err)
+	}
+	for name, open := range fake.open {
+		if open {
+			t.Errorf("valve %s open = %v, want false", name, open)
+		}
+	}
+}
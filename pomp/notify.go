@@ -0,0 +1,66 @@
+package main
+
+import "sync"
+
+// changeNotifier tells its subscribers that something changed.
+// Notifications are coalesced: a subscriber which hasn't read the last one yet gets a single
+// notification for all the changes meanwhile, so notify never blocks, also without subscribers.
+// A subscriber reads the current state after every notification.
+type changeNotifier struct {
+	subs map[<-chan struct{}]chan struct{}
+	sync.Mutex
+}
+
+// newChangeNotifier returns a changeNotifier without subscribers.
+func newChangeNotifier() *changeNotifier {
+	return &changeNotifier{subs: make(map[<-chan struct{}]chan struct{})}
+}
+
+// subscribe returns a channel which receives the notifications.
+// It's thread safe.
+func (n *changeNotifier) subscribe() <-chan struct{} {
+	n.Lock()
+	defer n.Unlock()
+
+	c := make(chan struct{}, 1)
+	n.subs[c] = c
+	return c
+}
+
+// unsubscribe stops the notifications on c. The channel is not closed:
+// a pending notification could still be read.
+// It's thread safe.
+func (n *changeNotifier) unsubscribe(c <-chan struct{}) {
+	n.Lock()
+	defer n.Unlock()
+
+	delete(n.subs, c)
+}
+
+// notify sends a notification to every subscriber without waiting them.
+// It's thread safe.
+func (n *changeNotifier) notify() {
+	n.Lock()
+	defer n.Unlock()
+
+	for _, c := range n.subs {
+		select {
+		case c <- struct{}{}:
+		default: // A notification is already pending.
+		}
+	}
+}
+
+// Subscribe returns a channel notified when the schedule changes: times, rules or pause.
+// The notifications are coalesced and never block the manager, after a notification
+// the subscriber reads the schedule again.
+// It's thread safe.
+func (wtm *waterTimeManager) Subscribe() <-chan struct{} {
+	return wtm.changes.subscribe()
+}
+
+// Unsubscribe stops the notifications on c.
+// It's thread safe.
+func (wtm *waterTimeManager) Unsubscribe(c <-chan struct{}) {
+	wtm.changes.unsubscribe(c)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_changeNotifier(t *testing.T) {
+
+	n := newChangeNotifier()
+	// Without subscribers notify doesn't block.
+	n.notify()
+
+	first, second := n.subscribe(), n.subscribe()
+	// Many changes, nobody reading: one notification each.
+	for i := 0; i < 10; i++ {
+		n.notify()
+	}
+	for _, c := range []<-chan struct{}{first, second} {
+		select {
+		case <-c:
+		default:
+			t.Fatalf("notification not received")
+		}
+		select {
+		case <-c:
+			t.Fatalf("notifications not coalesced")
+		default:
+		}
+	}
+
+	n.unsubscribe(first)
+	n.notify()
+	select {
+	case <-first:
+		t.Errorf("notification received after unsubscribe")
+	case <-second:
+	case <-time.After(time.Second):
+		t.Errorf("notification not received")
+	}
+}
+
+func Test_waterTimeManager_Subscribe(t *testing.T) {
+
+	start := time.Date(2018, 8, 21, 6, 0, 0, 0, siteTZ.loc)
+	wtm := newWaterTimeManager(withClock(newFakeClock(start)))
+	changes := wtm.Subscribe()
+	defer wtm.Unsubscribe(changes)
+
+	// Nobody reads the notifications: the manager goes on.
+	for i := 0; i < 3; i++ {
+		wt := &waterTime{start: start.Add(time.Duration(i) * time.Hour), end: start.Add(time.Duration(i)*time.Hour + 10*time.Minute)}
+		if _, err := wtm.Append(wt); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+	if err := wtm.Pause(time.Time{}, "rain"); err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+
+	select {
+	case <-changes:
+	default:
+		t.Errorf("changes not notified")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// pause suspends the whole schedule without removing anything from the queue.
+// The slots running while the schedule is paused are skipped.
+type pause struct {
+	since time.Time
+	// until is the automatic resume, zero if the schedule waits Resume.
+	until  time.Time
+	reason string
+}
+
+// String returns a human readable version of the pause.
+func (p *pause) String() string {
+	if p.until.IsZero() {
+		return fmt.Sprintf("paused (%s) until resumed", p.reason)
+	}
+	return fmt.Sprintf("paused (%s) until %s", p.reason, p.until.Format(parseTimeConst))
+}
+
+// Pause suspends the schedule until the given time, or until Resume if it's zero.
+// A new pause replaces the current one.
+// It notify the changes to the subscribers.
+// It's thread safe.
+func (wtm *waterTimeManager) Pause(until time.Time, reason string) error {
+	wtm.Lock()
+	defer wtm.Unlock()
+
+	now := wtm.clock.Now()
+	if !until.IsZero() && !until.After(now) {
+		return fmt.Errorf("resume time is before Now: %v - %v", until, now)
+	}
+
+	wtm.pause = &pause{since: now, until: until, reason: reason}
+	log.Printf("schedule %v", wtm.pause)
+	wtm.persist()
+
+	// Notify listeners that the queue is changed
+	wtm.changes.notify()
+	return nil
+}
+
+// Resume ends the pause of the schedule.
+// It notify the changes to the subscribers.
+// It's thread safe.
+func (wtm *waterTimeManager) Resume() error {
+	wtm.Lock()
+	defer wtm.Unlock()
+
+	if wtm.pause == nil {
+		return &conflictError{msg: "the schedule is not paused"}
+	}
+
+	log.Printf("schedule resumed, it was %v", wtm.pause)
+	wtm.pause = nil
+	wtm.persist()
+
+	// Notify listeners that the queue is changed
+	wtm.changes.notify()
+	return nil
+}
+
+// Paused returns a copy of the current pause, nil if the schedule is running.
+// It's thread safe.
+func (wtm *waterTimeManager) Paused() *pause {
+	wtm.RLock()
+	defer wtm.RUnlock()
+
+	if wtm.pause == nil {
+		return nil
+	}
+	p := *wtm.pause
+	return &p
+}
+
+// expirePause ends the pause if its resume time is passed.
+// It returns true if the pause has been ended.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) expirePause(now time.Time) bool {
+	if wtm.pause == nil || wtm.pause.until.IsZero() || now.Before(wtm.pause.until) {
+		return false
+	}
+	log.Printf("schedule resumed automatically, it was %v", wtm.pause)
+	wtm.pause = nil
+	return true
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_waterTimeManager_Pause(t *testing.T) {
+
+	store := newScheduleStore(filepath.Join(t.TempDir(), "schedule.json"))
+
+	start := time.Date(2018, 8, 21, 6, 0, 0, 0, time.UTC)
+	fc := newFakeClock(start)
+	wtm := newWaterTimeManager(withClock(fc), withStore(store))
+
+	err := wtm.Pause(start.Add(-time.Hour), "rain")
+	if err == nil {
+		t.Errorf("Pause() in the past want error, got nil")
+	}
+	if err = wtm.Resume(); err == nil {
+		t.Errorf("Resume() without pause want error, got nil")
+	}
+
+	until := start.Add(72 * time.Hour)
+	if err = wtm.Pause(until, "rain"); err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+
+	// The pause survives a restart.
+	reloaded := newWaterTimeManager(withClock(fc), withStore(store))
+	if err = reloaded.Load(); err != nil {
+		t.Fatalf("unable to load the schedule: %v", err)
+	}
+	if p := reloaded.Paused(); p == nil || !p.until.Equal(until) || p.reason != "rain" {
+		t.Fatalf("reloaded pause = %v, want rain until %v", p, until)
+	}
+
+	// The automatic resume is the next change, then the pause ends.
+	if _, next := wtm.nextChange(); !next.Equal(until) {
+		t.Errorf("next change = %v, want %v", next, until)
+	}
+	fc.AdvanceTo(until)
+	wtm.nextChange()
+	if p := wtm.Paused(); p != nil {
+		t.Errorf("pause = %v after its end, want nil", p)
+	}
+
+	// A pause without end waits Resume.
+	if err = wtm.Pause(time.Time{}, "holidays"); err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+	fc.Advance(365 * 24 * time.Hour)
+	if _, next := wtm.nextChange(); !next.IsZero() || wtm.Paused() == nil {
+		t.Errorf("pause without end = %v, next change %v", wtm.Paused(), next)
+	}
+	if err = wtm.Resume(); err != nil || wtm.Paused() != nil {
+		t.Errorf("Resume() error = %v, pause %v", err, wtm.Paused())
+	}
+}
+
+func Test_consumerSchedule_pause(t *testing.T) {
+
+	start := time.Date(2018, 8, 21, 6, 0, 0, 0, time.UTC)
+	ct := newConsumerTest(start)
+	fc, wtm := ct.fc, ct.wtm
+	wtm.insert(&waterTime{start: start, end: start.Add(20 * time.Minute), zones: []string{"a"}, id: wtm.nextID()})
+	wtm.insert(&waterTime{start: start.Add(60 * time.Minute), end: start.Add(70 * time.Minute), zones: []string{"b"}, id: wtm.nextID()})
+	wtm.pause = &pause{since: fc.Now(), until: start.Add(10 * time.Minute), reason: "rain"}
+	ct.run()
+	sub := ct.sub
+
+	// "a" starts while the schedule is paused: it's skipped,
+	// also if the schedule resumes before its end.
+	fc.AdvanceTo(start)
+	fc.WaitWaiters(1)
+	fc.AdvanceTo(start.Add(10 * time.Minute))
+	fc.WaitWaiters(1)
+	if p := wtm.Paused(); p != nil {
+		t.Fatalf("pause = %v after its end, want nil", p)
+	}
+	fc.AdvanceTo(start.Add(20 * time.Minute))
+
+	// "b" starts after the resume.
+	fc.WaitWaiters(1)
+	fc.AdvanceTo(start.Add(60 * time.Minute))
+	e := waitEvent(t, sub, startZonesEvent{})
+	if z := e.(startZonesEvent).zones; !sameZones(z, []string{"b"}) {
+		t.Errorf("zones after the pause = %v, want [b]", z)
+	}
+
+	// A pause stops the running slot.
+	fc.WaitWaiters(1)
+	if err := wtm.Pause(time.Time{}, "rain"); err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+	if e := waitEvent(t, sub, stopEvent{}); e != (stopEvent{stopLocal}) {
+		t.Errorf("stop signal after the pause = %v, want %v", e, stopLocal)
+	}
+
+	ct.stop()
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultHorizon is how far in the future rules are expanded into waterTimes.
+	defaultHorizon = 7 * 24 * time.Hour
+)
+
+// weekdayNames maps the short names accepted by parseWaterRule.
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// waterRule is a recurring watering rule.
+// The waterTimeManager expands every rule into concrete waterTimes.
+type waterRule struct {
+	// text is the rule as typed by the user.
+	text string
+	// weekdays when the rule is active. If it's empty the rule uses every.
+	weekdays map[time.Weekday]bool
+	// every is the number of days between two runs (1 means every day).
+	every int
+	// hour and minute of the start.
+	hour   int
+	minute int
+	// solar is the start relative to a solar event, it replaces hour and minute if it's not nil.
+	solar *solarAnchor
+	// duration of the watering.
+	duration time.Duration
+	// zones to open, if it's empty all the valves are opened.
+	zones []string
+	// soak splits the duration in pulses, nil if the water runs for the whole duration.
+	soak *soakPlan
+	// catchUp is the catch-up policy of the waterTimes, empty for the one of the manager.
+	catchUp catchUpPolicy
+	// anchor is the first day of the rule, used to count the "every" days.
+	anchor time.Time
+	// expanded is the time until the rule has already been expanded.
+	expanded time.Time
+}
+
+// parseWaterRule returns a new waterRule parsing a string like:
+//
+//	every day at 06:00 for 20m
+//	mon/wed/fri at 19:30 for 15m
+//	every 3 days at 07:00 for 10m
+//	every day at 21:00 for 5m zones front,back
+//	every day at sunrise-30m for 20m
+//	every day at 06:00 for 40m zones front soak 8m/15m
+//	every day at 06:00 for 20m catchup remaining
+//
+// The anchor is the day used to count the "every N days" rules.
+// It may return an error if parsing goes bad.
+func parseWaterRule(row string, anchor time.Time) (*waterRule, error) {
+	fields := strings.Fields(strings.ToLower(row))
+	r := &waterRule{
+		text:     strings.Join(fields, " "),
+		weekdays: make(map[time.Weekday]bool),
+		every:    1,
+	}
+
+	if len(fields) > 2 && fields[len(fields)-2] == "catchup" {
+		policy, err := parseCatchUpPolicy(fields[len(fields)-1])
+		if err != nil {
+			return nil, err
+		}
+		r.catchUp = policy
+		fields = fields[:len(fields)-2]
+	}
+
+	if len(fields) > 2 && fields[len(fields)-2] == "soak" {
+		plan, err := parseSoakPlan(fields[len(fields)-1])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse soak: %v", err)
+		}
+		r.soak = plan
+		fields = fields[:len(fields)-2]
+	}
+
+	if len(fields) > 2 && fields[len(fields)-2] == "zones" {
+		zones, err := parseZones(fields[len(fields)-1])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse zones: %v", err)
+		}
+		r.zones = zones
+		fields = fields[:len(fields)-2]
+	}
+
+	if len(fields) < 5 || fields[len(fields)-4] != "at" || fields[len(fields)-2] != "for" {
+		return nil, fmt.Errorf("rule must be '<days> at <HH:MM|sunrise|sunset|dawn|dusk[+-offset]> for <duration> [zones <z1,z2>] [soak <run>/<soak>] [catchup <policy>]'")
+	}
+
+	days := fields[:len(fields)-4]
+	switch {
+	case len(days) == 2 && days[0] == "every" && days[1] == "day":
+	case len(days) == 3 && days[0] == "every" && days[2] == "days":
+		every, err := strconv.Atoi(days[1])
+		if err != nil || every < 1 {
+			return nil, fmt.Errorf("invalid number of days '%s'", days[1])
+		}
+		r.every = every
+	case len(days) == 1:
+		for _, name := range strings.FieldsFunc(days[0], func(c rune) bool { return c == '/' || c == ',' }) {
+			wd, ok := weekdayNames[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown week day '%s'", name)
+			}
+			r.weekdays[wd] = true
+		}
+		if len(r.weekdays) == 0 {
+			return nil, fmt.Errorf("no week days in '%s'", days[0])
+		}
+	default:
+		return nil, fmt.Errorf("unable to parse days '%s'", strings.Join(days, " "))
+	}
+
+	at, err := time.Parse("15:04", fields[len(fields)-3])
+	if err == nil {
+		r.hour, r.minute = at.Hour(), at.Minute()
+	} else if solar, errSolar := parseSolarAnchor(fields[len(fields)-3]); errSolar == nil {
+		if siteSun == nil {
+			return nil, fmt.Errorf("unable to use '%s': the position of the garden is not configured", solar)
+		}
+		r.solar = &solar
+	} else {
+		return nil, fmt.Errorf("unable to parse start hour: %v", err)
+	}
+
+	r.duration, err = time.ParseDuration(fields[len(fields)-1])
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse duration: %v", err)
+	}
+	if r.duration <= 0 || r.duration >= 24*time.Hour {
+		return nil, fmt.Errorf("duration must be between 0 and 24h: %v", r.duration)
+	}
+
+	y, m, d := anchor.Date()
+	r.anchor = time.Date(y, m, d, 0, 0, 0, 0, anchor.Location())
+
+	return r, nil
+}
+
+// String returns the rule as typed by the user.
+func (r *waterRule) String() string {
+	return r.text
+}
+
+// horizon returns how far the rule must be expanded.
+// A rule running every N days needs at least N days, otherwise
+// the schedule could remain empty and nobody will expand it again.
+func (r *waterRule) horizon(min time.Duration) time.Duration {
+	if d := time.Duration(r.every) * 24 * time.Hour; d > min {
+		return d
+	}
+	return min
+}
+
+// matchDay returns true if the rule is active on the day y-m-d.
+func (r *waterRule) matchDay(y int, m time.Month, d int) bool {
+	if len(r.weekdays) > 0 {
+		return r.weekdays[time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Weekday()]
+	}
+	// Days are counted in UTC, so DST changes don't move the count.
+	ay, am, ad := r.anchor.Date()
+	days := int(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Sub(time.Date(ay, am, ad, 0, 0, 0, 0, time.UTC)).Hours() / 24)
+	return days >= 0 && days%r.every == 0
+}
+
+// occurrences returns the waterTimes of the rule starting in the range [from, to).
+// Start times are wall clock times of the site, resolved by its dst policy,
+// or the solar events of every day.
+func (r *waterRule) occurrences(from, to time.Time) []*waterTime {
+	times := make([]*waterTime, 0)
+
+	// Days are counted in UTC, so DST changes don't move them.
+	y, m, d := from.In(siteTZ.loc).Date()
+	first := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	y, m, d = to.In(siteTZ.loc).Date()
+	last := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		if !r.matchDay(day.Date()) {
+			continue
+		}
+		var start time.Time
+		var err error
+		if r.solar != nil {
+			start, err = r.solar.time(day.Date())
+		} else {
+			start, err = siteTZ.date(day.Year(), day.Month(), day.Day(), r.hour, r.minute, 0)
+		}
+		if err != nil {
+			log.Printf("rule '%s' skips %s: %v", r, day.Format("2006-01-02"), err)
+			continue
+		}
+		if start.Before(from) || !start.Before(to) {
+			continue
+		}
+		end := start.Add(r.duration)
+		if r.soak != nil {
+			end = start.Add(r.soak.wallTime(r.duration))
+		}
+		times = append(times, &waterTime{start: start, end: end, zones: r.zones, soak: r.soak, catchUp: r.catchUp})
+	}
+
+	return times
+}
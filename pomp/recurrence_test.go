@@ -0,0 +1,190 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_parseWaterRule(t *testing.T) {
+
+	loc, _ := time.LoadLocation("Europe/Berlin")
+	anchor := time.Date(2018, 8, 21, 10, 49, 0, 0, loc)
+
+	tests := []struct {
+		name     string
+		row      string
+		every    int
+		weekdays []time.Weekday
+		hour     int
+		minute   int
+		duration time.Duration
+		wantErr  bool
+	}{
+		{name: "every day", row: "every day at 06:00 for 20m", every: 1, hour: 6, duration: 20 * time.Minute},
+		{name: "every 3 days", row: "Every 3 days at 07:15 for 10m", every: 3, hour: 7, minute: 15, duration: 10 * time.Minute},
+		{name: "week days", row: "Mon/Wed/Fri at 19:30 for 15m", every: 1, weekdays: []time.Weekday{time.Monday, time.Wednesday, time.Friday}, hour: 19, minute: 30, duration: 15 * time.Minute},
+		{name: "week days with comma", row: "sat,sun at 19:30 for 1h", every: 1, weekdays: []time.Weekday{time.Saturday, time.Sunday}, hour: 19, minute: 30, duration: time.Hour},
+		{name: "missing for", row: "every day at 06:00", wantErr: true},
+		{name: "wrong hour", row: "every day at 6 for 20m", wantErr: true},
+		{name: "wrong duration", row: "every day at 06:00 for 20", wantErr: true},
+		{name: "negative duration", row: "every day at 06:00 for -20m", wantErr: true},
+		{name: "zero days", row: "every 0 days at 06:00 for 20m", wantErr: true},
+		{name: "wrong week day", row: "mon/xyz at 06:00 for 20m", wantErr: true},
+		{name: "wrong days", row: "every week at 06:00 for 20m", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseWaterRule(tt.row, anchor)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unable to parse the rule = %v", err)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.every != tt.every {
+				t.Errorf("every value = %d, want %d", got.every, tt.every)
+			}
+			if len(got.weekdays) != len(tt.weekdays) {
+				t.Errorf("week days = %v, want %v", got.weekdays, tt.weekdays)
+			}
+			for _, wd := range tt.weekdays {
+				if !got.weekdays[wd] {
+					t.Errorf("missing week day %v", wd)
+				}
+			}
+			if got.hour != tt.hour || got.minute != tt.minute {
+				t.Errorf("start = %02d:%02d, want %02d:%02d", got.hour, got.minute, tt.hour, tt.minute)
+			}
+			if got.duration != tt.duration {
+				t.Errorf("duration = %v, want %v", got.duration, tt.duration)
+			}
+			if !got.anchor.Equal(time.Date(2018, 8, 21, 0, 0, 0, 0, loc)) {
+				t.Errorf("anchor = %v, want the start of the day", got.anchor)
+			}
+		})
+	}
+}
+
+func Test_waterRule_occurrences(t *testing.T) {
+
+	loc, _ := time.LoadLocation("Europe/Berlin")
+	// 2018-08-21 is a Tuesday.
+	anchor := time.Date(2018, 8, 21, 10, 49, 0, 0, loc)
+
+	tests := []struct {
+		name string
+		row  string
+		from time.Time
+		to   time.Time
+		want []time.Time
+	}{
+		{
+			"every day skips the past",
+			"every day at 06:00 for 20m",
+			anchor,
+			anchor.AddDate(0, 0, 3),
+			[]time.Time{
+				time.Date(2018, 8, 22, 6, 0, 0, 0, loc),
+				time.Date(2018, 8, 23, 6, 0, 0, 0, loc),
+				time.Date(2018, 8, 24, 6, 0, 0, 0, loc),
+			},
+		},
+		{
+			"every 3 days",
+			"every 3 days at 12:00 for 20m",
+			anchor,
+			anchor.AddDate(0, 0, 7),
+			[]time.Time{
+				time.Date(2018, 8, 21, 12, 0, 0, 0, loc),
+				time.Date(2018, 8, 24, 12, 0, 0, 0, loc),
+				time.Date(2018, 8, 27, 12, 0, 0, 0, loc),
+			},
+		},
+		{
+			"week days",
+			"mon/wed/fri at 19:30 for 15m",
+			anchor,
+			anchor.AddDate(0, 0, 7),
+			[]time.Time{
+				time.Date(2018, 8, 22, 19, 30, 0, 0, loc),
+				time.Date(2018, 8, 24, 19, 30, 0, 0, loc),
+				time.Date(2018, 8, 27, 19, 30, 0, 0, loc),
+			},
+		},
+		{
+			"across DST change",
+			"every day at 06:00 for 20m",
+			time.Date(2018, 10, 27, 7, 0, 0, 0, loc),
+			time.Date(2018, 10, 29, 7, 0, 0, 0, loc),
+			[]time.Time{
+				time.Date(2018, 10, 28, 6, 0, 0, 0, loc),
+				time.Date(2018, 10, 29, 6, 0, 0, 0, loc),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseWaterRule(tt.row, anchor)
+			if err != nil {
+				t.Fatalf("unable to parse the rule = %v", err)
+			}
+			got := r.occurrences(tt.from, tt.to)
+			if len(got) != len(tt.want) {
+				t.Fatalf("len expected %d, got %d", len(tt.want), len(got))
+			}
+			for i := range tt.want {
+				if !got[i].start.Equal(tt.want[i]) {
+					t.Errorf("wrong start at position %d, want %v; got %v", i, tt.want[i], got[i].start)
+				}
+				if got[i].end.Sub(got[i].start) != r.duration {
+					t.Errorf("wrong duration at position %d, want %v; got %v", i, r.duration, got[i].end.Sub(got[i].start))
+				}
+			}
+		})
+	}
+}
+
+func Test_waterTimeManager_AddRule(t *testing.T) {
+
+	wtm := newWaterTimeManager()
+	wtm.horizon = 3 * 24 * time.Hour
+
+	// A manual time which collides with the rule of tomorrow.
+	now := time.Now()
+	y, m, d := now.AddDate(0, 0, 1).Date()
+	tomorrow := time.Date(y, m, d, 6, 10, 0, 0, siteTZ.loc)
+	if _, err := wtm.Append(&waterTime{start: tomorrow, end: tomorrow.Add(time.Hour)}); err != nil {
+		t.Fatalf("unable to append the time = %v", err)
+	}
+
+	r, err := parseWaterRule("every day at 06:00 for 20m", now.In(siteTZ.loc))
+	if err != nil {
+		t.Fatalf("unable to parse the rule = %v", err)
+	}
+
+	added, err := wtm.AddRule(r)
+	if err != nil {
+		t.Fatalf("unable to add the rule = %v", err)
+	}
+	if added < 2 || added > 3 {
+		t.Errorf("added expected between 2 and 3, got %d", added)
+	}
+	if len(wtm.times) != added+1 {
+		t.Errorf("len expected %d, got %d", added+1, len(wtm.times))
+	}
+	for i := 1; i < len(wtm.times); i++ {
+		if wtm.times[i].start.Before(wtm.times[i-1].end) {
+			t.Errorf("times %v and %v overlap", wtm.times[i-1], wtm.times[i])
+		}
+	}
+
+	// Expanding again, the rule must not duplicate the times.
+	if got := wtm.expandRules(now); got != 0 {
+		t.Errorf("expand again expected 0, got %d", got)
+	}
+	// Moving forward, the rule adds one more day.
+	if got := wtm.expandRules(now.AddDate(0, 0, 1)); got != 1 {
+		t.Errorf("expand next day expected 1, got %d", got)
+	}
+}
@@ -1,117 +1,206 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"sync"
-	"time"
 
-	"gobot.io/x/gobot"
-	"gobot.io/x/gobot/drivers/gpio"
-	"gobot.io/x/gobot/drivers/spi"
+	"github.com/tux-eithel/PIrrigation_system/config"
 )
 
-// workRelay does the raley work.
-func workRelay(robotName string, relay *gpio.RelayDriver, eventer gobot.Eventer, waitRobots *sync.WaitGroup) {
-	commands := eventer.Subscribe()
-	var err error
+// raiseFault latches a fault in the status and brings the system in the safe state.
+// The process stays up, skipping the slots, until the operator acknowledges the fault.
+func raiseFault(events *bus, status *systemStatus, code faultCode, reason string, value int) {
+	if !status.raiseFault(code, reason, value) {
+		log.Printf("fault '%s' (%s) while another fault is active, ignored", code, reason)
+		return
+	}
+	log.Printf("FAULT '%s': %s... the system is stopped until the fault is acknowledged", code, reason)
+	events.publish(stopEvent{stopFault})
+}
+
+// relayWorker does the raley work.
+// The pump starts only when the valves have confirmed (statePumpStarting)
+// and stops before the valves are closed.
+type relayWorker struct {
+	name   string
+	relay  pump
+	events *bus
+	status *systemStatus
+}
+
+// ackFault acknowledges the latched fault and closes the valves left open by the safe state:
+// the cycle is back in stateIdle when they are closed.
+func ackFault(events *bus, status *systemStatus) (*fault, error) {
+	f, err := status.ackFault()
+	if err != nil {
+		return nil, err
+	}
+	events.publish(stopEvent{stopRemote})
+	return f, nil
+}
+
+// workRelay runs a relayWorker on the events of the bus, until stopAndQuit.
+func workRelay(robotName string, relay pump, events *bus, status *systemStatus, waitRobots *sync.WaitGroup) {
+	commands := events.subscribe(robotName, 0)
 	defer waitRobots.Done()
 
-	for e := range commands {
-		switch e.Name {
+	w := &relayWorker{name: robotName, relay: relay, events: events, status: status}
+	for ev := range commands.C {
+		if w.handle(ev) {
+			events.unsubscribe(commands)
+			return
+		}
+	}
+}
 
-		// Here we start the relay.
-		// If all goes well we are going to start the MCP
-		case startRelay:
-			err = relay.Off()
-			if err != nil {
-				log.Printf("unable to '%s' on robots '%s': %v\n", e.Name, robotName, err)
-			} else {
-				log.Println("start relay!")
-				eventer.Publish(startMCP, struct{}{})
+// handle does the work of an event, it returns true when the worker quits.
+func (w *relayWorker) handle(ev event) bool {
+	var err error
+	switch e := ev.(type) {
+
+	// Here we start the relay.
+	// If all goes well we are going to start the MCP
+	case startPumpEvent:
+		if state, _ := w.status.cycle.current(); state != statePumpStarting {
+			log.Printf("robot '%s' can't start the pump in state '%s', skip...\n", w.name, state)
+			return false
+		}
+		err = w.relay.Start()
+		if err != nil {
+			log.Printf("unable to start the pump on robots '%s': %v\n", w.name, err)
+			// The valves are open: close them.
+			if err = w.status.cycle.to(stateStopping, "pump not started"); err == nil {
+				w.events.publish(stopEvent{stopRemote})
 			}
+			return false
+		}
+		w.status.setPump(true)
+		if err = w.status.cycle.toFrom(statePumpStarting, stateRunning, "pump started"); err != nil {
+			// Something stopped the cycle meanwhile: the pump must be off.
+			log.Printf("robot '%s' started the pump but %v, stop it\n", w.name, err)
+			if err = w.relay.Stop(); err == nil {
+				w.status.setPump(false)
+			}
+			return false
+		}
+		log.Println("start relay!")
+		w.events.publish(startSensorEvent{})
 
-		// Here we stop the relay.
-		case stopWorkers:
+	// Here we stop the relay.
+	case stopEvent:
 
-			statusExit, ok := e.Data.(StopSignal)
-			if !ok {
-				eventer.Unsubscribe(commands)
-				return
-			}
+		statusExit := e.signal
+		if statusExit == stopLocal || statusExit == stopFault || statusExit == stopAndQuit {
+			// A stopLocal starts the stop of the cycle, if there is something to stop.
+			stopping := statusExit == stopLocal && w.status.cycle.to(stateStopping, "stop") == nil
 
-			if statusExit == stopLocal || statusExit == stopAndQuit {
-				err = relay.On()
-				if err != nil {
-					log.Printf("unable to '%s' on robots '%s': %v\n", e.Name, robotName, err)
-				} else {
-					log.Printf("robot '%s' will be '%s'\n", robotName, e.Name)
+			err = w.relay.Stop()
+			if err != nil {
+				log.Printf("unable to '%s' on robots '%s': %v\n", statusExit, w.name, err)
+				if statusExit != stopAndQuit {
+					raiseFault(w.events, w.status, faultPump, fmt.Sprintf("unable to stop the pump: %v", err), -1)
 				}
+			} else {
+				log.Printf("robot '%s' will be '%s'\n", w.name, statusExit)
+				w.status.setPump(false)
 
+				// The pump is off: now the valves can be closed.
+				if stopping {
+					w.events.publish(stopEvent{stopRemote})
+				}
 			}
+		}
 
-			if statusExit == stopAndQuit {
-				eventer.Unsubscribe(commands)
-				return
-			}
+		return statusExit == stopAndQuit
 
-		}
 	}
+	return false
 }
 
-// workMCP does the MCP work
-func workMCP(robotName string, mcp *spi.MCP3008Driver, eventer gobot.Eventer, waitRobots *sync.WaitGroup) {
-	commands := eventer.Subscribe()
-	var err error
-	var stopReadAnalogData chan struct{}
-	var analogData <-chan *gobot.Event
-	defer waitRobots.Done()
+// mcpWorker does the MCP work: it reads the sensor, on the clock of the status,
+// from the start of the pump until the system stops.
+type mcpWorker struct {
+	name   string
+	mcp    analogSensor
+	cfg    config.Sensor
+	events *bus
+	status *systemStatus
+	// stopReading stops the readings, it's nil when the sensor isn't read
+	stopReading func()
+}
 
-	for e := range commands {
-		switch e.Name {
+// workMCP runs a mcpWorker on the events of the bus, until stopAndQuit.
+func workMCP(robotName string, mcp analogSensor, cfg config.Sensor, events *bus, status *systemStatus, waitRobots *sync.WaitGroup) {
+	commands := events.subscribe(robotName, 0)
+	defer waitRobots.Done()
 
-		case startMCP:
-			if stopReadAnalogData != nil {
-				log.Printf("robot '%s' already started... skip!\n", robotName)
-				continue
-			}
-			log.Println("start mcp!")
-			go func() {
-				analogData, stopReadAnalogData = readsFromMCP(mcp, 250*time.Millisecond, 100)
-				for ae := range analogData {
-					switch ae.Name {
-					case gpio.Error:
-						err = ae.Data.(error)
-						log.Printf("robot '%s' unable to read value: %v... for security reason we are going to shut down the system!\n\n", robotName, err)
-						eventer.Publish(stopWorkers, stopAndQuit)
-						return
-					case gpio.Data:
-						value := ae.Data.(int)
-						log.Printf("robot '%s' seems like there is no water '%d'... we are going to shut down the system!\n", robotName, value)
-						eventer.Publish(stopWorkers, stopAndQuit)
-						return
-					}
-				}
-			}()
+	w := &mcpWorker{name: robotName, mcp: mcp, cfg: cfg, events: events, status: status}
+	for ev := range commands.C {
+		if w.handle(ev) {
+			events.unsubscribe(commands)
+			return
+		}
+	}
+}
 
-		// Here we are going to close the MCP
-		case stopWorkers:
+// handle does the work of an event, it returns true when the worker quits.
+func (w *mcpWorker) handle(ev event) bool {
+	switch e := ev.(type) {
 
-			statusExit, ok := e.Data.(StopSignal)
-			if !ok || statusExit == stopAndQuit {
-				eventer.Unsubscribe(commands)
-				return
-			}
+	case startSensorEvent:
+		if w.stopReading != nil {
+			log.Printf("robot '%s' already started... skip!\n", w.name)
+			return false
+		}
+		log.Println("start mcp!")
+		w.stopReading = readsFromMCP(w.mcp, w.cfg, w.status.clock, w.levels())
 
-			if statusExit == stopLocal {
-				if stopReadAnalogData != nil {
-					stopReadAnalogData <- struct{}{}
-					stopReadAnalogData = nil
-				}
+	// Here we are going to close the MCP
+	case stopEvent:
 
-				log.Printf("robot '%s' will be '%s'\n", robotName, stopWorkers)
+		statusExit := e.signal
+		if statusExit == stopLocal || statusExit == stopFault || statusExit == stopAndQuit {
+			if w.stopReading != nil {
+				w.stopReading()
+				w.stopReading = nil
 			}
 
+			log.Printf("robot '%s' will be '%s'\n", w.name, statusExit)
 		}
 
+		return statusExit == stopAndQuit
+
+	}
+	return false
+}
+
+// levels returns the handler of the values read, until the reading is stopped.
+// After a fault the values are still read, without raising other faults.
+func (w *mcpWorker) levels() func(sensorEvent) {
+	faulted := false
+	return func(ae sensorEvent) {
+		switch a := ae.(type) {
+		case levelReadingEvent:
+			w.status.setSensor(a.raw, a.level)
+		case levelRecoveredEvent:
+			log.Printf("robot '%s' water level recovered: %.1f%%\n", w.name, a.level)
+			w.status.setWaterLow(false)
+		case readErrorEvent:
+			if faulted {
+				return
+			}
+			faulted = true
+			log.Printf("robot '%s' unable to read value: %v... for security reason we are going to stop the system!\n\n", w.name, a.err)
+			raiseFault(w.events, w.status, faultReadError, fmt.Sprintf("read error: %v", a.err), -1)
+		case levelLowEvent:
+			w.status.setWaterLow(true)
+			if faulted {
+				return
+			}
+			faulted = true
+			log.Printf("robot '%s' seems like there is no water '%d' (%.1f%%)... we are going to stop the system!\n", w.name, a.raw, a.level)
+			raiseFault(w.events, w.status, faultNoWater, "no water", a.raw)
+		}
 	}
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// stopReason is why a slot stopped watering, or why it didn't start.
+// A fault stops the slots with the reason of its faultCode:
+// no_water, read_error, remote_failure or pump_failure.
+// Remote robots which fail to open the valves stop them with remote_failure too.
+type stopReason string
+
+const (
+	reasonScheduleEnd stopReason = "schedule_end"
+	// reasonManual is a slot stopped, removed or preempted by the operator.
+	reasonManual   stopReason = "manual_stop"
+	reasonPause    stopReason = "paused"
+	reasonBlackout stopReason = "blackout"
+	reasonBudget   stopReason = "budget"
+	// reasonShutdown is a slot running when pomp quits.
+	reasonShutdown stopReason = "shutdown"
+)
+
+// activeRun is a slot watering, with the pump time when it started.
+type activeRun struct {
+	record *runRecord
+	pump   time.Duration
+}
+
+// runLog follows the slots while they water and records them in the history when they end.
+type runLog struct {
+	history *runHistory
+	status  *systemStatus
+	runs    map[uint64]*activeRun
+	sync.Mutex
+}
+
+// newRunLog returns a runLog which writes to history, it reads the pump time
+// and the sensor values from status.
+func newRunLog(history *runHistory, status *systemStatus) *runLog {
+	l := &runLog{history: history, status: status, runs: make(map[uint64]*activeRun)}
+	status.onSensor(l.reading)
+	return l
+}
+
+// reading adds a value of the sensor to the runs in progress.
+// It's thread safe.
+func (l *runLog) reading(value int) {
+	l.Lock()
+	defer l.Unlock()
+
+	for _, r := range l.runs {
+		if r.record.SensorMin < 0 || value < r.record.SensorMin {
+			r.record.SensorMin = value
+		}
+		if value > r.record.SensorMax {
+			r.record.SensorMax = value
+		}
+	}
+}
+
+// update starts the runs of the active slots not skipped and ends the runs of the slots
+// no longer active: at their end, or stopped manually before.
+// It's thread safe.
+func (l *runLog) update(active []waterTime, skipped map[uint64]bool, now time.Time) {
+	l.Lock()
+	defer l.Unlock()
+
+	running := make(map[uint64]bool)
+	for _, t := range active {
+		if skipped[t.id] {
+			continue
+		}
+		running[t.id] = true
+		if r, ok := l.runs[t.id]; ok {
+			// The slot could have been changed while running.
+			r.record.PlannedEnd = t.end
+			continue
+		}
+		l.runs[t.id] = &activeRun{
+			record: &runRecord{Slot: t.id, Zones: t.zones, PlannedStart: t.start, PlannedEnd: t.end, Start: now, SensorMin: -1, SensorMax: -1},
+			pump:   l.status.pumpTime(),
+		}
+	}
+
+	for id, r := range l.runs {
+		if running[id] {
+			continue
+		}
+		reason := reasonManual
+		if !now.Before(r.record.PlannedEnd) {
+			reason = reasonScheduleEnd
+		}
+		l.end(id, now, reason)
+	}
+}
+
+// skip ends the run of t because of reason, or records that t didn't start.
+// It's thread safe.
+func (l *runLog) skip(t *waterTime, now time.Time, reason stopReason) {
+	l.Lock()
+	defer l.Unlock()
+
+	if _, ok := l.runs[t.id]; ok {
+		l.end(t.id, now, reason)
+		return
+	}
+	l.history.record(&runRecord{
+		At: now, Slot: t.id, Zones: t.zones, PlannedStart: t.start, PlannedEnd: t.end,
+		Outcome: outcomeSkipped, StopReason: reason, SensorMin: -1, SensorMax: -1,
+	})
+}
+
+// stopAll ends all the runs because of reason.
+// It's thread safe.
+func (l *runLog) stopAll(now time.Time, reason stopReason) {
+	l.Lock()
+	defer l.Unlock()
+
+	for id := range l.runs {
+		l.end(id, now, reason)
+	}
+}
+
+// end records the run of the slot id.
+// The caller must hold the lock.
+func (l *runLog) end(id uint64, now time.Time, reason stopReason) {
+	r := l.runs[id]
+	delete(l.runs, id)
+
+	r.record.At, r.record.End, r.record.StopReason = now, now, reason
+	r.record.PumpOn = l.status.pumpTime() - r.pump
+	r.record.Outcome = outcomeStopped
+	if reason == reasonScheduleEnd {
+		r.record.Outcome = outcomeCompleted
+	}
+	log.Printf("slot %d %s (%s): watered %v", id, r.record.Outcome, reason, r.record.End.Sub(r.record.Start))
+	l.history.record(r.record)
+}
+
+// historyRange returns the range of the days from and to (like 2018-08-21) of the site, to included.
+// Without from the range starts a week before now, without to it ends today.
+func historyRange(from, to string, now time.Time) (time.Time, time.Time, error) {
+	y, m, d := now.In(siteTZ.loc).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, siteTZ.loc)
+	start, end := today.AddDate(0, 0, -7), today
+	if from != "" {
+		t, err := time.ParseInLocation("2006-01-02", from, siteTZ.loc)
+		if err != nil {
+			return start, end, fmt.Errorf("unable to parse from: %v", err)
+		}
+		start = t
+	}
+	if to != "" {
+		t, err := time.ParseInLocation("2006-01-02", to, siteTZ.loc)
+		if err != nil {
+			return start, end, fmt.Errorf("unable to parse to: %v", err)
+		}
+		end = t
+	}
+	if end.Before(start) {
+		return start, end, fmt.Errorf("to is before from: %s - %s", end.Format("2006-01-02"), start.Format("2006-01-02"))
+	}
+	return start, end.AddDate(0, 0, 1), nil
+}
+
+// History returns the records of the slots planned to start in the range [from, to),
+// empty without history.
+// It's thread safe.
+func (wtm *waterTimeManager) History(from, to time.Time) []runRecord {
+	if wtm.history == nil {
+		return []runRecord{}
+	}
+	return wtm.history.Records(from, to)
+}
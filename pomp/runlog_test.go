@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_historyRange(t *testing.T) {
+	now := time.Date(2018, 8, 21, 15, 0, 0, 0, siteTZ.loc)
+	day := func(d int) time.Time { return time.Date(2018, 8, d, 0, 0, 0, 0, siteTZ.loc) }
+	tests := []struct {
+		name     string
+		from, to string
+		want     [2]time.Time
+		wantErr  bool
+	}{
+		{"last week", "", "", [2]time.Time{day(14), day(22)}, false},
+		{"one day", "2018-08-20", "2018-08-20", [2]time.Time{day(20), day(21)}, false},
+		{"from", "2018-08-19", "", [2]time.Time{day(19), day(22)}, false},
+		{"reversed", "2018-08-20", "2018-08-19", [2]time.Time{}, true},
+		{"bad day", "yesterday", "", [2]time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, err := historyRange(tt.from, tt.to, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("historyRange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (!from.Equal(tt.want[0]) || !to.Equal(tt.want[1])) {
+				t.Errorf("historyRange() = %v - %v, want %v - %v", from, to, tt.want[0], tt.want[1])
+			}
+		})
+	}
+}
+
+func Test_consumerSchedule_history(t *testing.T) {
+
+	start := time.Date(2018, 8, 21, 6, 0, 0, 0, siteTZ.loc)
+	ct := newConsumerTest(start, withHistory(newRunHistory("")))
+	fc, wtm, status := ct.fc, ct.wtm, ct.status
+	wtm.insert(&waterTime{start: start, end: start.Add(10 * time.Minute), zones: []string{"a"}, id: wtm.nextID()})
+	wtm.insert(&waterTime{start: start.Add(20 * time.Minute), end: start.Add(40 * time.Minute), zones: []string{"b"}, id: wtm.nextID()})
+	wtm.insert(&waterTime{start: start.Add(60 * time.Minute), end: start.Add(70 * time.Minute), zones: []string{"c"}, id: wtm.nextID()})
+	ct.run()
+	sub := ct.sub
+
+	// The first slot runs until its end, with the sensor read meanwhile.
+	fc.AdvanceTo(start)
+	waitEvent(t, sub, startZonesEvent{})
+	fc.WaitWaiters(1)
+	status.setSensor(600, 60)
+	status.setSensor(500, 50)
+	fc.AdvanceTo(start.Add(10 * time.Minute))
+	waitEvent(t, sub, stopEvent{})
+
+	// The second is stopped by the operator.
+	fc.WaitWaiters(1)
+	fc.AdvanceTo(start.Add(20 * time.Minute))
+	waitEvent(t, sub, startZonesEvent{})
+	fc.WaitWaiters(1)
+	fc.Advance(5 * time.Minute)
+	fc.WaitWaiters(1)
+	if _, err := wtm.StopNow(); err != nil {
+		t.Fatalf("StopNow() error = %v", err)
+	}
+	waitEvent(t, sub, stopEvent{})
+
+	// The third doesn't start because of a fault.
+	fc.WaitWaiters(1)
+	status.raiseFault(faultNoWater, "no water", 12)
+	fc.AdvanceTo(start.Add(60 * time.Minute))
+	fc.WaitWaiters(1)
+	ct.stop()
+
+	want := []struct {
+		outcome    runOutcome
+		reason     stopReason
+		start, end time.Time
+		min, max   int
+	}{
+		{outcomeCompleted, reasonScheduleEnd, start, start.Add(10 * time.Minute), 500, 600},
+		{outcomeStopped, reasonManual, start.Add(20 * time.Minute), start.Add(25 * time.Minute), -1, -1},
+		{outcomeSkipped, stopReason(faultNoWater), time.Time{}, time.Time{}, -1, -1},
+	}
+	got := wtm.History(start.Add(-time.Hour), start.Add(24*time.Hour))
+	if len(got) != len(want) {
+		t.Fatalf("History() = %+v, want %d records", got, len(want))
+	}
+	for i, w := range want {
+		r := got[i]
+		if r.Slot != uint64(i+1) || r.Outcome != w.outcome || r.StopReason != w.reason {
+			t.Errorf("record %d = slot %d %s (%s), want slot %d %s (%s)", i, r.Slot, r.Outcome, r.StopReason, i+1, w.outcome, w.reason)
+		}
+		if !r.Start.Equal(w.start) || !r.End.Equal(w.end) {
+			t.Errorf("record %d run = %v - %v, want %v - %v", i, r.Start, r.End, w.start, w.end)
+		}
+		if r.SensorMin != w.min || r.SensorMax != w.max {
+			t.Errorf("record %d sensor = %d-%d, want %d-%d", i, r.SensorMin, r.SensorMax, w.min, w.max)
+		}
+	}
+}
+
+// failingValves is a valvesDriver whose valves never answer.
+type failingValves struct{}
+
+func (failingValves) doRemoteWork(zones []string) error { return errors.New("no answer") }
+func (failingValves) stopRemoteWork() error             { return errors.New("no answer") }
+func (failingValves) openAll() error                    { return errors.New("no answer") }
+func (failingValves) close() error                      { return nil }
+
+func Test_consumerSchedule_remoteFailure(t *testing.T) {
+
+	start := time.Date(2018, 8, 21, 6, 0, 0, 0, siteTZ.loc)
+	ct := newConsumerTest(start, withHistory(newRunHistory("")))
+	fc, wtm, status := ct.fc, ct.wtm, ct.status
+	wtm.insert(&waterTime{start: start, end: start.Add(10 * time.Minute), zones: []string{"a"}, id: wtm.nextID()})
+	// The remote worker subscribes before the slot starts.
+	commands := ct.events.subscribe("remote", 0)
+	defer ct.events.unsubscribe(commands)
+	remote := &remoteWorker{name: "remote", remote: failingValves{}, events: ct.events, status: status}
+	go func() {
+		for ev := range commands.C {
+			remote.handle(ev)
+		}
+	}()
+	ct.run()
+
+	// The valves don't open: the slot is stopped, not watered until its end.
+	fc.AdvanceTo(start)
+	waitEvent(t, ct.sub, remoteFailureEvent{})
+	deadline := time.Now().Add(2 * time.Second)
+	var got []runRecord
+	for len(got) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		got = wtm.History(start.Add(-time.Hour), start.Add(time.Hour))
+	}
+	ct.stop()
+
+	if len(got) != 1 {
+		t.Fatalf("History() = %+v, want 1 record", got)
+	}
+	if r := got[0]; r.Outcome != outcomeStopped || r.StopReason != stopReason(faultRemote) || r.PumpOn != 0 {
+		t.Errorf("record = %s (%s), pump on %v, want %s (%s)", r.Outcome, r.StopReason, r.PumpOn, outcomeStopped, faultRemote)
+	}
+	if s, _ := status.cycle.current(); s != stateIdle {
+		t.Errorf("cycle = %s, want %s", s, stateIdle)
+	}
+}
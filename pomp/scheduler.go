@@ -1,14 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"sort"
 	"strings"
 	"sync"
 	"time"
-
-	"gobot.io/x/gobot"
 )
 
 const (
@@ -19,231 +18,929 @@ const (
 type waterTime struct {
 	start time.Time
 	end   time.Time
+	// id is assigned by the waterTimeManager and it's stable across restarts.
+	id uint64
+	// zones (valves) to open, if it's empty all the valves are opened.
+	zones []string
+	// soak splits the waterTime in pulses, nil if the water runs from start to end.
+	soak *soakPlan
+	// catchUp is what to do if the waterTime is missed while pomp is down,
+	// empty to use the policy of the manager.
+	catchUp catchUpPolicy
+}
+
+// String returns a human readable version of the waterTime.
+func (wt *waterTime) String() string {
+	s := fmt.Sprintf("[%d] %s - %s (%s)", wt.id, wt.start.Format(parseTimeConst), wt.end.Format(parseTimeConst), zonesString(wt.zones))
+	if wt.soak != nil {
+		s += " soak " + wt.soak.String()
+	}
+	if wt.catchUp != "" {
+		s += " catchup " + string(wt.catchUp)
+	}
+	return s
+}
+
+// active returns true if the waterTime is running at time now.
+func (wt *waterTime) active(now time.Time) bool {
+	return !wt.start.After(now) && wt.end.After(now)
 }
 
 // newWaterTime returns a new waterTime parsing a string.
+// Start and end are like "2018-08-21 10:49:00" or "2018-08-21 sunrise-30m".
+// The end could be followed by a comma separated list of zones and by "soak <run>/<soak>":
+// then end - start is the time the water runs, split in pulses, and the end is moved
+// to include the soaks. The last could be "catchup <skip|remaining|full>".
 // It may return an error if parsing goes bad.
 func newWaterTime(row string) (*waterTime, error) {
 	times := strings.Split(row, " - ")
 	if len(times) != 2 {
 		return nil, fmt.Errorf("too feew or too much elements")
 	}
-	loc, _ := time.LoadLocation("Europe/Berlin")
 	e := &waterTime{}
-	timeStart, err := time.ParseInLocation(parseTimeConst, times[0], loc)
+
+	// The end is made by a date and a time, then the list of zones and the soak plan.
+	fields := strings.Fields(times[1])
+	if len(fields) > 3 && fields[len(fields)-2] == "catchup" {
+		policy, err := parseCatchUpPolicy(fields[len(fields)-1])
+		if err != nil {
+			return nil, err
+		}
+		e.catchUp = policy
+		fields = fields[:len(fields)-2]
+	}
+	if len(fields) > 3 && fields[len(fields)-2] == "soak" {
+		plan, err := parseSoakPlan(fields[len(fields)-1])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse soak: %v", err)
+		}
+		e.soak = plan
+		fields = fields[:len(fields)-2]
+	}
+	if len(fields) == 3 {
+		zones, err := parseZones(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse zones: %v", err)
+		}
+		e.zones = zones
+		fields = fields[:2]
+	}
+	times[1] = strings.Join(fields, " ")
+
+	timeStart, err := parseSiteTime(times[0])
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse start date: %v", err)
 	}
 	e.start = timeStart
 
-	timeEnd, err := time.ParseInLocation(parseTimeConst, times[1], loc)
+	timeEnd, err := parseSiteTime(times[1])
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse end date: %v", err)
 	}
 	e.end = timeEnd
+	if e.soak != nil && e.end.After(e.start) {
+		e.end = e.start.Add(e.soak.wallTime(e.end.Sub(e.start)))
+	}
 
 	return e, nil
 
 }
 
+// conflictError is returned when a waterTime collides with the queue.
+type conflictError struct {
+	msg string
+}
+
+func (e *conflictError) Error() string {
+	return e.msg
+}
+
+// notFoundError is returned when a waterTime isn't in the queue.
+type notFoundError struct {
+	id uint64
+}
+
+func (e *notFoundError) Error() string {
+	return fmt.Sprintf("unable to find schedule %d", e.id)
+}
+
+// annotate adds a prefix to the message of err, keeping its type.
+func annotate(err error, prefix string) error {
+	msg := fmt.Sprintf("%s: %v", prefix, err)
+	if _, ok := err.(*conflictError); ok {
+		return &conflictError{msg: msg}
+	}
+	return errors.New(msg)
+}
+
 // waterTimeManager is a struct to keep the queue of waterTimes.
-// It provides a channel used to notify when a new waterTime has been added.
-// This channel is useful to understand when the manager changes.
-// After a change has been notified, user must call GetNextSlot to get the correct waterTime
+// Subscribe returns a channel notified at every change of the times, the rules or the pause.
+// The notifications are coalesced: after one, user must call GetNextSlot to get the correct waterTime
 // (could return the same waterTime).
 type waterTimeManager struct {
 	// queue of waterTime
 	times []*waterTime
-	// channel to notify that queue is changed
-	resetTimer chan bool
+	// recurring rules expanded into times
+	rules []*waterRule
+	// how far in the future rules are expanded
+	horizon time.Duration
+	// last id assigned to a waterTime
+	lastID uint64
+	// maximum number of zones open at once, 0 means no limit
+	maxOpenZones int
+	// longest waterTime accepted, 0 means no limit
+	maxSlot time.Duration
+	// shortest rest of the pump between two runs, 0 means no limit
+	minGap time.Duration
+	// zones known by the system, if it's empty every zone is accepted
+	zones map[string]bool
+	// pause of the whole schedule, nil if it's running
+	pause *pause
+	// periods when watering is forbidden and what to do with a waterTime inside them
+	blackouts      []*blackout
+	blackoutPolicy blackoutPolicy
+	// what to do with the waterTimes missed while pomp is down, how often the schedule saves
+	// that it's running (0 only when it changes) and the last time it did
+	catchUp   catchUpPolicy
+	heartbeat time.Duration
+	aliveAt   time.Time
+	// zones open since aliveAt: their water isn't in usage yet
+	aliveZones []string
+	// history records the decisions of the manager, it could be nil
+	history *runHistory
+	// rejections records the occurrences of the rules rejected, it's nil but in the simulation
+	rejections *runHistory
+	// maximum water of every period, the liters per minute of the zones
+	// and the water delivered by day (like "2006-01-02")
+	budgets []*budget
+	flows   map[string]float64
+	usage   map[string]*dayUsage
+	// changes notifies the subscribers that the queue is changed
+	changes *changeNotifier
+	// store keeps the queue on disk and state the running state, they could be nil
+	store *scheduleStore
+	state *scheduleStore
+	// clock is the source of time of the manager and its consumer
+	clock clock
 	sync.RWMutex
 }
 
+// managerOption is a function to configure a waterTimeManager.
+type managerOption func(*waterTimeManager)
+
+// withStore saves the queue on the store at every change,
+// and the running state of the schedule next to it.
+func withStore(s *scheduleStore) managerOption {
+	return func(wtm *waterTimeManager) {
+		wtm.store = s
+		if s != nil {
+			wtm.state = s.state()
+		}
+	}
+}
+
+// withClock uses c as source of time instead of the system clock.
+func withClock(c clock) managerOption {
+	return func(wtm *waterTimeManager) {
+		wtm.clock = c
+	}
+}
+
+// withMaxOpenZones limits the number of zones open at once,
+// for example because of the capacity of the pump.
+func withMaxOpenZones(n int) managerOption {
+	return func(wtm *waterTimeManager) {
+		wtm.maxOpenZones = n
+	}
+}
+
+// withMaxSlot rejects waterTimes longer than d.
+func withMaxSlot(d time.Duration) managerOption {
+	return func(wtm *waterTimeManager) {
+		wtm.maxSlot = d
+	}
+}
+
+// withZones accepts only waterTimes and rules with the given zones.
+func withZones(zones []string) managerOption {
+	return func(wtm *waterTimeManager) {
+		wtm.zones = make(map[string]bool)
+		for _, z := range zones {
+			wtm.zones[z] = true
+		}
+	}
+}
+
 // newWaterTimeManager returns a waterTimeManager
-func newWaterTimeManager() *waterTimeManager {
+func newWaterTimeManager(opts ...managerOption) *waterTimeManager {
 
 	wtm := &waterTimeManager{
-		times:      make([]*waterTime, 0),
-		resetTimer: make(chan bool),
+		times:   make([]*waterTime, 0),
+		rules:   make([]*waterRule, 0),
+		horizon: defaultHorizon,
+		usage:   make(map[string]*dayUsage),
+		catchUp: catchUpSkip,
+		changes: newChangeNotifier(),
+		clock:   realClock{},
+	}
+
+	for _, opt := range opts {
+		opt(wtm)
 	}
 
 	return wtm
 
 }
 
-// Append tries to append a new waterTime to the queue manager.
-// It also reorders times and notify the changes on resetTimer channel.
-// It could return some errors if waterTime collides with other times already in the queue.
+// Load reads the queue and the rules from the store.
+// The times missed while pomp was down are caught up by their policy,
+// expired times are dropped and rules are expanded again.
+// It doesn't notify the subscribers: consumerSchedule reads the queue when it starts.
 // It's thread safe.
-func (wtm *waterTimeManager) Append(wt *waterTime) (bool, error) {
+func (wtm *waterTimeManager) Load() error {
 	wtm.Lock()
 	defer wtm.Unlock()
 
-	// checkTime checks if a new time could fit the queue.
-	checkTime := func(input []*waterTime, t *waterTime) error {
-
-		if t.start.Before(time.Now()) {
-			return fmt.Errorf("time start is before Now: %v - %v", t.start, time.Now())
-		}
+	if wtm.store == nil {
+		return nil
+	}
 
-		if t.end.Before(t.start) {
-			return fmt.Errorf("time end is before time start: %v - %v", t.end, t.start)
-		}
+	entries, err := wtm.store.load()
+	if err != nil {
+		return err
+	}
+	// The running state was saved in the schedule file by the older versions.
+	state, err := wtm.state.load()
+	if err != nil {
+		return err
+	}
 
-		for _, oldTime := range input {
-			if t.start.After(oldTime.start) && t.start.Before(oldTime.end) {
-				return fmt.Errorf("time start '%v' is inside %v-%v range", t.start, oldTime.start, oldTime.end)
+	for _, e := range append(entries, state...) {
+		switch {
+		case e.Time != nil:
+			if e.Time.End.Before(e.Time.Start) {
+				log.Printf("stored time %v-%v is invalid, skip...", e.Time.Start, e.Time.End)
+				continue
+			}
+			wt := &waterTime{
+				start:   e.Time.Start.In(siteTZ.loc),
+				end:     e.Time.End.In(siteTZ.loc),
+				id:      e.Time.ID,
+				zones:   e.Time.Zones,
+				catchUp: catchUpPolicy(e.Time.CatchUp),
+			}
+			if s := e.Time.Soak; s != nil {
+				wt.soak = &soakPlan{run: s.Run, soak: s.Soak}
 			}
-			if t.end.After(oldTime.start) && t.end.Before(oldTime.end) {
-				return fmt.Errorf("time end '%v' is inside %v-%v range", t.end, oldTime.start, oldTime.end)
+			if wt.id == 0 || wtm.find(wt.id) >= 0 {
+				wt.id = 0 // Missing or duplicated id: assigned below.
 			}
-			if t.start.Before(oldTime.start) && t.end.After(oldTime.end) {
-				return fmt.Errorf("time range %v-%v, include the rage %v-%v", t.start, t.end, oldTime.start, oldTime.end)
+			if wt.id > wtm.lastID {
+				wtm.lastID = wt.id
+			}
+			wtm.insert(wt)
+		case e.Rule != nil:
+			r, err := parseWaterRule(e.Rule.Rule, e.Rule.Anchor.In(siteTZ.loc))
+			if err != nil {
+				log.Printf("stored rule '%s' is invalid, skip...: %v", e.Rule.Rule, err)
+				continue
+			}
+			r.expanded = e.Rule.Expanded
+			wtm.rules = append(wtm.rules, r)
+		case e.Pause != nil:
+			wtm.pause = &pause{since: e.Pause.Since.In(siteTZ.loc), until: e.Pause.Until.In(siteTZ.loc), reason: e.Pause.Reason}
+		case e.Alive != nil:
+			wtm.aliveAt = e.Alive.In(siteTZ.loc)
+		case e.Usage != nil:
+			wtm.usage[e.Usage.Day] = &dayUsage{watering: e.Usage.Watering, zones: e.Usage.Zones}
+			if e.Usage.Zones == nil {
+				wtm.usage[e.Usage.Day].zones = make(map[string]time.Duration)
 			}
 		}
-		return nil
 	}
 
-	if err := checkTime(wtm.times, wt); err != nil {
-		return false, fmt.Errorf("unable to add schedule to manager: %v", err)
+	for _, t := range wtm.times {
+		if t.id == 0 {
+			t.id = wtm.nextID()
+		}
+	}
+
+	wtm.catchUpMissed(wtm.clock.Now())
+	wtm.removeExpired(wtm.clock.Now())
+	wtm.expandRules(wtm.clock.Now())
+	wtm.expirePause(wtm.clock.Now())
+	wtm.persist()
+	wtm.persistState()
+
+	return nil
+}
+
+// persist saves the queue, the rules and the pause on the store.
+// Errors are only logged, the schedule keeps working in memory.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) persist() {
+	if wtm.store == nil {
+		return
+	}
+
+	entries := make([]*storedEntry, 0, len(wtm.times)+len(wtm.rules)+1)
+	for _, t := range wtm.times {
+		st := &storedTime{ID: t.id, Start: t.start, End: t.end, Zones: t.zones, CatchUp: string(t.catchUp)}
+		if t.soak != nil {
+			st.Soak = &storedSoak{Run: t.soak.run, Soak: t.soak.soak}
+		}
+		entries = append(entries, &storedEntry{Time: st})
+	}
+	for _, r := range wtm.rules {
+		entries = append(entries, &storedEntry{Rule: &storedRule{Rule: r.text, Anchor: r.anchor, Expanded: r.expanded}})
+	}
+	if p := wtm.pause; p != nil {
+		entries = append(entries, &storedEntry{Pause: &storedPause{Since: p.since, Until: p.until, Reason: p.reason}})
+	}
+
+	if err := wtm.store.save(entries); err != nil {
+		log.Printf("unable to save the schedule: %v", err)
+	}
+}
+
+// persistState saves the last time the schedule was running and the water delivered by day.
+// Errors are only logged, the schedule keeps working in memory.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) persistState() {
+	if wtm.state == nil {
+		return
+	}
+
+	entries := make([]*storedEntry, 0, len(wtm.usage)+1)
+	if !wtm.aliveAt.IsZero() {
+		entries = append(entries, &storedEntry{Alive: &wtm.aliveAt})
+	}
+	days := make([]string, 0, len(wtm.usage))
+	for day := range wtm.usage {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	for _, day := range days {
+		u := wtm.usage[day]
+		entries = append(entries, &storedEntry{Usage: &storedUsage{Day: day, Watering: u.watering, Zones: u.zones}})
+	}
+
+	if err := wtm.state.save(entries); err != nil {
+		log.Printf("unable to save the state of the schedule: %v", err)
+	}
+}
+
+// Append tries to append a new waterTime to the queue manager.
+// It also reorders times and notify the changes to the subscribers.
+// It could return some errors if waterTime collides with other times already in the queue.
+// It's thread safe.
+func (wtm *waterTimeManager) Append(wt *waterTime) (bool, error) {
+	wtm.Lock()
+	defer wtm.Unlock()
+
+	err := wtm.applyBlackouts(wt, true)
+	if err == nil {
+		err = wtm.checkTime(wt)
+	}
+	if err != nil {
+		return false, annotate(err, "unable to add schedule to manager")
 	}
 
 	// Now it's safe to add the new time to the queue
-	// and the reorder by start time
-	wtm.times = append(wtm.times, wt)
-	sort.Slice(wtm.times, func(i, j int) bool { return wtm.times[i].start.Before(wtm.times[j].start) })
+	wt.id = wtm.nextID()
+	wtm.insert(wt)
+	wtm.persist()
 
 	// Notify listeners that the queue is changed
-	wtm.resetTimer <- true
+	wtm.changes.notify()
 	return true, nil
 }
 
-// GetNextSlot returns the next scheduled time.
+// Remove removes the waterTime with the given id from the queue.
+// If the waterTime is running, consumerSchedule stops it after the notification.
 // It's thread safe.
-func (wtm *waterTimeManager) GetNextSlot() *waterTime {
+func (wtm *waterTimeManager) Remove(id uint64) error {
 	wtm.Lock()
 	defer wtm.Unlock()
 
-	if len(wtm.times) == 0 {
-		return nil
+	i := wtm.find(id)
+	if i < 0 {
+		return &notFoundError{id: id}
+	}
+
+	wtm.times = append(wtm.times[:i], wtm.times[i+1:]...)
+	wtm.persist()
+
+	// Notify listeners that the queue is changed
+	wtm.changes.notify()
+	return nil
+}
+
+// Update replaces the range of the waterTime with the given id.
+// A running waterTime can change only its end (for example to shorten it),
+// otherwise the new range must respect the same rules of Append.
+// It's thread safe.
+func (wtm *waterTimeManager) Update(id uint64, newRange *waterTime) error {
+	wtm.Lock()
+	defer wtm.Unlock()
+
+	i := wtm.find(id)
+	if i < 0 {
+		return &notFoundError{id: id}
+	}
+
+	// Times in the queue are never modified, consumerSchedule could be using the old one.
+	wt := &waterTime{start: newRange.start, end: newRange.end, id: id, zones: newRange.zones, soak: newRange.soak, catchUp: newRange.catchUp}
+
+	var err error
+	if wtm.times[i].active(wtm.clock.Now()) && wt.start.Equal(wtm.times[i].start) {
+		if err = wtm.applyBlackouts(wt, false); err == nil {
+			err = wtm.checkRange(wt)
+		}
+	} else if err = wtm.applyBlackouts(wt, true); err == nil {
+		err = wtm.checkTime(wt)
+	}
+	if err != nil {
+		return annotate(err, fmt.Sprintf("unable to update schedule %d", id))
+	}
+
+	wtm.times[i] = wt
+	sort.Slice(wtm.times, func(i, j int) bool { return wtm.times[i].start.Before(wtm.times[j].start) })
+	wtm.persist()
+
+	// Notify listeners that the queue is changed
+	wtm.changes.notify()
+	return nil
+}
+
+// List returns a copy of the waterTimes in the queue.
+// It's thread safe.
+func (wtm *waterTimeManager) List() []waterTime {
+	wtm.RLock()
+	defer wtm.RUnlock()
+
+	times := make([]waterTime, len(wtm.times))
+	for i, t := range wtm.times {
+		times[i] = *t
+	}
+	return times
+}
+
+// isActive returns true if the waterTime with the given id is in the queue and it's running.
+// It's thread safe.
+func (wtm *waterTimeManager) isActive(id uint64) bool {
+	wtm.RLock()
+	defer wtm.RUnlock()
+
+	i := wtm.find(id)
+	return i >= 0 && wtm.times[i].active(wtm.clock.Now())
+}
+
+// find returns the position of the waterTime with the given id, or -1.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) find(id uint64) int {
+	for i, t := range wtm.times {
+		if t.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// nextID returns a new id for a waterTime.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) nextID() uint64 {
+	wtm.lastID++
+	return wtm.lastID
+}
+
+// AddRule adds a recurring rule to the manager and expands it
+// until the horizon. Times colliding with the queue are skipped.
+// It returns the number of waterTimes added and notify the changes to the subscribers.
+// It's thread safe.
+func (wtm *waterTimeManager) AddRule(r *waterRule) (int, error) {
+	wtm.Lock()
+	defer wtm.Unlock()
+
+	if err := wtm.checkZones(r.zones); err != nil {
+		return 0, err
 	}
+	if wtm.maxSlot > 0 && r.duration > wtm.maxSlot {
+		return 0, fmt.Errorf("rule lasts %v, max %v", r.duration, wtm.maxSlot)
+	}
+
+	wtm.rules = append(wtm.rules, r)
+	added := wtm.expandRules(wtm.clock.Now())
+	wtm.persist()
+
+	// Notify listeners that the queue is changed
+	wtm.changes.notify()
+	return added, nil
+}
+
+// Rules returns the recurring rules of the manager.
+// It's thread safe.
+func (wtm *waterTimeManager) Rules() []string {
+	wtm.RLock()
+	defer wtm.RUnlock()
+
+	rules := make([]string, len(wtm.rules))
+	for i, r := range wtm.rules {
+		rules[i] = r.String()
+	}
+	return rules
+}
 
-	i := 0
-	// Find the next waterTime to use
-	totalTimes := len(wtm.times)
-	for ; i < totalTimes; i++ {
-		if wtm.times[i].end.After(time.Now()) {
-			break
+// checkTime checks if a new time could fit the queue.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) checkTime(t *waterTime) error {
+
+	now := wtm.clock.Now()
+	if t.start.Before(now) {
+		return fmt.Errorf("time start is before Now: %v - %v", t.start, now)
+	}
+
+	return wtm.checkRange(t)
+}
+
+// checkRange checks if the range of a time is valid and doesn't collide with the queue.
+// Times collide only if they share a zone, or if too many zones would be open at once.
+// The time must also fit the budgets.
+// A time already in the queue (with the same id) is not compared with itself.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) checkRange(t *waterTime) error {
+
+	if t.end.Before(t.start) {
+		return fmt.Errorf("time end is before time start: %v - %v", t.end, t.start)
+	}
+	if wtm.maxSlot > 0 && t.runTime() > wtm.maxSlot {
+		return fmt.Errorf("time range %v-%v runs %v, max %v", t.start, t.end, t.runTime(), wtm.maxSlot)
+	}
+	if err := wtm.checkZones(t.zones); err != nil {
+		return err
+	}
+
+	for _, oldTime := range wtm.times {
+		if t.id != 0 && oldTime.id == t.id || !t.sharesZones(oldTime) {
+			continue
+		}
+		if t.start.Equal(oldTime.start) && t.end.Equal(oldTime.end) {
+			return &conflictError{msg: fmt.Sprintf("time range %v-%v is already scheduled", t.start, t.end)}
+		}
+		if t.start.After(oldTime.start) && t.start.Before(oldTime.end) {
+			return &conflictError{msg: fmt.Sprintf("time start '%v' is inside %v-%v range", t.start, oldTime.start, oldTime.end)}
 		}
+		if t.end.After(oldTime.start) && t.end.Before(oldTime.end) {
+			return &conflictError{msg: fmt.Sprintf("time end '%v' is inside %v-%v range", t.end, oldTime.start, oldTime.end)}
+		}
+		if t.start.Before(oldTime.start) && t.end.After(oldTime.end) {
+			return &conflictError{msg: fmt.Sprintf("time range %v-%v, include the rage %v-%v", t.start, t.end, oldTime.start, oldTime.end)}
+		}
+		// The same start or end with a different length.
+		if t.start.Before(oldTime.end) && oldTime.start.Before(t.end) {
+			return &conflictError{msg: fmt.Sprintf("time range %v-%v overlaps %v-%v", t.start, t.end, oldTime.start, oldTime.end)}
+		}
+	}
+	if err := wtm.checkCapacity(t); err != nil {
+		return err
 	}
+	return wtm.checkBudgets(t)
+}
 
-	wtm.times = wtm.times[i:]
-	if i == totalTimes { // No waterTime founds.
+// insert adds a time to the queue and reorders it by start time.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) insert(wt *waterTime) {
+	wtm.times = append(wtm.times, wt)
+	sort.Slice(wtm.times, func(i, j int) bool { return wtm.times[i].start.Before(wtm.times[j].start) })
+}
+
+// expandRules expands all the rules until now plus the horizon.
+// Every new time passes through the blackouts and checkTime, colliding times are logged and skipped.
+// It returns the number of waterTimes added.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) expandRules(now time.Time) int {
+	added := 0
+	for _, r := range wtm.rules {
+		from := r.expanded
+		if from.Before(now) {
+			from = now
+		}
+		to := now.Add(r.horizon(wtm.horizon))
+		if !from.Before(to) {
+			continue
+		}
+
+		for _, wt := range r.occurrences(from, to) {
+			err := wtm.applyBlackouts(wt, true)
+			if err == nil {
+				err = wtm.checkTime(wt)
+			}
+			if err != nil {
+				log.Printf("rule '%s' skips %v: %v", r, wt.start, err)
+				wtm.rejections.record(&runRecord{
+					At: now, Zones: wt.zones, PlannedStart: wt.start, PlannedEnd: wt.end,
+					Outcome: outcomeRejected, Reason: fmt.Sprintf("rule '%s': %v", r, err), SensorMin: -1, SensorMax: -1,
+				})
+				continue
+			}
+			wt.id = wtm.nextID()
+			wtm.insert(wt)
+			added++
+		}
+		r.expanded = to
+	}
+	return added
+}
+
+// GetNextSlot returns the next scheduled time.
+// It's thread safe.
+func (wtm *waterTimeManager) GetNextSlot() *waterTime {
+	wtm.Lock()
+	defer wtm.Unlock()
+
+	// Keeps the rules expanded as time passes
+	now := wtm.clock.Now()
+	added := wtm.expandRules(now)
+	removed := wtm.removeExpired(now)
+	if added > 0 || removed > 0 {
+		wtm.persist()
+	}
+
+	if len(wtm.times) == 0 { // No waterTime founds.
 		return nil
 	}
 
 	return wtm.times[0]
 }
 
-// consumerSchedule manages the ticker for the system
-func consumerSchedule(wtm *waterTimeManager, eventer gobot.Eventer, wg *sync.WaitGroup) {
+// removeExpired removes from the queue all the times ended before now.
+// Times of different zones could overlap, so the whole queue is checked.
+// It returns the number of waterTimes removed.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) removeExpired(now time.Time) int {
+	times := wtm.times[:0]
+	for _, t := range wtm.times {
+		if t.end.After(now) {
+			times = append(times, t)
+		}
+	}
+
+	removed := len(wtm.times) - len(times)
+	wtm.times = times
+	return removed
+}
+
+// consumerSchedule manages the ticker for the system: it runs a scheduleLoop at the next start or end,
+// at every change of the queue, at every fault and at every failure of the remote robots, until stopAndQuit.
+func consumerSchedule(wtm *waterTimeManager, events *bus, status *systemStatus, wg *sync.WaitGroup) {
 
 	defer wg.Done()
 
-	commands := eventer.Subscribe()
+	commands := events.subscribe("schedule", 0)
+	changes := wtm.Subscribe()
 	quit := make(chan bool)
+	faults := make(chan bool, 1)
+	failures := make(chan bool, 1)
 
 	// This routine will wait events from commands channel
 	// and in case a stopAndQuit signal will be received,
 	// we close the scheduler
 	go func() {
-		for e := range commands {
-			if e.Name != stopWorkers {
+		for ev := range commands.C {
+			if _, ok := ev.(remoteFailureEvent); ok {
+				select {
+				case failures <- true:
+				default: // The loop has already to look at the failure.
+				}
+				continue
+			}
+			e, ok := ev.(stopEvent)
+			if !ok {
 				continue
 			}
 
-			statusExit, ok := e.Data.(StopSignal)
-			if !ok || statusExit == stopAndQuit {
+			if e.signal == stopAndQuit {
 				quit <- true
 				return
 			}
+			if e.signal == stopFault {
+				select {
+				case faults <- true:
+				default: // The loop has already to look at the fault.
+				}
+			}
 		}
 	}()
 
-	var timer *time.Timer
+	loop := newScheduleLoop(wtm, events, status)
+	// timer wakes the loop on wake at the next start or end.
+	var timer clockTimer
+	wake := make(chan struct{}, 1)
 
-WAIT_FIRST_SLOT:
+	// The queue could already contain some times (for example loaded from disk),
+	// so we look at the queue before waiting any change.
 	for {
-		select {
-
-		case <-wtm.resetTimer: // Wait the first incoming waterTime.
-		WAIT_SLOTS:
-			for {
-				nextSlot := wtm.GetNextSlot() // Get the next waterTime.
-				if nextSlot == nil {
-					// No more work to do, empty schedule,
-					// go to start and wait the first waterTime incoming.
-					continue WAIT_FIRST_SLOT
-				}
-				d := time.Until(nextSlot.start)
-				// If a duration is negative, means that the slot received is currently active.
-				// This happens when the scheduler has been reseted during an active task.
-
-				if d > 0 {
-					log.Printf("Next timer will start at: %v", d)
-					timer = time.AfterFunc(d, func() {
-						eventer.Publish(startRemoteRobots, struct{}{})
-					})
-				}
-
+		next := loop.step()
+
+		// Without times, we wait the first waterTime incoming.
+		// The timer of the previous loop is stopped, so only one timer is waiting the clock.
+		if timer != nil && !timer.Stop() {
+			// The timer expired meanwhile: the loop already looked at the change.
+			select {
+			case <-wake:
+			default:
+			}
+		}
+		timer = nil
+		if !next.IsZero() {
+			d := next.Sub(wtm.clock.Now())
+			log.Printf("Next timer will start at: %v", d)
+			timer = wtm.clock.AfterFunc(d, func() {
 				select {
-				case <-time.After(time.Until(nextSlot.end)): // Wait the end of the process.
-					eventer.Publish(stopWorkers, stopRemote)
-					continue WAIT_SLOTS
-				case <-wtm.resetTimer: // Wait if the meanwhile the manager has been reset.
-					if timer != nil {
-						timer.Stop()
-					}
-					log.Println("reset timer!")
-					continue WAIT_SLOTS
-				case <-quit: // Quit signal. Exits
-					if timer != nil {
-						timer.Stop()
-					}
-					eventer.Unsubscribe(commands)
-					log.Printf("close the schedule")
-					return
+				case wake <- struct{}{}:
+				default: // The loop has already to wake up.
 				}
-			}
+			})
+		}
 
+		select {
+		case <-wake: // Wait the next start or end.
+		case <-changes: // Wait if the meanwhile the manager has been changed.
+			// A removed or moved time is stopped at the next loop,
+			// a shortened one is still open and the loop waits its new end.
+			log.Println("reset timer!")
+		case <-faults: // A fault stopped the system: the running slots are skipped.
+			log.Println("fault!")
+		case <-failures: // The remote robots failed: the running slots are stopped.
+			log.Println("remote failure!")
+			loop.remoteFailed = true
 		case <-quit: // Quit signal. Exits
-			eventer.Unsubscribe(commands)
+			if timer != nil {
+				timer.Stop()
+			}
+			loop.quit()
+			events.unsubscribe(commands)
+			wtm.Unsubscribe(changes)
 			log.Printf("close the schedule")
 			return
+		}
+	}
+
+}
+
+// scheduleLoop opens and closes the zones following the queue of a waterTimeManager.
+// While a fault is active, the schedule is paused, a blackout is running or a budget is exhausted
+// the slots are skipped: a skipped slot doesn't start also if the fault is acknowledged,
+// the schedule resumed or the blackout ended before its end.
+// The slots active when the remote robots fail to open or change the valves are stopped the same way.
+// The water delivered is recorded for the budgets, a running slot is stopped when a budget runs out.
+// At every step, and every heartbeat while a slot is active, the schedule is saved as running.
+// Every slot which runs or is skipped is recorded in the history.
+type scheduleLoop struct {
+	wtm    *waterTimeManager
+	events *bus
+	status *systemStatus
+	// open keeps the zones currently open, it's empty if the system is stopped.
+	open []string
+	// skipped keeps the active slots skipped because of a fault.
+	skipped map[uint64]bool
+	// runs records the slots in the history.
+	runs *runLog
+	// last is when the schedule has been saved as running, with the water delivered for the budgets.
+	last time.Time
+	// remoteFailed is true when the remote robots failed after the last step.
+	remoteFailed bool
+}
+
+// newScheduleLoop returns a scheduleLoop with the system stopped.
+func newScheduleLoop(wtm *waterTimeManager, events *bus, status *systemStatus) *scheduleLoop {
+	return &scheduleLoop{
+		wtm:     wtm,
+		events:  events,
+		status:  status,
+		skipped: make(map[uint64]bool),
+		runs:    newRunLog(wtm.history, status),
+		last:    wtm.clock.Now(),
+	}
+}
 
+// step looks at the queue now: it skips the slots which can't run and asks to open,
+// change or close the zones. It returns when it has to look again, zero if only a change
+// of the queue or a fault can change something.
+func (l *scheduleLoop) step() time.Time {
+	wtm, events, status := l.wtm, l.events, l.status
+
+	now := wtm.clock.Now()
+	wtm.alive(l.open, l.last, now)
+	l.last = now
+
+	active, next := wtm.nextChange()
+
+	stillSkipped := make(map[uint64]bool)
+	f := status.activeFault()
+	p := wtm.Paused()
+	b := wtm.activeBlackout(now)
+	for _, t := range active {
+		if !l.skipped[t.id] {
+			var reason stopReason
+			switch exhausted := wtm.exhaustedBudget(&t, now); {
+			case f != nil:
+				log.Printf("slot %v skipped: fault '%s' active", &t, f.code)
+				reason = stopReason(f.code)
+			case l.remoteFailed:
+				log.Printf("slot %v stopped: the remote robots failed", &t)
+				reason = stopReason(faultRemote)
+			case p != nil:
+				log.Printf("slot %v skipped: schedule %v", &t, p)
+				reason = reasonPause
+			case b != nil:
+				log.Printf("slot %v skipped: %v", &t, b)
+				reason = reasonBlackout
+			case exhausted != nil:
+				log.Printf("slot %v skipped: %v exhausted", &t, exhausted)
+				reason = reasonBudget
+			}
+			if reason != "" {
+				l.skipped[t.id] = true
+				l.runs.skip(&t, now, reason)
+			}
 		}
+		if l.skipped[t.id] {
+			stillSkipped[t.id] = true
+		}
+	}
+	l.skipped = stillSkipped
+	l.runs.update(active, l.skipped, now)
+	if f != nil || l.remoteFailed {
+		// The workers already brought the hardware in the safe state, or stopped it.
+		l.open = nil
+	}
+	l.remoteFailed = false
+
+	// The slots soaking keep their zones closed until the next pulse.
+	zones := openZones(watering(active, now), l.skipped)
+	switch {
+	case len(l.open) == 0 && len(zones) > 0:
+		log.Printf("start zones %v", zones)
+		events.publish(startZonesEvent{zones})
+	case len(l.open) > 0 && len(zones) == 0:
+		log.Printf("stop zones %v", l.open)
+		events.publish(stopEvent{stopLocal})
+	case !sameZones(l.open, zones):
+		log.Printf("change zones from %v to %v", l.open, zones)
+		events.publish(changeZonesEvent{zones})
+	}
+	l.open = zones
+	if out := wtm.budgetRunsOut(zones, now); !out.IsZero() && (next.IsZero() || out.Before(next)) {
+		next = out
 	}
+	if beat := now.Add(wtm.heartbeat); wtm.heartbeat > 0 && len(active) > 0 && beat.Before(next) {
+		next = beat
+	}
+	return next
+}
 
+// quit saves the schedule as running for the last time and closes the runs in the history.
+func (l *scheduleLoop) quit() {
+	now := l.wtm.clock.Now()
+	l.wtm.alive(l.open, l.last, now)
+	l.runs.stopAll(now, reasonShutdown)
 }
 
 type sumWaterTime struct {
+	id        uint64
 	start     time.Time
 	end       time.Time
+	zones     []string
 	started   bool
 	willStart time.Duration
+	// soak is the plan of the pulses, nil without pulses.
+	soak *soakPlan
+	// pulse is the number of the current pulse (from 1) of pulses, soaking is true between two pulses.
+	pulse, pulses int
+	soaking       bool
 }
 
 func (wtm *waterTimeManager) PrintStatus() []*sumWaterTime {
 	wtm.RLock()
 	defer wtm.RUnlock()
 
+	now := wtm.clock.Now()
 	times := make([]*sumWaterTime, len(wtm.times))
 	for i, t := range wtm.times {
 		times[i] = &sumWaterTime{
+			id:        t.id,
 			start:     t.start,
 			end:       t.end,
-			started:   !t.start.After(time.Now()),
-			willStart: time.Until(t.start),
+			zones:     t.zones,
+			started:   !t.start.After(now),
+			willStart: t.start.Sub(now),
+			soak:      t.soak,
+		}
+		if t.soak != nil {
+			pulse, running := t.pulseAt(now)
+			times[i].pulse, times[i].pulses = pulse, len(t.pulses())
+			times[i].soaking = pulse > 0 && !running
 		}
 	}
 	return times
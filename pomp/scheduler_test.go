@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
@@ -27,13 +28,25 @@ func Test_newWaterTime(t *testing.T) {
 		{
 			"normal",
 			args{fmt.Sprintf("%s - %s", firstTimeStr, secondTimeStr)},
-			&waterTime{firsTime, secondTime},
+			&waterTime{start: firsTime, end: secondTime},
 			false,
 		},
+		{
+			"with zones",
+			args{fmt.Sprintf("%s - %s front,back", firstTimeStr, secondTimeStr)},
+			&waterTime{start: firsTime, end: secondTime, zones: []string{"back", "front"}},
+			false,
+		},
+		{
+			"wrong zones",
+			args{fmt.Sprintf("%s - %s front,,back", firstTimeStr, secondTimeStr)},
+			nil,
+			true,
+		},
 		{
 			"missing args",
 			args{fmt.Sprintf("%s -", firstTimeStr)},
-			&waterTime{firsTime, secondTime},
+			&waterTime{start: firsTime, end: secondTime},
 			true,
 		},
 		{
@@ -69,6 +82,9 @@ func Test_newWaterTime(t *testing.T) {
 			if got.end.String() != tt.want.end.String() {
 				t.Errorf("end value = %s, want %s", got.end, tt.want.end)
 			}
+			if !sameZones(got.zones, tt.want.zones) {
+				t.Errorf("zones value = %v, want %v", got.zones, tt.want.zones)
+			}
 		})
 	}
 }
@@ -85,14 +101,13 @@ func Test_newWaterTimeManager(t *testing.T) {
 		return
 	}
 
-	// some basic test about resetTimer chan
-	go func() {
-		<-got.resetTimer
-	}()
+	// some basic test about the notifications
+	changes := got.Subscribe()
+	got.changes.notify()
 	select {
-	case got.resetTimer <- true:
+	case <-changes:
 	case <-time.After(1 * time.Second):
-		t.Errorf("unable to send to got.resetTimer chanel")
+		t.Errorf("unable to receive from got.Subscribe() channel")
 		return
 	}
 
@@ -135,23 +150,23 @@ func Test_waterTimeManager_Append(t *testing.T) {
 		waitReset bool
 	}{
 		{name: "empty and before now", args: []*waterTime{&waterTime{}}, order: nil, wantErr: true},
-		{name: "end before start", args: []*waterTime{&waterTime{tnow2Minute, tnow1Minute}}, order: nil, wantErr: true},
-		{name: "start match end", args: []*waterTime{&waterTime{tnow1Minute, tnow4Minute}, &waterTime{tnow4Minute, tnow5Minute}}, order: []*waterTime{&waterTime{tnow1Minute, tnow4Minute}, &waterTime{tnow4Minute, tnow5Minute}}, want: true},
-		{name: "start in between", args: []*waterTime{&waterTime{tnow1Minute, tnow4Minute}, &waterTime{tnow2Minute, tnow5Minute}}, order: []*waterTime{&waterTime{tnow1Minute, tnow4Minute}}, wantErr: true},
-		{name: "end in between", args: []*waterTime{&waterTime{tnow2Minute, tnow5Minute}, &waterTime{tnow1Minute, tnow4Minute}}, order: []*waterTime{&waterTime{tnow2Minute, tnow5Minute}}, wantErr: true},
-		{name: "completely inside", args: []*waterTime{&waterTime{tnow1Minute, tnow8Minute}, &waterTime{tnow4Minute, tnow5Minute}}, order: []*waterTime{&waterTime{tnow1Minute, tnow8Minute}}, wantErr: true},
-		{name: "completely outside", args: []*waterTime{&waterTime{tnow4Minute, tnow5Minute}, &waterTime{tnow1Minute, tnow8Minute}}, order: []*waterTime{&waterTime{tnow4Minute, tnow5Minute}}, wantErr: true},
-		{name: "start in between with three times", args: []*waterTime{&waterTime{tnow1Minute, tnow4Minute}, &waterTime{tnow6Minute, tnow8Minute}, &waterTime{tnow2Minute, tnow5Minute}}, order: []*waterTime{&waterTime{tnow1Minute, tnow4Minute}, &waterTime{tnow6Minute, tnow8Minute}}, wantErr: true},
-		{name: "end in between with three times", args: []*waterTime{&waterTime{tnow1Minute, tnow2Minute}, &waterTime{tnow5Minute, tnow8Minute}, &waterTime{tnow4Minute, tnow6Minute}}, order: []*waterTime{&waterTime{tnow1Minute, tnow2Minute}, &waterTime{tnow5Minute, tnow8Minute}}, wantErr: true},
-		{name: "start in a range, end in other range", args: []*waterTime{&waterTime{tnow1Minute, tnow4Minute}, &waterTime{tnow5Minute, tnow8Minute}, &waterTime{tnow2Minute, tnow6Minute}}, order: []*waterTime{&waterTime{tnow1Minute, tnow4Minute}, &waterTime{tnow5Minute, tnow8Minute}}, wantErr: true},
-		{name: "simple", args: []*waterTime{&waterTime{tnow1Minute, tnow2Minute}}, order: []*waterTime{&waterTime{tnow1Minute, tnow2Minute}}, want: true},
-		{name: "order times", args: []*waterTime{&waterTime{tnow4Minute, tnow5Minute}, &waterTime{tnow1Minute, tnow2Minute}}, order: []*waterTime{&waterTime{tnow1Minute, tnow2Minute}, &waterTime{tnow4Minute, tnow5Minute}}, want: true},
-		{name: "three times: order s1, s2, s3", args: []*waterTime{&waterTime{tnow1Minute, tnow2Minute}, &waterTime{tnow4Minute, tnow5Minute}, &waterTime{tnow6Minute, tnow8Minute}}, order: []*waterTime{&waterTime{tnow1Minute, tnow2Minute}, &waterTime{tnow4Minute, tnow5Minute}, &waterTime{tnow6Minute, tnow8Minute}}, want: true},
-		{name: "three times: order s1, s3, s2", args: []*waterTime{&waterTime{tnow1Minute, tnow2Minute}, &waterTime{tnow6Minute, tnow8Minute}, &waterTime{tnow4Minute, tnow5Minute}}, order: []*waterTime{&waterTime{tnow1Minute, tnow2Minute}, &waterTime{tnow4Minute, tnow5Minute}, &waterTime{tnow6Minute, tnow8Minute}}, want: true},
-		{name: "three times: order s3, s1, s2", args: []*waterTime{&waterTime{tnow6Minute, tnow8Minute}, &waterTime{tnow1Minute, tnow2Minute}, &waterTime{tnow4Minute, tnow5Minute}}, order: []*waterTime{&waterTime{tnow1Minute, tnow2Minute}, &waterTime{tnow4Minute, tnow5Minute}, &waterTime{tnow6Minute, tnow8Minute}}, want: true},
-		{name: "three times: order s3, s2, s1", args: []*waterTime{&waterTime{tnow6Minute, tnow8Minute}, &waterTime{tnow4Minute, tnow5Minute}, &waterTime{tnow1Minute, tnow2Minute}}, order: []*waterTime{&waterTime{tnow1Minute, tnow2Minute}, &waterTime{tnow4Minute, tnow5Minute}, &waterTime{tnow6Minute, tnow8Minute}}, want: true},
-		{name: "three times: order s2, s1, s3", args: []*waterTime{&waterTime{tnow4Minute, tnow5Minute}, &waterTime{tnow1Minute, tnow2Minute}, &waterTime{tnow6Minute, tnow8Minute}}, order: []*waterTime{&waterTime{tnow1Minute, tnow2Minute}, &waterTime{tnow4Minute, tnow5Minute}, &waterTime{tnow6Minute, tnow8Minute}}, want: true},
-		{name: "three times: order s2, s3, s1", args: []*waterTime{&waterTime{tnow4Minute, tnow5Minute}, &waterTime{tnow6Minute, tnow8Minute}, &waterTime{tnow1Minute, tnow2Minute}}, order: []*waterTime{&waterTime{tnow1Minute, tnow2Minute}, &waterTime{tnow4Minute, tnow5Minute}, &waterTime{tnow6Minute, tnow8Minute}}, want: true},
+		{name: "end before start", args: []*waterTime{&waterTime{start: tnow2Minute, end: tnow1Minute}}, order: nil, wantErr: true},
+		{name: "start match end", args: []*waterTime{&waterTime{start: tnow1Minute, end: tnow4Minute}, &waterTime{start: tnow4Minute, end: tnow5Minute}}, order: []*waterTime{&waterTime{start: tnow1Minute, end: tnow4Minute}, &waterTime{start: tnow4Minute, end: tnow5Minute}}, want: true},
+		{name: "start in between", args: []*waterTime{&waterTime{start: tnow1Minute, end: tnow4Minute}, &waterTime{start: tnow2Minute, end: tnow5Minute}}, order: []*waterTime{&waterTime{start: tnow1Minute, end: tnow4Minute}}, wantErr: true},
+		{name: "end in between", args: []*waterTime{&waterTime{start: tnow2Minute, end: tnow5Minute}, &waterTime{start: tnow1Minute, end: tnow4Minute}}, order: []*waterTime{&waterTime{start: tnow2Minute, end: tnow5Minute}}, wantErr: true},
+		{name: "completely inside", args: []*waterTime{&waterTime{start: tnow1Minute, end: tnow8Minute}, &waterTime{start: tnow4Minute, end: tnow5Minute}}, order: []*waterTime{&waterTime{start: tnow1Minute, end: tnow8Minute}}, wantErr: true},
+		{name: "completely outside", args: []*waterTime{&waterTime{start: tnow4Minute, end: tnow5Minute}, &waterTime{start: tnow1Minute, end: tnow8Minute}}, order: []*waterTime{&waterTime{start: tnow4Minute, end: tnow5Minute}}, wantErr: true},
+		{name: "start in between with three times", args: []*waterTime{&waterTime{start: tnow1Minute, end: tnow4Minute}, &waterTime{start: tnow6Minute, end: tnow8Minute}, &waterTime{start: tnow2Minute, end: tnow5Minute}}, order: []*waterTime{&waterTime{start: tnow1Minute, end: tnow4Minute}, &waterTime{start: tnow6Minute, end: tnow8Minute}}, wantErr: true},
+		{name: "end in between with three times", args: []*waterTime{&waterTime{start: tnow1Minute, end: tnow2Minute}, &waterTime{start: tnow5Minute, end: tnow8Minute}, &waterTime{start: tnow4Minute, end: tnow6Minute}}, order: []*waterTime{&waterTime{start: tnow1Minute, end: tnow2Minute}, &waterTime{start: tnow5Minute, end: tnow8Minute}}, wantErr: true},
+		{name: "start in a range, end in other range", args: []*waterTime{&waterTime{start: tnow1Minute, end: tnow4Minute}, &waterTime{start: tnow5Minute, end: tnow8Minute}, &waterTime{start: tnow2Minute, end: tnow6Minute}}, order: []*waterTime{&waterTime{start: tnow1Minute, end: tnow4Minute}, &waterTime{start: tnow5Minute, end: tnow8Minute}}, wantErr: true},
+		{name: "simple", args: []*waterTime{&waterTime{start: tnow1Minute, end: tnow2Minute}}, order: []*waterTime{&waterTime{start: tnow1Minute, end: tnow2Minute}}, want: true},
+		{name: "order times", args: []*waterTime{&waterTime{start: tnow4Minute, end: tnow5Minute}, &waterTime{start: tnow1Minute, end: tnow2Minute}}, order: []*waterTime{&waterTime{start: tnow1Minute, end: tnow2Minute}, &waterTime{start: tnow4Minute, end: tnow5Minute}}, want: true},
+		{name: "three times: order s1, s2, s3", args: []*waterTime{&waterTime{start: tnow1Minute, end: tnow2Minute}, &waterTime{start: tnow4Minute, end: tnow5Minute}, &waterTime{start: tnow6Minute, end: tnow8Minute}}, order: []*waterTime{&waterTime{start: tnow1Minute, end: tnow2Minute}, &waterTime{start: tnow4Minute, end: tnow5Minute}, &waterTime{start: tnow6Minute, end: tnow8Minute}}, want: true},
+		{name: "three times: order s1, s3, s2", args: []*waterTime{&waterTime{start: tnow1Minute, end: tnow2Minute}, &waterTime{start: tnow6Minute, end: tnow8Minute}, &waterTime{start: tnow4Minute, end: tnow5Minute}}, order: []*waterTime{&waterTime{start: tnow1Minute, end: tnow2Minute}, &waterTime{start: tnow4Minute, end: tnow5Minute}, &waterTime{start: tnow6Minute, end: tnow8Minute}}, want: true},
+		{name: "three times: order s3, s1, s2", args: []*waterTime{&waterTime{start: tnow6Minute, end: tnow8Minute}, &waterTime{start: tnow1Minute, end: tnow2Minute}, &waterTime{start: tnow4Minute, end: tnow5Minute}}, order: []*waterTime{&waterTime{start: tnow1Minute, end: tnow2Minute}, &waterTime{start: tnow4Minute, end: tnow5Minute}, &waterTime{start: tnow6Minute, end: tnow8Minute}}, want: true},
+		{name: "three times: order s3, s2, s1", args: []*waterTime{&waterTime{start: tnow6Minute, end: tnow8Minute}, &waterTime{start: tnow4Minute, end: tnow5Minute}, &waterTime{start: tnow1Minute, end: tnow2Minute}}, order: []*waterTime{&waterTime{start: tnow1Minute, end: tnow2Minute}, &waterTime{start: tnow4Minute, end: tnow5Minute}, &waterTime{start: tnow6Minute, end: tnow8Minute}}, want: true},
+		{name: "three times: order s2, s1, s3", args: []*waterTime{&waterTime{start: tnow4Minute, end: tnow5Minute}, &waterTime{start: tnow1Minute, end: tnow2Minute}, &waterTime{start: tnow6Minute, end: tnow8Minute}}, order: []*waterTime{&waterTime{start: tnow1Minute, end: tnow2Minute}, &waterTime{start: tnow4Minute, end: tnow5Minute}, &waterTime{start: tnow6Minute, end: tnow8Minute}}, want: true},
+		{name: "three times: order s2, s3, s1", args: []*waterTime{&waterTime{start: tnow4Minute, end: tnow5Minute}, &waterTime{start: tnow6Minute, end: tnow8Minute}, &waterTime{start: tnow1Minute, end: tnow2Minute}}, order: []*waterTime{&waterTime{start: tnow1Minute, end: tnow2Minute}, &waterTime{start: tnow4Minute, end: tnow5Minute}, &waterTime{start: tnow6Minute, end: tnow8Minute}}, want: true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -169,13 +184,6 @@ func Test_waterTimeManager_Append(t *testing.T) {
 			// 	}()
 			// }
 
-			// Catch all the signal we receive form wtm.resetTimer.
-			go func() {
-				for range wtm.resetTimer {
-
-				}
-			}()
-
 			var err error
 			var got bool
 			for _, tm := range tt.args {
@@ -203,7 +211,8 @@ func Test_waterTimeManager_Append(t *testing.T) {
 			// Check the order of the elements.
 			if tt.order != nil {
 				for i := range tt.order {
-					if ok := reflect.DeepEqual(tt.order[i], wtm.times[i]); !ok {
+					// Ids are assigned by the manager, so only the range is compared.
+					if ok := reflect.DeepEqual(tt.order[i].start, wtm.times[i].start) && reflect.DeepEqual(tt.order[i].end, wtm.times[i].end); !ok {
 						t.Errorf("wrong order at position %d, want %v; got %v", i, tt.order[i], wtm.times[i])
 						return
 					}
@@ -220,3 +229,230 @@ func Test_waterTimeManager_Append(t *testing.T) {
 		})
 	}
 }
+
+func Test_waterTimeManager_RemoveUpdate(t *testing.T) {
+
+	now := time.Now()
+	wtm := newWaterTimeManager()
+
+	// An active time, and two in the future.
+	wtm.insert(&waterTime{start: now.Add(-1 * time.Minute), end: now.Add(2 * time.Minute), id: wtm.nextID()})
+	for _, tm := range []*waterTime{{start: now.Add(4 * time.Minute), end: now.Add(5 * time.Minute)}, {start: now.Add(6 * time.Minute), end: now.Add(8 * time.Minute)}} {
+		if _, err := wtm.Append(tm); err != nil {
+			t.Fatalf("unable to append the time = %v", err)
+		}
+	}
+
+	list := wtm.List()
+	if len(list) != 3 {
+		t.Fatalf("len expected %d, got %d", 3, len(list))
+	}
+	for i, tm := range list {
+		if tm.id != uint64(i+1) {
+			t.Errorf("id expected %d, got %d", i+1, tm.id)
+		}
+	}
+
+	// If wt is nil the test removes the time.
+	tests := []struct {
+		name    string
+		id      uint64
+		wt      *waterTime
+		wantErr bool
+	}{
+		{name: "remove unknown", id: 10, wantErr: true},
+		{name: "update unknown", id: 10, wt: &waterTime{start: now.Add(9 * time.Minute), end: now.Add(10 * time.Minute)}, wantErr: true},
+		{name: "update in the past", id: 2, wt: &waterTime{start: now.Add(-9 * time.Minute), end: now.Add(5 * time.Minute)}, wantErr: true},
+		{name: "update colliding", id: 2, wt: &waterTime{start: now.Add(5 * time.Minute), end: now.Add(7 * time.Minute)}, wantErr: true},
+		{name: "update itself", id: 2, wt: &waterTime{start: now.Add(4 * time.Minute), end: now.Add(6 * time.Minute)}},
+		{name: "shorten the active one", id: 1, wt: &waterTime{start: now.Add(-1 * time.Minute), end: now.Add(1 * time.Minute)}},
+		{name: "move the active one", id: 1, wt: &waterTime{start: now.Add(-2 * time.Minute), end: now.Add(1 * time.Minute)}, wantErr: true},
+		{name: "remove", id: 3},
+		{name: "remove twice", id: 3, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if tt.wt == nil {
+				err = wtm.Remove(tt.id)
+			} else {
+				err = wtm.Update(tt.id, tt.wt)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("want error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	list = wtm.List()
+	if len(list) != 2 {
+		t.Fatalf("len expected %d, got %d", 2, len(list))
+	}
+	if !list[0].end.Equal(now.Add(1*time.Minute)) || !wtm.isActive(1) {
+		t.Errorf("active time expected shortened, got %v", &list[0])
+	}
+	if !list[1].end.Equal(now.Add(6*time.Minute)) || list[1].id != 2 {
+		t.Errorf("second time expected updated, got %v", &list[1])
+	}
+}
+
+// waitEvent waits an event of the same type of want, skipping the others.
+func waitEvent(t *testing.T, events *subscription, want event) event {
+	t.Helper()
+	for {
+		select {
+		case e := <-events.C:
+			if reflect.TypeOf(e) == reflect.TypeOf(want) {
+				return e
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatalf("timeout waiting event %T", want)
+			return nil
+		}
+	}
+}
+
+// consumerTest is a consumerSchedule running on a fakeClock, the events it publishes are read on sub.
+type consumerTest struct {
+	fc     *fakeClock
+	wtm    *waterTimeManager
+	events *bus
+	sub    *subscription
+	status *systemStatus
+	wg     sync.WaitGroup
+}
+
+// newConsumerTest returns a consumerTest with a manager configured by opts,
+// on a fakeClock a minute before start. The queue is filled before run.
+func newConsumerTest(start time.Time, opts ...managerOption) *consumerTest {
+	fc := newFakeClock(start.Add(-time.Minute))
+	return &consumerTest{
+		fc:     fc,
+		wtm:    newWaterTimeManager(append([]managerOption{withClock(fc)}, opts...)...),
+		events: newBus(),
+		status: newSystemStatus(fc),
+	}
+}
+
+// run starts the consumer and waits until it waits the clock.
+func (ct *consumerTest) run() {
+	ct.sub = ct.events.subscribe("test", 0)
+	ct.wg.Add(1)
+	go consumerSchedule(ct.wtm, ct.events, ct.status, &ct.wg)
+	ct.fc.WaitWaiters(1)
+}
+
+// stop quits the consumer and waits its end.
+func (ct *consumerTest) stop() {
+	ct.events.publish(stopEvent{stopAndQuit})
+	ct.wg.Wait()
+}
+
+func Test_consumerSchedule(t *testing.T) {
+
+	// 2018-08-21 is a Tuesday.
+	loc, _ := time.LoadLocation("Europe/Berlin")
+	ct := newConsumerTest(time.Date(2018, 8, 21, 6, 0, 0, 0, loc))
+	fc, wtm := ct.fc, ct.wtm
+
+	// A whole day: a rule in the morning and a manual time in the evening.
+	// The queue is filled before the consumer starts, as it happens loading it from disk.
+	r, err := parseWaterRule("every day at 06:00 for 20m", fc.Now())
+	if err != nil {
+		t.Fatalf("unable to parse the rule = %v", err)
+	}
+	wtm.horizon = 24 * time.Hour
+	wtm.rules = append(wtm.rules, r)
+	evening := time.Date(2018, 8, 21, 19, 30, 0, 0, loc)
+	wtm.insert(&waterTime{start: evening, end: evening.Add(15 * time.Minute), id: wtm.nextID()})
+	wtm.expandRules(fc.Now())
+	ct.run()
+	sub := ct.sub
+
+	steps := []struct {
+		at      time.Time
+		event   event
+		waiters int
+	}{
+		{time.Date(2018, 8, 21, 6, 0, 0, 0, loc), startZonesEvent{}, 1},
+		{time.Date(2018, 8, 21, 6, 20, 0, 0, loc), stopEvent{stopLocal}, 1},
+		{time.Date(2018, 8, 21, 19, 30, 0, 0, loc), startZonesEvent{}, 1},
+		{time.Date(2018, 8, 21, 19, 45, 0, 0, loc), stopEvent{stopLocal}, 1},
+		{time.Date(2018, 8, 22, 6, 0, 0, 0, loc), startZonesEvent{}, 1},
+	}
+	for _, step := range steps {
+		fc.WaitWaiters(step.waiters)
+		if next, _ := fc.Next(); !next.Equal(step.at) {
+			t.Fatalf("next timer at %v, want %v", next, step.at)
+		}
+		fc.AdvanceTo(step.at)
+		e := waitEvent(t, sub, step.event)
+		if stop, ok := step.event.(stopEvent); ok && e != stop {
+			t.Errorf("stop signal at %v = %v, want %v", step.at, e, stop)
+		}
+	}
+
+	// Cancelling the running time stops the robots.
+	id := wtm.GetNextSlot().id
+	if err := wtm.Remove(id); err != nil {
+		t.Fatalf("unable to remove the time = %v", err)
+	}
+	if e := waitEvent(t, sub, stopEvent{}); e != (stopEvent{stopLocal}) {
+		t.Errorf("stop signal after remove = %v, want %v", e, stopLocal)
+	}
+
+	ct.stop()
+}
+
+func Test_consumerSchedule_fault(t *testing.T) {
+
+	start := time.Date(2018, 8, 21, 6, 0, 0, 0, time.UTC)
+	ct := newConsumerTest(start)
+	fc, wtm, status, events := ct.fc, ct.wtm, ct.status, ct.events
+	wtm.insert(&waterTime{start: start, end: start.Add(20 * time.Minute), zones: []string{"a"}, id: wtm.nextID()})
+	wtm.insert(&waterTime{start: start.Add(30 * time.Minute), end: start.Add(50 * time.Minute), zones: []string{"b"}, id: wtm.nextID()})
+	wtm.insert(&waterTime{start: start.Add(60 * time.Minute), end: start.Add(70 * time.Minute), zones: []string{"c"}, id: wtm.nextID()})
+	ct.run()
+	sub := ct.sub
+
+	fc.AdvanceTo(start)
+	waitEvent(t, sub, startZonesEvent{})
+
+	// A fault while "a" is running: the workers bring the hardware in the safe state.
+	fc.WaitWaiters(1)
+	status.raiseFault(faultNoWater, "no water", 12)
+	events.publish(stopEvent{stopFault})
+	waitEvent(t, sub, stopEvent{})
+
+	// The consumer waits only the end of "a": it looks at the fault before or at that time.
+	fc.WaitWaiters(1)
+	fc.AdvanceTo(start.Add(20 * time.Minute))
+
+	// "b" starts while the fault is active: it's skipped,
+	// also if the fault is acknowledged before its end.
+	fc.WaitWaiters(1)
+	fc.AdvanceTo(start.Add(30 * time.Minute))
+	fc.WaitWaiters(1)
+	if _, err := ackFault(events, status); err != nil {
+		t.Fatalf("unable to acknowledge the fault = %v", err)
+	}
+	// The acknowledgement closes the valves left open by the safe state.
+	if e := waitEvent(t, sub, stopEvent{}); e != (stopEvent{stopRemote}) {
+		t.Errorf("stop signal after the acknowledgement = %v, want %v", e, stopRemote)
+	}
+	fc.AdvanceTo(start.Add(50 * time.Minute))
+
+	// "c" starts after the acknowledgement.
+	fc.WaitWaiters(1)
+	fc.AdvanceTo(start.Add(60 * time.Minute))
+	select {
+	case e := <-sub.C:
+		if z, ok := e.(startZonesEvent); !ok || fmt.Sprint(z.zones) != "[c]" {
+			t.Errorf("event after the fault = %T %v, want startZonesEvent [c]", e, e)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("timeout waiting event startZonesEvent")
+	}
+
+	ct.stop()
+}
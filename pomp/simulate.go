@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tux-eithel/PIrrigation_system/config"
+)
+
+// withRejections records in h the occurrences of the rules rejected by a blackout or a collision.
+// pomp doesn't use it: the simulation lists them in its summary.
+func withRejections(h *runHistory) managerOption {
+	return func(wtm *waterTimeManager) {
+		wtm.rejections = h
+	}
+}
+
+// simValves are the valves of the simulation, in memory.
+// Only the valves it has been created with exist, without valves any valve asked is added.
+// notify (if not nil) receives the open valves after every change.
+type simValves struct {
+	open map[string]bool
+	// anyValve adds the unknown valves when they are opened.
+	anyValve bool
+	notify   func(open []string)
+}
+
+// newSimValves returns a simValves with the given valves, all closed.
+func newSimValves(names ...string) *simValves {
+	v := &simValves{open: make(map[string]bool), anyValve: len(names) == 0}
+	for _, name := range names {
+		v.open[name] = false
+	}
+	return v
+}
+
+func (v *simValves) doRemoteWork(zones []string) error {
+	if len(zones) == 1 && zones[0] == allZones {
+		return v.openAll()
+	}
+
+	for _, name := range zones {
+		if _, ok := v.open[name]; !ok && !v.anyValve {
+			return fmt.Errorf("valve '%s' unknown to the remote robots", name)
+		}
+	}
+	for name := range v.open {
+		v.open[name] = false
+	}
+	for _, name := range zones {
+		v.open[name] = true
+	}
+	v.changed()
+	return nil
+}
+
+func (v *simValves) stopRemoteWork() error {
+	for name := range v.open {
+		v.open[name] = false
+	}
+	v.changed()
+	return nil
+}
+
+func (v *simValves) openAll() error {
+	for name := range v.open {
+		v.open[name] = true
+	}
+	v.changed()
+	return nil
+}
+
+func (v *simValves) close() error {
+	return nil
+}
+
+// changed notifies the open valves, sorted by name.
+func (v *simValves) changed() {
+	if v.notify == nil {
+		return
+	}
+	open := make([]string, 0)
+	for name, o := range v.open {
+		if o {
+			open = append(open, name)
+		}
+	}
+	sort.Strings(open)
+	v.notify(open)
+}
+
+// timeline prints the events of a simulation at the time of its clock.
+type timeline struct {
+	clock clock
+	out   io.Writer
+}
+
+// printf prints a line at the current time of the clock.
+func (tl *timeline) printf(format string, a ...interface{}) {
+	fmt.Fprintf(tl.out, "%s  %s\n", tl.clock.Now().Format("02/01/2006 15:04:05"), fmt.Sprintf(format, a...))
+}
+
+// timelinePump is a simulated pump which prints on the timeline.
+type timelinePump struct {
+	tl *timeline
+}
+
+func (p *timelinePump) Start() error {
+	p.tl.printf("pompa: accesa")
+	return nil
+}
+
+func (p *timelinePump) Stop() error {
+	p.tl.printf("pompa: spenta")
+	return nil
+}
+
+// simWorker is a worker of the simulation: handle does the work of the events taken from sub,
+// it returns true when the worker quits.
+type simWorker struct {
+	sub    *subscription
+	handle func(event) bool
+}
+
+// drainSimulation passes the events to the workers until none of them has something left to do.
+func drainSimulation(events *bus, workers []*simWorker) {
+	for busy := true; busy; {
+		busy = false
+		for _, w := range workers {
+			for e, ok := w.sub.poll(); ok; e, ok = w.sub.poll() {
+				busy = true
+				if w.handle(e) {
+					events.unsubscribe(w.sub)
+					break
+				}
+			}
+		}
+	}
+}
+
+// simulate runs the schedule saved at schedulePath from start for horizon against a virtual clock,
+// with the managerOptions of the policies and the simulated hardware: the valves of zones
+// (any valve if empty) and a sensor configured as sensor, read at its interval of the virtual clock.
+// It prints on out the timeline of the valves, the pump and the sensor.
+// At the end it prints the slots rejected, skipped or stopped before their end.
+// The schedule file is not modified.
+//
+// The workers run on a single goroutine: after every change they take all their events,
+// then the clock moves to the next timer. So the same schedule gives always the same timeline.
+func simulate(policies []managerOption, zones []string, sensor config.Sensor, schedulePath string, start time.Time, horizon time.Duration, out io.Writer) error {
+	if horizon <= 0 {
+		return fmt.Errorf("the horizon of the simulation must be positive, got %v", horizon)
+	}
+
+	// The simulation works on a copy of the schedule: without the last time pomp was running,
+	// it starts as a fresh pomp would, without catching up anything.
+	saved := newScheduleStore(schedulePath)
+	entries, err := saved.load()
+	if err != nil {
+		return err
+	}
+	state, err := saved.state().load()
+	if err != nil {
+		return err
+	}
+	entries = append(entries, state...)
+	dir, err := os.MkdirTemp("", "pomp-simulate")
+	if err != nil {
+		return fmt.Errorf("unable to create the directory of the simulation: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	copied := make([]*storedEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.Alive == nil {
+			copied = append(copied, e)
+		}
+	}
+	store := newScheduleStore(filepath.Join(dir, "schedule"))
+	if err = store.save(copied); err != nil {
+		return err
+	}
+
+	start = start.In(siteTZ.loc).Truncate(time.Second)
+	end := start.Add(horizon)
+	fc := newFakeClock(start)
+	history := newRunHistory("")
+	opts := append([]managerOption{withStore(store), withClock(fc), withHistory(history), withRejections(history)}, policies...)
+	wtm := newWaterTimeManager(opts...)
+	if err = wtm.Load(); err != nil {
+		return err
+	}
+
+	tl := &timeline{clock: fc, out: out}
+	fmt.Fprintf(out, "Simulazione dal %s al %s\n", start.Format("02/01/2006 15:04"), end.Format("02/01/2006 15:04"))
+
+	v := newSimValves(zones...)
+	v.notify = func(open []string) {
+		if len(open) == 0 {
+			tl.printf("valvole: chiuse")
+			return
+		}
+		tl.printf("valvole: aperte %s", strings.Join(open, ", "))
+	}
+
+	events := newBus()
+	status := newSystemStatus(fc)
+	loop := newScheduleLoop(wtm, events, status)
+	// due is true when the schedule has to look at the queue: at its timer, after a fault
+	// or a failure of the valves.
+	due := true
+	var timer clockTimer
+
+	remote := &remoteWorker{name: "remote relays", remote: v, events: events, status: status}
+	relay := &relayWorker{name: "Relay Pompa", relay: &timelinePump{tl: tl}, events: events, status: status}
+	// Without noise the readings, and the sensor values of the records, are the same at every run.
+	level := newSimSensor(512)
+	level.noise = 0
+	mcp := &mcpWorker{name: "Sensore Acqua", mcp: level, cfg: sensor, events: events, status: status}
+	workers := []*simWorker{
+		{events.subscribePolled("remote relays", 0), remote.handle},
+		{events.subscribePolled("Relay Pompa", 0), relay.handle},
+		{events.subscribePolled("Sensore Acqua", 0), mcp.handle},
+		{events.subscribePolled("schedule", 0), func(ev event) bool {
+			if _, ok := ev.(remoteFailureEvent); ok {
+				loop.remoteFailed, due = true, true
+				return false
+			}
+			switch e, _ := ev.(stopEvent); e.signal {
+			case stopFault:
+				due = true
+			case stopAndQuit:
+				loop.quit()
+				return true
+			}
+			return false
+		}},
+		// The timeline of the requests between the workers.
+		{events.subscribePolled("timeline", 0), func(ev event) bool {
+			switch e := ev.(type) {
+			case startZonesEvent:
+				tl.printf("schedule: avvio zone %s", zonesString(e.zones))
+			case changeZonesEvent:
+				tl.printf("schedule: cambio zone %s", zonesString(e.zones))
+			case startSensorEvent:
+				tl.printf("sensore: avviato")
+			case stopEvent:
+				switch e.signal {
+				case stopLocal:
+					tl.printf("schedule: stop, sensore fermato")
+				case stopFault:
+					if f := status.activeFault(); f != nil {
+						tl.printf("ERRORE: %s (%s), sistema in stato sicuro", f.code, f.reason)
+					}
+				case stopAndQuit:
+					return true
+				}
+			}
+			return false
+		}},
+	}
+
+	// The clock moves to the next timer only when the workers are done with the last change.
+	for {
+		drainSimulation(events, workers)
+		if due {
+			due = false
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = nil
+			if next := loop.step(); !next.IsZero() {
+				timer = fc.AfterFunc(next.Sub(fc.Now()), func() { due = true })
+			}
+			continue
+		}
+
+		next, waiting := fc.Next()
+		if !waiting || next.After(end) {
+			break
+		}
+		fc.AdvanceTo(next)
+	}
+	fc.AdvanceTo(end)
+	tl.printf("fine della simulazione: valvole lasciate aperte, come all'uscita di pomp")
+	events.publish(stopEvent{stopAndQuit})
+	drainSimulation(events, workers)
+
+	// The history starts empty with the simulation: a slot running at its start
+	// has been planned before it, so there is no lower bound.
+	printSimulationSummary(out, history.Records(time.Time{}, end))
+	return nil
+}
+
+// printSimulationSummary prints the slots watered and the ones rejected, skipped or stopped.
+func printSimulationSummary(out io.Writer, records []runRecord) {
+	sort.SliceStable(records, func(i, j int) bool { return records[i].PlannedStart.Before(records[j].PlannedStart) })
+
+	completed := 0
+	var watered time.Duration
+	fmt.Fprintln(out, "Schedulazioni non irrigate o interrotte:")
+	for _, r := range records {
+		if r.Outcome == outcomeCompleted {
+			completed++
+			watered += r.PumpOn
+			continue
+		}
+		slot := "regola"
+		if r.Slot != 0 {
+			slot = fmt.Sprintf("%d", r.Slot)
+		}
+		fmt.Fprintf(out, "[%s] %s - %s, Zone %s: %s", slot, r.PlannedStart.Format("02/01/2006 15:04"), r.PlannedEnd.Format("15:04"), zonesString(r.Zones), r.Outcome)
+		if r.StopReason != "" {
+			fmt.Fprintf(out, " (%s)", r.StopReason)
+		}
+		if r.Reason != "" {
+			fmt.Fprintf(out, ": %s", r.Reason)
+		}
+		fmt.Fprintln(out)
+	}
+	fmt.Fprintf(out, "Irrigazioni completate: %d, per %v\n", completed, watered.Round(time.Second))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tux-eithel/PIrrigation_system/config"
+)
+
+func Test_simulate(t *testing.T) {
+
+	now := time.Now().In(siteTZ.loc)
+	path := filepath.Join(t.TempDir(), "schedule")
+	store := newScheduleStore(path)
+	alive := now.Add(-time.Hour)
+	err := store.save([]*storedEntry{
+		// The first slot is skipped because of the pause, the second one waters.
+		{Time: &storedTime{ID: 1, Start: now.Add(30 * time.Minute), End: now.Add(40 * time.Minute), Zones: []string{"front"}}},
+		{Time: &storedTime{ID: 2, Start: now.Add(2 * time.Hour), End: now.Add(2*time.Hour + 10*time.Minute), Zones: []string{"back"}}},
+		// Two pulses of 5 minutes in 25 minutes: only the pulses are watering.
+		{Time: &storedTime{ID: 3, Start: now.Add(150 * time.Minute), End: now.Add(175 * time.Minute), Zones: []string{"front"},
+			Soak: &storedSoak{Run: 5 * time.Minute, Soak: 10 * time.Minute}}},
+		{Pause: &storedPause{Since: now, Until: now.Add(90 * time.Minute), Reason: "rain"}},
+		{Alive: &alive},
+	})
+	if err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	if err = simulate(nil, []string{"back", "front"}, config.Default().Pomp.Sensor, path, now, 3*time.Hour, out); err != nil {
+		t.Fatalf("simulate() error = %v", err)
+	}
+
+	got := out.String()
+	at := now.Add(2 * time.Hour).Truncate(time.Second)
+	for _, want := range []string{
+		at.Format("02/01/2006 15:04:05") + "  valvole: aperte back",
+		"pompa: accesa",
+		"sensore: avviato",
+		"pompa: spenta",
+		"valvole: chiuse",
+		"[1] " + now.Add(30*time.Minute).Format("02/01/2006 15:04") + " - " + now.Add(40*time.Minute).Format("15:04") + ", Zone front: skipped (paused)",
+		"Irrigazioni completate: 2, per 20m0s",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("simulate() output misses %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, now.Add(30*time.Minute).Truncate(time.Second).Format("02/01/2006 15:04:05")+"  valvole: aperte front") {
+		t.Errorf("simulate() opened the skipped slot:\n%s", got)
+	}
+
+	// The same schedule gives the same timeline.
+	again := &bytes.Buffer{}
+	if err = simulate(nil, []string{"back", "front"}, config.Default().Pomp.Sensor, path, now, 3*time.Hour, again); err != nil {
+		t.Fatalf("simulate() error = %v", err)
+	}
+	if again.String() != got {
+		t.Errorf("simulate() again:\n%s\nwant\n%s", again, got)
+	}
+
+	// The schedule is left as it was.
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("simulate() changed the schedule file")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// soakPlan splits a waterTime in pulses: run minutes of water, then soak minutes
+// with the valves closed to let the water soak in, until the end of the waterTime.
+type soakPlan struct {
+	run, soak time.Duration
+}
+
+// parseSoakPlan parses a plan like "8m/15m" (run/soak).
+func parseSoakPlan(s string) (*soakPlan, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("soak must be '<run>/<soak>', got '%s'", s)
+	}
+	run, err := time.ParseDuration(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse run: %v", err)
+	}
+	soak, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse soak: %v", err)
+	}
+	if run <= 0 || soak <= 0 {
+		return nil, fmt.Errorf("run and soak must be positive, got %v and %v", run, soak)
+	}
+	return &soakPlan{run: run, soak: soak}, nil
+}
+
+// String returns the plan like parseSoakPlan reads it.
+func (p *soakPlan) String() string {
+	return fmt.Sprintf("%v/%v", p.run, p.soak)
+}
+
+// wallTime returns how long it takes to deliver runTime of water.
+func (p *soakPlan) wallTime(runTime time.Duration) time.Duration {
+	pulses := (runTime + p.run - 1) / p.run
+	if pulses < 1 {
+		return runTime
+	}
+	return runTime + (pulses-1)*p.soak
+}
+
+// pulse is a part of a waterTime when the water runs.
+type pulse struct {
+	start, end time.Time
+}
+
+// pulses returns the pulses of the waterTime, a single one without soakPlan.
+func (wt *waterTime) pulses() []pulse {
+	if wt.soak == nil {
+		return []pulse{{start: wt.start, end: wt.end}}
+	}
+	pulses := make([]pulse, 0)
+	for start := wt.start; start.Before(wt.end); start = start.Add(wt.soak.run + wt.soak.soak) {
+		end := start.Add(wt.soak.run)
+		if end.After(wt.end) {
+			end = wt.end
+		}
+		pulses = append(pulses, pulse{start: start, end: end})
+	}
+	return pulses
+}
+
+// runTime returns how long the water runs, without the soaks.
+func (wt *waterTime) runTime() time.Duration {
+	var d time.Duration
+	for _, p := range wt.pulses() {
+		d += p.end.Sub(p.start)
+	}
+	return d
+}
+
+// runTimeIn returns how long the water runs in the range [from, to).
+func (wt *waterTime) runTimeIn(from, to time.Time) time.Duration {
+	var d time.Duration
+	for _, p := range wt.pulses() {
+		start, end := p.start, p.end
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			d += end.Sub(start)
+		}
+	}
+	return d
+}
+
+// pulseAt returns the number (from 1) of the pulse running or the last one ended at time now,
+// 0 if no pulse has started, and true if the water is running.
+func (wt *waterTime) pulseAt(now time.Time) (int, bool) {
+	n, running := 0, false
+	for i, p := range wt.pulses() {
+		if p.start.After(now) {
+			break
+		}
+		n, running = i+1, p.end.After(now)
+	}
+	return n, running
+}
+
+// nextPulseChange returns the next start or end of a pulse after now,
+// or the end of the waterTime if there isn't.
+func (wt *waterTime) nextPulseChange(now time.Time) time.Time {
+	for _, p := range wt.pulses() {
+		if p.start.After(now) {
+			return p.start
+		}
+		if p.end.After(now) {
+			return p.end
+		}
+	}
+	return wt.end
+}
+
+// watering returns the waterTimes of active with the water running at time now:
+// the ones soaking are left out.
+func watering(active []waterTime, now time.Time) []waterTime {
+	times := make([]waterTime, 0, len(active))
+	for _, t := range active {
+		if _, running := t.pulseAt(now); running {
+			times = append(times, t)
+		}
+	}
+	return times
+}
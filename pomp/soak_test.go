@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_parseSoakPlan(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    soakPlan
+		wantErr bool
+	}{
+		{"8m/15m", soakPlan{8 * time.Minute, 15 * time.Minute}, false},
+		{"1h/30m", soakPlan{time.Hour, 30 * time.Minute}, false},
+		{"8m", soakPlan{}, true},
+		{"8m/soon", soakPlan{}, true},
+		{"0m/15m", soakPlan{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseSoakPlan(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSoakPlan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && *got != tt.want {
+				t.Errorf("parseSoakPlan() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_waterTime_pulses(t *testing.T) {
+
+	wt, err := newWaterTime("2018-08-21 06:00:00 - 2018-08-21 06:42:00 front soak 8m/15m")
+	if err != nil {
+		t.Fatalf("unable to parse the time = %v", err)
+	}
+	start := wt.start
+
+	// 42m of water: 5 pulses of 8m and one of 2m, with 5 soaks between them.
+	if want := start.Add(42*time.Minute + 5*15*time.Minute); !wt.end.Equal(want) {
+		t.Errorf("end = %v, want %v", wt.end, want)
+	}
+	if got := wt.runTime(); got != 42*time.Minute {
+		t.Errorf("runTime() = %v, want 42m", got)
+	}
+	if got := len(wt.pulses()); got != 6 {
+		t.Errorf("pulses = %d, want 6", got)
+	}
+
+	steps := []struct {
+		at      time.Duration
+		pulse   int
+		running bool
+		next    time.Duration
+	}{
+		{0, 1, true, 8 * time.Minute},
+		{8 * time.Minute, 1, false, 23 * time.Minute},
+		{23 * time.Minute, 2, true, 31 * time.Minute},
+		{116 * time.Minute, 6, true, 117 * time.Minute},
+	}
+	for _, step := range steps {
+		pulse, running := wt.pulseAt(start.Add(step.at))
+		if pulse != step.pulse || running != step.running {
+			t.Errorf("pulseAt(%v) = %d %v, want %d %v", step.at, pulse, running, step.pulse, step.running)
+		}
+		if next := wt.nextPulseChange(start.Add(step.at)); !next.Equal(start.Add(step.next)) {
+			t.Errorf("nextPulseChange(%v) = %v, want %v", step.at, next, start.Add(step.next))
+		}
+	}
+}
+
+func Test_consumerSchedule_soak(t *testing.T) {
+
+	start := time.Date(2018, 8, 21, 6, 0, 0, 0, time.UTC)
+	ct := newConsumerTest(start, withMaxSlot(20*time.Minute))
+	fc, wtm := ct.fc, ct.wtm
+	plan := &soakPlan{run: 8 * time.Minute, soak: 15 * time.Minute}
+	wt := &waterTime{start: start, end: start.Add(plan.wallTime(16 * time.Minute)), zones: []string{"a"}, soak: plan}
+	// The maximum slot is about the water, not the soaks.
+	if err := wtm.checkTime(wt); err != nil {
+		t.Fatalf("checkTime() error = %v", err)
+	}
+	wt.id = wtm.nextID()
+	wtm.insert(wt)
+	ct.run()
+	sub := ct.sub
+
+	// Every pulse starts and stops the cycle.
+	for _, step := range []struct {
+		at    time.Duration
+		event event
+	}{
+		{0, startZonesEvent{}},
+		{8 * time.Minute, stopEvent{}},
+		{23 * time.Minute, startZonesEvent{}},
+		{31 * time.Minute, stopEvent{}},
+	} {
+		fc.WaitWaiters(1)
+		if next, _ := fc.Next(); !next.Equal(start.Add(step.at)) {
+			t.Fatalf("next timer at %v, want %v", next, start.Add(step.at))
+		}
+		fc.AdvanceTo(start.Add(step.at))
+		waitEvent(t, sub, step.event)
+
+		if step.at == 8*time.Minute {
+			s := wtm.PrintStatus()[0]
+			if s.pulse != 1 || s.pulses != 2 || !s.soaking {
+				t.Errorf("status = pulse %d of %d soaking %v, want 1 of 2 soaking", s.pulse, s.pulses, s.soaking)
+			}
+		}
+	}
+
+	ct.stop()
+}
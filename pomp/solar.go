@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// solarEvent is a moment of the day which depends on the sun.
+type solarEvent string
+
+const (
+	solarSunrise solarEvent = "sunrise"
+	solarSunset  solarEvent = "sunset"
+	// solarDawn is the civil dawn, when the sun is 6 degrees under the horizon.
+	solarDawn solarEvent = "dawn"
+	// solarDusk is the civil dusk, when the sun is 6 degrees under the horizon.
+	solarDusk solarEvent = "dusk"
+)
+
+// solarSite is the position of the garden, used to calculate the solar events offline.
+type solarSite struct {
+	latitude, longitude float64
+}
+
+// siteSun is the position of the garden, nil if it's not configured.
+var siteSun *solarSite
+
+// errNoSolarEvent is returned when the sun doesn't rise or set on a day (polar day or night).
+var errNoSolarEvent = errors.New("the sun doesn't cross the horizon on this day")
+
+// solarAnchor is a start time relative to a solar event, like "sunrise-30m".
+type solarAnchor struct {
+	event  solarEvent
+	offset time.Duration
+}
+
+// String returns the anchor as typed by the user.
+func (a solarAnchor) String() string {
+	switch {
+	case a.offset > 0:
+		return fmt.Sprintf("%s+%v", a.event, a.offset)
+	case a.offset < 0:
+		return fmt.Sprintf("%s-%v", a.event, -a.offset)
+	}
+	return string(a.event)
+}
+
+// parseSolarAnchor parses a string like "sunrise", "sunset+15m" or "dawn-1h".
+func parseSolarAnchor(s string) (solarAnchor, error) {
+	a := solarAnchor{}
+	name, offset := s, ""
+	if i := strings.IndexAny(s, "+-"); i >= 0 {
+		name, offset = s[:i], s[i:]
+	}
+
+	switch e := solarEvent(strings.ToLower(name)); e {
+	case solarSunrise, solarSunset, solarDawn, solarDusk:
+		a.event = e
+	default:
+		return a, fmt.Errorf("unknown solar event '%s' (use %s, %s, %s or %s)", name, solarSunrise, solarSunset, solarDawn, solarDusk)
+	}
+
+	if offset != "" {
+		d, err := time.ParseDuration(offset)
+		if err != nil {
+			return a, fmt.Errorf("unable to parse offset: %v", err)
+		}
+		a.offset = d
+	}
+	return a, nil
+}
+
+// parseSiteTime parses a wall clock time of the site like "2018-08-21 10:49:00",
+// or a solar event of a day like "2018-08-21 sunrise-30m".
+func parseSiteTime(s string) (time.Time, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 2 {
+		if a, err := parseSolarAnchor(fields[1]); err == nil {
+			day, err := time.Parse("2006-01-02", fields[0])
+			if err != nil {
+				return time.Time{}, err
+			}
+			return a.time(day.Date())
+		}
+	}
+	return siteTZ.parse(parseTimeConst, s)
+}
+
+// time returns the time of the anchor on the day y-m-d of the site.
+// It returns an error if the position of the garden is not configured
+// or the event doesn't happen that day.
+func (a solarAnchor) time(y int, m time.Month, d int) (time.Time, error) {
+	if siteSun == nil {
+		return time.Time{}, fmt.Errorf("the position of the garden is not configured")
+	}
+	t, err := siteSun.event(y, m, d, a.event)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("no %s on %04d-%02d-%02d: %v", a.event, y, m, d, err)
+	}
+	return t.Add(a.offset).In(siteTZ.loc), nil
+}
+
+// event returns the time of the solar event on the day y-m-d.
+// It uses the sunrise equation, precise to a minute or two, which is enough for a garden.
+func (s *solarSite) event(y int, m time.Month, d int, e solarEvent) (time.Time, error) {
+	const (
+		deg       = math.Pi / 180
+		j2000     = 2451545.0
+		unixJD    = 2440587.5
+		obliquity = 23.4397 * deg
+	)
+
+	// Julian day of the solar noon nearest to the day at the longitude of the garden.
+	jd := float64(time.Date(y, m, d, 12, 0, 0, 0, time.UTC).Unix())/86400 + unixJD
+	n := math.Round(jd - j2000)
+	jStar := n - s.longitude/360
+
+	meanAnomaly := math.Mod(357.5291+0.98560028*jStar, 360) * deg
+	center := 1.9148*math.Sin(meanAnomaly) + 0.0200*math.Sin(2*meanAnomaly) + 0.0003*math.Sin(3*meanAnomaly)
+	eclipticLong := math.Mod(meanAnomaly/deg+center+180+102.9372, 360) * deg
+	transit := j2000 + jStar + 0.0053*math.Sin(meanAnomaly) - 0.0069*math.Sin(2*eclipticLong)
+	declination := math.Asin(math.Sin(eclipticLong) * math.Sin(obliquity))
+
+	// Altitude of the center of the sun at the event: refraction and radius for sunrise and sunset.
+	altitude := -0.833 * deg
+	if e == solarDawn || e == solarDusk {
+		altitude = -6 * deg
+	}
+	lat := s.latitude * deg
+	cosHourAngle := (math.Sin(altitude) - math.Sin(lat)*math.Sin(declination)) / (math.Cos(lat) * math.Cos(declination))
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return time.Time{}, errNoSolarEvent
+	}
+	hourAngle := math.Acos(cosHourAngle) / deg
+
+	j := transit + hourAngle/360
+	if e == solarSunrise || e == solarDawn {
+		j = transit - hourAngle/360
+	}
+	return time.Unix(0, int64((j-unixJD)*86400*float64(time.Second))).UTC().Truncate(time.Second), nil
+}
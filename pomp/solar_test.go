@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_solarSite_event(t *testing.T) {
+
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	la, _ := time.LoadLocation("America/Los_Angeles")
+
+	tests := []struct {
+		name    string
+		site    *solarSite
+		day     time.Time
+		event   solarEvent
+		want    time.Time
+		wantErr bool
+	}{
+		{"berlin sunrise in june", &solarSite{52.52, 13.405}, time.Date(2018, 6, 21, 0, 0, 0, 0, berlin), solarSunrise, time.Date(2018, 6, 21, 4, 43, 0, 0, berlin), false},
+		{"berlin sunset in june", &solarSite{52.52, 13.405}, time.Date(2018, 6, 21, 0, 0, 0, 0, berlin), solarSunset, time.Date(2018, 6, 21, 21, 33, 0, 0, berlin), false},
+		{"berlin dawn in december", &solarSite{52.52, 13.405}, time.Date(2018, 12, 21, 0, 0, 0, 0, berlin), solarDawn, time.Date(2018, 12, 21, 7, 33, 0, 0, berlin), false},
+		{"berlin dusk in december", &solarSite{52.52, 13.405}, time.Date(2018, 12, 21, 0, 0, 0, 0, berlin), solarDusk, time.Date(2018, 12, 21, 16, 35, 0, 0, berlin), false},
+		{"west of greenwich", &solarSite{37.77, -122.42}, time.Date(2018, 8, 21, 0, 0, 0, 0, la), solarSunset, time.Date(2018, 8, 21, 19, 55, 0, 0, la), false},
+		{"polar day", &solarSite{78.2, 15.6}, time.Date(2018, 6, 21, 0, 0, 0, 0, time.UTC), solarSunrise, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.site.event(tt.day.Year(), tt.day.Month(), tt.day.Day(), tt.event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("event() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if d := got.Sub(tt.want); !tt.wantErr && (d < -2*time.Minute || d > 2*time.Minute) {
+				t.Errorf("event() = %v, want %v", got.In(tt.want.Location()), tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseSolarAnchor(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    solarAnchor
+		wantErr bool
+	}{
+		{"sunrise", solarAnchor{solarSunrise, 0}, false},
+		{"sunrise-30m", solarAnchor{solarSunrise, -30 * time.Minute}, false},
+		{"Sunset+1h15m", solarAnchor{solarSunset, 75 * time.Minute}, false},
+		{"dawn", solarAnchor{solarDawn, 0}, false},
+		{"noon", solarAnchor{}, true},
+		{"sunrise-soon", solarAnchor{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseSolarAnchor(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSolarAnchor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseSolarAnchor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_solarTimes(t *testing.T) {
+
+	defer func(old *solarSite) { siteSun = old }(siteSun)
+	siteSun = nil
+
+	anchor := time.Date(2018, 3, 20, 0, 0, 0, 0, siteTZ.loc)
+	if _, err := parseWaterRule("every day at sunrise-30m for 20m", anchor); err == nil {
+		t.Errorf("solar rule without position want error, got nil")
+	}
+
+	siteSun = &solarSite{52.52, 13.405}
+	r, err := parseWaterRule("every day at sunrise-30m for 20m", anchor)
+	if err != nil {
+		t.Fatalf("unable to parse the rule = %v", err)
+	}
+
+	// Every day the start is recalculated.
+	got := r.occurrences(anchor, anchor.AddDate(0, 0, 3))
+	if len(got) != 3 {
+		t.Fatalf("len expected 3, got %d", len(got))
+	}
+	for _, wt := range got {
+		y, m, d := wt.start.Date()
+		sunrise, _ := siteSun.event(y, m, d, solarSunrise)
+		if !wt.start.Equal(sunrise.Add(-30*time.Minute)) || wt.end.Sub(wt.start) != 20*time.Minute {
+			t.Errorf("occurrence %v, want 30m before the sunrise %v", wt, sunrise)
+		}
+	}
+	if got[0].start.Equal(got[2].start.AddDate(0, 0, -2)) {
+		t.Errorf("the start doesn't follow the sun: %v and %v", got[0], got[2])
+	}
+
+	// A one-off slot anchored to the sun.
+	wt, err := newWaterTime("2018-06-21 sunset - 2018-06-21 sunset+30m front")
+	if err != nil {
+		t.Fatalf("unable to parse the time = %v", err)
+	}
+	sunset, _ := siteSun.event(2018, 6, 21, solarSunset)
+	if !wt.start.Equal(sunset) || wt.end.Sub(wt.start) != 30*time.Minute || wt.zones[0] != "front" {
+		t.Errorf("time = %v, want the sunset %v for 30m on front", wt, sunset)
+	}
+}
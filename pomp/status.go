@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// faultCode is the kind of a fault.
+type faultCode string
+
+const (
+	faultNoWater   faultCode = "no_water"
+	faultReadError faultCode = "read_error"
+	faultRemote    faultCode = "remote_failure"
+	faultPump      faultCode = "pump_failure"
+)
+
+// fault is an error which stopped the system.
+type fault struct {
+	code   faultCode
+	reason string
+	at     time.Time
+	// value read from the sensor when the fault happened, -1 if not available.
+	value int
+}
+
+// hardwareState is the state of the pump, the valves and the sensor.
+type hardwareState struct {
+	pumpOn      bool
+	pumpChanged time.Time
+	// pumpOnTime is the time the pump has been on until pumpChanged.
+	pumpOnTime time.Duration
+	// zones open on the remote robots.
+	zones []string
+
+	sensorValue int
+	sensorRead  time.Time
+	// sensorLevel is the calibrated level in percent.
+	sensorLevel float64
+	// waterLow is true after levelLow, until levelRecovered.
+	waterLow bool
+
+	// fault is the fault latched until the operator acknowledges it, nil if there isn't.
+	// While it's active the pump is off, the valves are in the safe state and slots are skipped.
+	fault *fault
+	// lastFault is the last fault, also when acknowledged.
+	lastFault *fault
+}
+
+// systemStatus keeps the hardwareState and the cycle.
+// Workers update it, the console and the api read it.
+type systemStatus struct {
+	state hardwareState
+	// cycle is the state machine of the irrigation cycle.
+	// It's in stateFault when a fault is latched.
+	cycle *cycle
+	// sensorHook is called with every value read from the sensor, it could be nil.
+	sensorHook func(value int)
+	// clock is the clock of the schedule: the times of the hardware follow it.
+	clock clock
+	sync.RWMutex
+}
+
+// newSystemStatus returns an empty systemStatus, which reads the time from clk.
+func newSystemStatus(clk clock) *systemStatus {
+	return &systemStatus{state: hardwareState{sensorValue: -1}, cycle: newCycle(clk), clock: clk}
+}
+
+// setPump saves the state of the pump.
+// It's thread safe.
+func (s *systemStatus) setPump(on bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.state.pumpOn != on {
+		now := s.clock.Now()
+		if s.state.pumpOn {
+			s.state.pumpOnTime += now.Sub(s.state.pumpChanged)
+		}
+		s.state.pumpOn = on
+		s.state.pumpChanged = now
+	}
+}
+
+// pumpTime returns the time the pump has been on since the start, used to measure a run.
+// It's thread safe.
+func (s *systemStatus) pumpTime() time.Duration {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.state.pumpOn {
+		return s.state.pumpOnTime + s.clock.Now().Sub(s.state.pumpChanged)
+	}
+	return s.state.pumpOnTime
+}
+
+// onSensor calls f with every value read from the sensor, it replaces the previous one.
+// f is called without the lock, so it can read the status.
+// It's thread safe.
+func (s *systemStatus) onSensor(f func(value int)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.sensorHook = f
+}
+
+// setZones saves the zones open on the remote robots.
+// It's thread safe.
+func (s *systemStatus) setZones(zones []string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.state.zones = zones
+}
+
+// setSensor saves the last value read from the sensor and its level.
+// It's thread safe.
+func (s *systemStatus) setSensor(value int, level float64) {
+	s.Lock()
+	s.state.sensorValue = value
+	s.state.sensorLevel = level
+	s.state.sensorRead = s.clock.Now()
+	hook := s.sensorHook
+	s.Unlock()
+
+	if hook != nil {
+		hook(value)
+	}
+}
+
+// setWaterLow saves the state of the water level.
+// It's thread safe.
+func (s *systemStatus) setWaterLow(low bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.state.waterLow = low
+}
+
+// raiseFault latches a fault.
+// It returns false if another fault is already active: the first one is kept.
+// It's thread safe.
+func (s *systemStatus) raiseFault(code faultCode, reason string, value int) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.state.fault != nil {
+		return false
+	}
+	f := &fault{code: code, reason: reason, at: s.clock.Now(), value: value}
+	s.state.fault = f
+	s.state.lastFault = f
+	// A fault is valid in every state but another fault, which is excluded above.
+	s.cycle.to(stateFault, reason)
+	return true
+}
+
+// activeFault returns the latched fault, nil if there isn't.
+// It's thread safe.
+func (s *systemStatus) activeFault() *fault {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.state.fault
+}
+
+// ackFault acknowledges the latched fault and returns it.
+// The cycle goes to stateStopping: the valves are still open from the safe state.
+// It returns an error if there isn't a fault to acknowledge.
+// It's thread safe.
+func (s *systemStatus) ackFault() (*fault, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	f := s.state.fault
+	if f == nil {
+		return nil, errors.New("no active fault")
+	}
+	s.state.fault = nil
+	s.cycle.to(stateStopping, "fault acknowledged")
+	return f, nil
+}
+
+// snapshot returns a copy of the hardwareState.
+// It's thread safe.
+func (s *systemStatus) snapshot() hardwareState {
+	s.RLock()
+	defer s.RUnlock()
+
+	state := s.state
+	state.zones = append([]string(nil), s.state.zones...)
+	return state
+}
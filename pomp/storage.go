@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// storedEntry is a line of the schedule file or of its state file.
+// Every line keeps a single waterTime, waterRule, pause, day of usage or the last time
+// the schedule was running, so a truncated
+// or corrupted line doesn't compromise the rest of the file.
+type storedEntry struct {
+	Time  *storedTime  `json:"time,omitempty"`
+	Rule  *storedRule  `json:"rule,omitempty"`
+	Pause *storedPause `json:"pause,omitempty"`
+	Usage *storedUsage `json:"usage,omitempty"`
+	Alive *time.Time   `json:"alive,omitempty"`
+}
+
+// storedTime is the stored version of a waterTime.
+type storedTime struct {
+	ID    uint64      `json:"id"`
+	Start time.Time   `json:"start"`
+	End   time.Time   `json:"end"`
+	Zones []string    `json:"zones,omitempty"`
+	Soak  *storedSoak `json:"soak,omitempty"`
+	// CatchUp is empty for the policy of the manager.
+	CatchUp string `json:"catch_up,omitempty"`
+}
+
+// storedSoak is the stored version of a soakPlan.
+type storedSoak struct {
+	Run  time.Duration `json:"run"`
+	Soak time.Duration `json:"soak"`
+}
+
+// storedRule is the stored version of a waterRule.
+type storedRule struct {
+	Rule     string    `json:"rule"`
+	Anchor   time.Time `json:"anchor"`
+	Expanded time.Time `json:"expanded"`
+}
+
+// storedPause is the stored version of a pause.
+type storedPause struct {
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until,omitempty"`
+	Reason string    `json:"reason"`
+}
+
+// storedUsage is the stored version of the dayUsage of a day.
+type storedUsage struct {
+	Day      string                   `json:"day"`
+	Watering time.Duration            `json:"watering"`
+	Zones    map[string]time.Duration `json:"zones,omitempty"`
+}
+
+// scheduleStore keeps the schedule of a waterTimeManager on disk.
+type scheduleStore struct {
+	path string
+}
+
+// newScheduleStore returns a scheduleStore which uses the file at path.
+func newScheduleStore(path string) *scheduleStore {
+	return &scheduleStore{path: path}
+}
+
+// state returns the store of the running state of the schedule, next to its file:
+// the last time it was running and the water delivered, which change every heartbeat.
+// They are kept apart, so the heartbeat doesn't rewrite the whole schedule.
+func (s *scheduleStore) state() *scheduleStore {
+	return newScheduleStore(s.path + ".state")
+}
+
+// save writes all the entries to the file.
+// The file is first written in a temporary file and then renamed,
+// so a crash during the write leaves the old file in place.
+func (s *scheduleStore) save(entries []*storedEntry) error {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("unable to encode entry: %v", err)
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(buf.Bytes()); err == nil {
+		err = tmp.Sync()
+	}
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write temporary file: %v", err)
+	}
+
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("unable to replace schedule file: %v", err)
+	}
+	return nil
+}
+
+// load reads all the entries from the file.
+// A missing file returns no entries. Lines which can't be decoded
+// (for example a truncated file) are logged and skipped.
+func (s *scheduleStore) load() ([]*storedEntry, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open schedule file: %v", err)
+	}
+	defer f.Close()
+
+	entries := make([]*storedEntry, 0)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		e := &storedEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			log.Printf("schedule file '%s' line %d is corrupted, skip...: %v", s.path, line, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("schedule file '%s' read interrupted, using entries read so far: %v", s.path, err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_scheduleStore(t *testing.T) {
+
+	dir, err := os.MkdirTemp("", "schedule")
+	if err != nil {
+		t.Fatalf("unable to create temporary dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	entries := []*storedEntry{
+		{Time: &storedTime{Start: now.Add(1 * time.Minute), End: now.Add(2 * time.Minute)}},
+		{Time: &storedTime{Start: now.Add(4 * time.Minute), End: now.Add(5 * time.Minute)}},
+		{Rule: &storedRule{Rule: "every day at 06:00 for 20m", Anchor: now, Expanded: now}},
+	}
+
+	tests := []struct {
+		name    string
+		corrupt func(path string) error
+		want    int
+	}{
+		{"missing file", func(path string) error { return os.Remove(path) }, 0},
+		{"normal", func(path string) error { return nil }, 3},
+		{"truncated", func(path string) error {
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			return os.Truncate(path, info.Size()-10)
+		}, 2},
+		{"corrupted line", func(path string) error {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			data[0] = '#'
+			return os.WriteFile(path, data, 0644)
+		}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScheduleStore(filepath.Join(dir, "schedule.json"))
+			if err := s.save(entries); err != nil {
+				t.Fatalf("unable to save entries: %v", err)
+			}
+			if err := tt.corrupt(s.path); err != nil {
+				t.Fatalf("unable to corrupt the file: %v", err)
+			}
+
+			got, err := s.load()
+			if err != nil {
+				t.Fatalf("unable to load entries: %v", err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("len expected %d, got %d", tt.want, len(got))
+			}
+		})
+	}
+}
+
+func Test_waterTimeManager_Load(t *testing.T) {
+
+	dir, err := os.MkdirTemp("", "schedule")
+	if err != nil {
+		t.Fatalf("unable to create temporary dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	store := newScheduleStore(filepath.Join(dir, "schedule.json"))
+
+	now := time.Now()
+	err = store.save([]*storedEntry{
+		{Time: &storedTime{Start: now.Add(-2 * time.Minute), End: now.Add(-1 * time.Minute)}}, // Expired.
+		{Time: &storedTime{Start: now.Add(-1 * time.Minute), End: now.Add(2 * time.Minute)}},  // Active.
+		{Time: &storedTime{Start: now.Add(4 * time.Minute), End: now.Add(5 * time.Minute)}},
+	})
+	if err != nil {
+		t.Fatalf("unable to save entries: %v", err)
+	}
+
+	wtm := newWaterTimeManager(withStore(store))
+	if err := wtm.Load(); err != nil {
+		t.Fatalf("unable to load the manager: %v", err)
+	}
+	if len(wtm.times) != 2 {
+		t.Fatalf("len expected %d, got %d", 2, len(wtm.times))
+	}
+	if !wtm.times[0].start.Equal(now.Add(-1 * time.Minute)) {
+		t.Errorf("first time expected the active one, got %v", wtm.times[0])
+	}
+
+	// Every Append is saved, so a new manager reads it.
+	if _, err := wtm.Append(&waterTime{start: now.Add(6 * time.Minute), end: now.Add(8 * time.Minute)}); err != nil {
+		t.Fatalf("unable to append the time = %v", err)
+	}
+	reloaded := newWaterTimeManager(withStore(store))
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("unable to load the manager: %v", err)
+	}
+	if len(reloaded.times) != 3 {
+		t.Errorf("len expected %d, got %d", 3, len(reloaded.times))
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// defaultTimeZone is the time zone used when nothing is configured.
+	defaultTimeZone = "Europe/Berlin"
+)
+
+// dstPolicy tells how to resolve a wall clock time which is ambiguous
+// (it happens twice when clocks go back) or skipped (it doesn't exist
+// when clocks go forward).
+type dstPolicy string
+
+const (
+	// dstEarlier uses the first occurrence of an ambiguous time,
+	// and moves a skipped time back by the size of the gap.
+	dstEarlier dstPolicy = "earlier"
+	// dstLater uses the second occurrence of an ambiguous time,
+	// and moves a skipped time forward by the size of the gap.
+	dstLater dstPolicy = "later"
+	// dstReject returns an error for ambiguous and skipped times.
+	dstReject dstPolicy = "reject"
+)
+
+// parseDSTPolicy returns the dstPolicy with the given name.
+func parseDSTPolicy(name string) (dstPolicy, error) {
+	switch p := dstPolicy(name); p {
+	case dstEarlier, dstLater, dstReject:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown dst policy '%s' (use %s, %s or %s)", name, dstEarlier, dstLater, dstReject)
+}
+
+// siteTimeZone is the time zone of the garden.
+// It's used to read every time typed by the user.
+type siteTimeZone struct {
+	loc    *time.Location
+	policy dstPolicy
+}
+
+// siteTZ is the time zone used by the schedule.
+var siteTZ = defaultSiteTimeZone()
+
+// defaultSiteTimeZone returns the siteTimeZone used when nothing is configured.
+func defaultSiteTimeZone() *siteTimeZone {
+	tz, _ := newSiteTimeZone(defaultTimeZone, dstEarlier)
+	return tz
+}
+
+// newSiteTimeZone returns the siteTimeZone with the given name.
+// If the time zone can't be loaded (for example tzdata is missing)
+// it returns a siteTimeZone in UTC along with the error, so the caller can report it.
+func newSiteTimeZone(name string, policy dstPolicy) (*siteTimeZone, error) {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return &siteTimeZone{loc: time.UTC, policy: policy}, fmt.Errorf("unable to load time zone '%s', using UTC: %v", name, err)
+	}
+	return &siteTimeZone{loc: loc, policy: policy}, nil
+}
+
+// String returns the name of the time zone.
+func (tz *siteTimeZone) String() string {
+	return fmt.Sprintf("%s (dst %s)", tz.loc, tz.policy)
+}
+
+// parse parses a value with the layout as a wall clock time of the site.
+func (tz *siteTimeZone) parse(layout, value string) (time.Time, error) {
+	t, err := time.ParseInLocation(layout, value, time.UTC)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return tz.date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())
+}
+
+// date returns the time of the wall clock y-m-d h:mi:s of the site.
+// Ambiguous or skipped times are resolved with the policy of the time zone.
+func (tz *siteTimeZone) date(y int, m time.Month, d, h, mi, s int) (time.Time, error) {
+	wall := time.Date(y, m, d, h, mi, s, 0, time.UTC)
+
+	// A DST change moves the offset, so we take the offsets of the day
+	// before and after and look which ones give back the same wall clock.
+	_, before := wall.Add(-24 * time.Hour).In(tz.loc).Zone()
+	_, after := wall.Add(24 * time.Hour).In(tz.loc).Zone()
+
+	first := wall.Add(-time.Duration(after) * time.Second).In(tz.loc)
+	second := wall.Add(-time.Duration(before) * time.Second).In(tz.loc)
+	if first.After(second) {
+		first, second = second, first
+	}
+
+	same := func(t time.Time) bool {
+		ty, tm, td := t.Date()
+		return ty == y && tm == m && td == d && t.Hour() == h && t.Minute() == mi && t.Second() == s
+	}
+
+	switch {
+	case first.Equal(second) || same(first) != same(second):
+		// Only one time matches the wall clock.
+		if same(first) {
+			return first, nil
+		}
+		return second, nil
+
+	case same(first) && tz.policy == dstReject:
+		return time.Time{}, fmt.Errorf("time %v is ambiguous in %s", wall.Format(parseTimeConst), tz.loc)
+
+	case !same(first) && tz.policy == dstReject:
+		return time.Time{}, fmt.Errorf("time %v doesn't exist in %s", wall.Format(parseTimeConst), tz.loc)
+
+	case tz.policy == dstLater:
+		return second, nil
+	}
+
+	return first, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_newSiteTimeZone(t *testing.T) {
+
+	tz, err := newSiteTimeZone("Europe/Berlin", dstEarlier)
+	if err != nil || tz.loc.String() != "Europe/Berlin" {
+		t.Errorf("want Europe/Berlin, got %v with error %v", tz, err)
+	}
+
+	tz, err = newSiteTimeZone("Mars/Olympus_Mons", dstEarlier)
+	if err == nil {
+		t.Errorf("want an error for an unknown time zone")
+	}
+	if tz == nil || tz.loc != time.UTC {
+		t.Errorf("want UTC as fallback, got %v", tz)
+	}
+}
+
+func Test_parseDSTPolicy(t *testing.T) {
+	for _, name := range []string{"earlier", "later", "reject"} {
+		if _, err := parseDSTPolicy(name); err != nil {
+			t.Errorf("unable to parse policy '%s': %v", name, err)
+		}
+	}
+	if _, err := parseDSTPolicy("never"); err == nil {
+		t.Errorf("want an error for an unknown policy")
+	}
+}
+
+func Test_siteTimeZone_parse(t *testing.T) {
+
+	loc, _ := time.LoadLocation("Europe/Berlin")
+	cest := time.FixedZone("CEST", 2*60*60)
+	cet := time.FixedZone("CET", 1*60*60)
+
+	tests := []struct {
+		name    string
+		value   string
+		policy  dstPolicy
+		want    time.Time
+		wantErr bool
+	}{
+		{"normal", "2018-08-21 10:49:00", dstReject, time.Date(2018, 8, 21, 10, 49, 0, 0, cest), false},
+		{"before the gap", "2018-03-25 01:59:00", dstReject, time.Date(2018, 3, 25, 1, 59, 0, 0, cet), false},
+		{"after the gap", "2018-03-25 03:00:00", dstReject, time.Date(2018, 3, 25, 3, 0, 0, 0, cest), false},
+		{"skipped earlier", "2018-03-25 02:30:00", dstEarlier, time.Date(2018, 3, 25, 1, 30, 0, 0, cet), false},
+		{"skipped later", "2018-03-25 02:30:00", dstLater, time.Date(2018, 3, 25, 3, 30, 0, 0, cest), false},
+		{"skipped reject", "2018-03-25 02:30:00", dstReject, time.Time{}, true},
+		{"ambiguous earlier", "2018-10-28 02:30:00", dstEarlier, time.Date(2018, 10, 28, 2, 30, 0, 0, cest), false},
+		{"ambiguous later", "2018-10-28 02:30:00", dstLater, time.Date(2018, 10, 28, 2, 30, 0, 0, cet), false},
+		{"ambiguous reject", "2018-10-28 02:30:00", dstReject, time.Time{}, true},
+		{"wrong value", "2018-10-28 02:30", dstEarlier, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tz := &siteTimeZone{loc: loc, policy: tt.policy}
+			got, err := tz.parse(parseTimeConst, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("want error %v, got %v", tt.wantErr, err)
+				return
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("time = %v, want %v", got, tt.want)
+			}
+			if !tt.wantErr && got.Location() != loc {
+				t.Errorf("location = %v, want %v", got.Location(), loc)
+			}
+		})
+	}
+}
@@ -1,44 +1,53 @@
 package main
 
 import (
-	"time"
+	"sync"
 
-	"gobot.io/x/gobot"
-	"gobot.io/x/gobot/drivers/gpio"
-	"gobot.io/x/gobot/drivers/spi"
+	"github.com/tux-eithel/PIrrigation_system/config"
 )
 
-// readsFromMCP uses an eventer to read at specific interval
-// the value from the chip.
-// It returns two channels: one for listen the events, the other to close this function
-func readsFromMCP(mcp *spi.MCP3008Driver, interval time.Duration, threshold int) (<-chan *gobot.Event, chan struct{}) {
-	oldValue := -1 // TODO: make this a parameter
-	streamValues := gobot.NewEventer()
-	streamValues.AddEvent(gpio.Error)
-	streamValues.AddEvent(gpio.Data)
-	halt := make(chan struct{})
-	events := streamValues.Subscribe()
+// readsFromMCP reads the value from a channel of the chip now and then at every interval of clk.
+// Every value is passed to handle as levelReadingEvent, a change of the level as levelLowEvent
+// or levelRecoveredEvent and a read error as readErrorEvent.
+// handle is never called twice at the same time.
+// It returns a function to stop the readings: after it returns handle isn't called anymore.
+func readsFromMCP(mcp analogSensor, cfg config.Sensor, clk clock, handle func(sensorEvent)) (stop func()) {
+	detector := newLevelDetector(cfg)
+	var (
+		mu      sync.Mutex
+		timer   clockTimer
+		stopped bool
+	)
 
-	go func() {
-		for {
-			newValue, err := mcp.Read(2)
-			if err != nil {
-				streamValues.Publish(gpio.Error, err)
-			} else if !(oldValue-threshold <= newValue && newValue <= oldValue+threshold) { // Send the value only if it differs for the previous one by the threshold
-				if oldValue != -1 {
-					streamValues.Publish(gpio.Data, newValue)
-				}
-				oldValue = newValue
-			}
+	var read func()
+	read = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopped {
+			return
+		}
 
-			select {
-			case <-time.After(interval): // Wait an interval
-			case <-halt: // Close the function
-				close(events)
-				return
+		newValue, err := mcp.Read(cfg.Channel)
+		if err != nil {
+			handle(readErrorEvent{err})
+		} else {
+			r, change := detector.update(newValue)
+			handle(levelReadingEvent{r})
+			if change != nil {
+				handle(change)
 			}
 		}
-	}()
+		// Wait an interval
+		timer = clk.AfterFunc(cfg.Interval, read)
+	}
+	read()
 
-	return events, halt
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+		if timer != nil {
+			timer.Stop()
+		}
+	}
 }
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// allZones is the zone of a waterTime without zones: it opens every valve.
+	allZones = "*"
+)
+
+// parseZones returns the zones of a comma separated list.
+// Zones are sorted and duplicates are removed.
+func parseZones(s string) ([]string, error) {
+	set := make(map[string]bool)
+	for _, z := range strings.Split(s, ",") {
+		z = strings.TrimSpace(z)
+		if z == "" || z == allZones {
+			return nil, fmt.Errorf("invalid zone '%s' in '%s'", z, s)
+		}
+		set[z] = true
+	}
+
+	zones := make([]string, 0, len(set))
+	for z := range set {
+		zones = append(zones, z)
+	}
+	sort.Strings(zones)
+	return zones, nil
+}
+
+// zonesString returns a human readable version of the zones.
+func zonesString(zones []string) string {
+	if len(zones) == 0 {
+		return "all zones"
+	}
+	return strings.Join(zones, ",")
+}
+
+// sameZones returns true if the two sorted lists contain the same zones.
+func sameZones(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sharesZones returns true if the two waterTimes use at least a common valve.
+// A waterTime without zones uses all the valves.
+func (wt *waterTime) sharesZones(o *waterTime) bool {
+	if len(wt.zones) == 0 || len(o.zones) == 0 {
+		return true
+	}
+	for _, z := range wt.zones {
+		for _, oz := range o.zones {
+			if z == oz {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// overlaps returns true if the two waterTimes run at the same time.
+func (wt *waterTime) overlaps(o *waterTime) bool {
+	return wt.start.Before(o.end) && o.start.Before(wt.end)
+}
+
+// checkZones checks that the zones are known by the system.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) checkZones(zones []string) error {
+	if len(wtm.zones) == 0 {
+		return nil
+	}
+	for _, z := range zones {
+		if !wtm.zones[z] {
+			return fmt.Errorf("unknown zone '%s'", z)
+		}
+	}
+	return nil
+}
+
+// checkCapacity checks that adding t doesn't open more than maxOpenZones valves at once.
+// A waterTime without zones opens all the valves: it's rejected if the zones are not known.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) checkCapacity(t *waterTime) error {
+	if wtm.maxOpenZones <= 0 {
+		return nil
+	}
+	if len(t.zones) == 0 && len(wtm.zones) == 0 {
+		return &conflictError{msg: fmt.Sprintf("time range without zones opens all the valves, max %d zones open at once", wtm.maxOpenZones)}
+	}
+
+	others := make([]*waterTime, 0)
+	points := []time.Time{t.start}
+	for _, o := range wtm.times {
+		if t.id != 0 && o.id == t.id || !t.overlaps(o) {
+			continue
+		}
+		others = append(others, o)
+		if o.start.After(t.start) {
+			points = append(points, o.start)
+		}
+	}
+
+	// The number of open zones changes only when a waterTime starts.
+	for _, p := range points {
+		open := wtm.openedZones(t)
+		for _, o := range others {
+			if o.active(p) {
+				open += wtm.openedZones(o)
+			}
+		}
+		if open > wtm.maxOpenZones {
+			return &conflictError{msg: fmt.Sprintf("%d zones would be open at %v, max %d", open, p, wtm.maxOpenZones)}
+		}
+	}
+	return nil
+}
+
+// openedZones returns the number of valves opened by t: all the known zones if it has no zones.
+// The caller must hold the lock.
+func (wtm *waterTimeManager) openedZones(t *waterTime) int {
+	if len(t.zones) == 0 {
+		return len(wtm.zones)
+	}
+	return len(t.zones)
+}
+
+// nextChange returns the waterTimes active now and the time of the next start or end
+// of a waterTime or of its pulses, of a blackout stopping an active waterTime
+// or of the automatic resume of the pause (zero if there isn't).
+// Like GetNextSlot it expands the rules and removes the expired times, it also ends an expired pause.
+// It's thread safe.
+func (wtm *waterTimeManager) nextChange() ([]waterTime, time.Time) {
+	wtm.Lock()
+	defer wtm.Unlock()
+
+	now := wtm.clock.Now()
+	added := wtm.expandRules(now)
+	removed := wtm.removeExpired(now)
+	resumed := wtm.expirePause(now)
+	if added > 0 || removed > 0 || resumed {
+		wtm.persist()
+	}
+
+	active := make([]waterTime, 0)
+	var next time.Time
+	if wtm.pause != nil {
+		next = wtm.pause.until
+	}
+	for _, t := range wtm.times {
+		change := t.start
+		if t.active(now) {
+			change = t.nextPulseChange(now)
+			active = append(active, *t)
+			for _, w := range wtm.blackoutWindows(now, t.end) {
+				if w.start.After(now) && w.start.Before(change) {
+					change = w.start
+				}
+			}
+		}
+		if next.IsZero() || change.Before(next) {
+			next = change
+		}
+	}
+	return active, next
+}
+
+// openZones returns the zones to open for the active waterTimes, except the skipped ones.
+func openZones(active []waterTime, skipped map[uint64]bool) []string {
+	set := make(map[string]bool)
+	for _, t := range active {
+		if skipped[t.id] {
+			continue
+		}
+		if len(t.zones) == 0 {
+			set[allZones] = true
+		}
+		for _, z := range t.zones {
+			set[z] = true
+		}
+	}
+
+	// A waterTime without zones opens every valve, so the others don't matter.
+	if set[allZones] {
+		return []string{allZones}
+	}
+
+	zones := make([]string, 0, len(set))
+	for z := range set {
+		zones = append(zones, z)
+	}
+	sort.Strings(zones)
+	return zones
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_parseZones(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{"single", "front", []string{"front"}, false},
+		{"sorted without duplicates", "back, front,back", []string{"back", "front"}, false},
+		{"empty zone", "front,,back", nil, true},
+		{"all zones", "*", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseZones(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("want error %v, got %v", tt.wantErr, err)
+				return
+			}
+			if !sameZones(got, tt.want) {
+				t.Errorf("zones = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_waterTimeManager_AppendZones(t *testing.T) {
+
+	now := time.Now()
+	tnow1Minute := now.Add(1 * time.Minute)
+	tnow2Minute := now.Add(2 * time.Minute)
+	tnow3Minute := now.Add(3 * time.Minute)
+	tnow4Minute := now.Add(4 * time.Minute)
+
+	tests := []struct {
+		name     string
+		maxZones int
+		maxSlot  time.Duration
+		known    []string
+		args     []*waterTime
+		wantErr  bool
+	}{
+		{name: "different zones", args: []*waterTime{{start: tnow1Minute, end: tnow3Minute, zones: []string{"a"}}, {start: tnow2Minute, end: tnow4Minute, zones: []string{"b"}}}},
+		{name: "shared zone", args: []*waterTime{{start: tnow1Minute, end: tnow3Minute, zones: []string{"a", "b"}}, {start: tnow2Minute, end: tnow4Minute, zones: []string{"b"}}}, wantErr: true},
+		{name: "same start, longer", args: []*waterTime{{start: tnow1Minute, end: tnow2Minute, zones: []string{"a"}}, {start: tnow1Minute, end: tnow3Minute, zones: []string{"a"}}}, wantErr: true},
+		{name: "same start, shorter", args: []*waterTime{{start: tnow1Minute, end: tnow3Minute, zones: []string{"a"}}, {start: tnow1Minute, end: tnow2Minute, zones: []string{"a"}}}, wantErr: true},
+		{name: "same end, longer", args: []*waterTime{{start: tnow2Minute, end: tnow3Minute, zones: []string{"a"}}, {start: tnow1Minute, end: tnow3Minute, zones: []string{"a"}}}, wantErr: true},
+		{name: "same range", args: []*waterTime{{start: tnow1Minute, end: tnow3Minute, zones: []string{"a"}}, {start: tnow1Minute, end: tnow3Minute, zones: []string{"a"}}}, wantErr: true},
+		{name: "without zones collides", args: []*waterTime{{start: tnow1Minute, end: tnow3Minute, zones: []string{"a"}}, {start: tnow2Minute, end: tnow4Minute}}, wantErr: true},
+		{name: "within capacity", maxZones: 2, args: []*waterTime{{start: tnow1Minute, end: tnow3Minute, zones: []string{"a"}}, {start: tnow2Minute, end: tnow4Minute, zones: []string{"b"}}}},
+		{name: "over capacity", maxZones: 2, args: []*waterTime{{start: tnow1Minute, end: tnow3Minute, zones: []string{"a"}}, {start: tnow2Minute, end: tnow4Minute, zones: []string{"b", "c"}}}, wantErr: true},
+		{name: "over capacity later", maxZones: 2, args: []*waterTime{{start: tnow2Minute, end: tnow4Minute, zones: []string{"b", "c"}}, {start: tnow1Minute, end: tnow3Minute, zones: []string{"a"}}}, wantErr: true},
+		{name: "capacity after the end", maxZones: 2, args: []*waterTime{{start: tnow1Minute, end: tnow2Minute, zones: []string{"a"}}, {start: tnow2Minute, end: tnow4Minute, zones: []string{"b", "c"}}}},
+		{name: "without zones within capacity", maxZones: 2, known: []string{"a", "b"}, args: []*waterTime{{start: tnow1Minute, end: tnow3Minute}}},
+		{name: "without zones over capacity", maxZones: 2, known: []string{"a", "b", "c"}, args: []*waterTime{{start: tnow1Minute, end: tnow3Minute}}, wantErr: true},
+		{name: "without zones unknown", maxZones: 2, args: []*waterTime{{start: tnow1Minute, end: tnow3Minute}}, wantErr: true},
+		{name: "known zone", known: []string{"a", "b"}, args: []*waterTime{{start: tnow1Minute, end: tnow3Minute, zones: []string{"a"}}}},
+		{name: "unknown zone", known: []string{"a", "b"}, args: []*waterTime{{start: tnow1Minute, end: tnow3Minute, zones: []string{"c"}}}, wantErr: true},
+		{name: "within max slot", maxSlot: 2 * time.Minute, args: []*waterTime{{start: tnow1Minute, end: tnow3Minute, zones: []string{"a"}}}},
+		{name: "over max slot", maxSlot: 2 * time.Minute, args: []*waterTime{{start: tnow1Minute, end: tnow4Minute, zones: []string{"a"}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wtm := newWaterTimeManager(withMaxOpenZones(tt.maxZones), withMaxSlot(tt.maxSlot), withZones(tt.known))
+
+			var err error
+			for _, tm := range tt.args {
+				_, err = wtm.Append(tm)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("want error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_waterTimeManager_nextChange(t *testing.T) {
+
+	start := time.Date(2018, 8, 21, 6, 0, 0, 0, time.UTC)
+	fc := newFakeClock(start)
+	wtm := newWaterTimeManager(withClock(fc))
+	wtm.insert(&waterTime{start: start, end: start.Add(20 * time.Minute), zones: []string{"front"}, id: wtm.nextID()})
+	wtm.insert(&waterTime{start: start.Add(10 * time.Minute), end: start.Add(30 * time.Minute), zones: []string{"back"}, id: wtm.nextID()})
+	wtm.insert(&waterTime{start: start.Add(40 * time.Minute), end: start.Add(50 * time.Minute), id: wtm.nextID()})
+
+	steps := []struct {
+		at    time.Duration
+		zones []string
+		next  time.Duration
+	}{
+		{0, []string{"front"}, 10 * time.Minute},
+		{10 * time.Minute, []string{"back", "front"}, 20 * time.Minute},
+		{20 * time.Minute, []string{"back"}, 30 * time.Minute},
+		{30 * time.Minute, []string{}, 40 * time.Minute},
+		{40 * time.Minute, []string{allZones}, 50 * time.Minute},
+		{50 * time.Minute, []string{}, 0},
+	}
+	for _, step := range steps {
+		fc.AdvanceTo(start.Add(step.at))
+		active, next := wtm.nextChange()
+		zones := openZones(active, nil)
+		if !sameZones(zones, step.zones) {
+			t.Errorf("zones at %v = %v, want %v", step.at, zones, step.zones)
+		}
+		if step.next == 0 && !next.IsZero() || step.next != 0 && !next.Equal(start.Add(step.next)) {
+			t.Errorf("next change at %v = %v, want %v", step.at, next, step.next)
+		}
+	}
+}
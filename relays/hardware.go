@@ -0,0 +1,102 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/tux-eithel/PIrrigation_system/config"
+	"gobot.io/x/gobot"
+	"gobot.io/x/gobot/drivers/gpio"
+	"gobot.io/x/gobot/platforms/raspi"
+)
+
+// actuator is a relay which moves the valves.
+// *gpio.GroveRelayDriver satisfies it.
+type actuator interface {
+	On() error
+	Off() error
+}
+
+// hardware is the hardware used by relays.
+type hardware struct {
+	// relayPlus and relayMinus controls the direcotion of current
+	relayPlus  actuator
+	relayMinus actuator
+	// valves by name
+	valves map[string]actuator
+	// robots are started and stopped with the program.
+	robots gobot.Robots
+}
+
+// newPiHardware returns the hardware of the Raspberry Pi wired as cfg.
+// pins are the pins of the valves, by name.
+func newPiHardware(cfg config.Relays, pins map[string]string) *hardware {
+	r := raspi.NewAdaptor()
+
+	// relayPlus and relayMinus controls the direcotion of current
+	relayPlus := gpio.NewGroveRelayDriver(r, cfg.PlusPin)
+	relayMinus := gpio.NewGroveRelayDriver(r, cfg.MinusPin)
+
+	// The bi-stable valves
+	devices := []gobot.Device{relayPlus, relayMinus}
+	valves := make(map[string]actuator)
+	for name, pin := range pins {
+		valve := gpio.NewGroveRelayDriver(r, pin)
+		valves[name] = valve
+		devices = append(devices, valve)
+	}
+
+	// Prepare the robot
+	r1 := gobot.NewRobot("relays",
+		[]gobot.Connection{r},
+		devices,
+	)
+
+	return &hardware{
+		relayPlus:  relayPlus,
+		relayMinus: relayMinus,
+		valves:     valves,
+		robots:     gobot.Robots{r1},
+	}
+}
+
+// newSimHardware returns a simulated hardware, which runs everywhere.
+func newSimHardware(cfg config.Relays, pins map[string]string) *hardware {
+	valves := make(map[string]actuator)
+	for name, pin := range pins {
+		valves[name] = &simActuator{name: "valve " + name + " (pin " + pin + ")"}
+	}
+	return &hardware{
+		relayPlus:  &simActuator{name: "relay plus (pin " + cfg.PlusPin + ")"},
+		relayMinus: &simActuator{name: "relay minus (pin " + cfg.MinusPin + ")"},
+		valves:     valves,
+	}
+}
+
+// simActuator is a simulated relay: it only logs.
+type simActuator struct {
+	name string
+	high bool
+	sync.Mutex
+}
+
+// On sets "HIGH" the simulated pin.
+func (a *simActuator) On() error {
+	return a.set(true)
+}
+
+// Off sets "LOW" the simulated pin.
+func (a *simActuator) Off() error {
+	return a.set(false)
+}
+
+func (a *simActuator) set(high bool) error {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.high != high {
+		log.Printf("simulated %s: high=%v", a.name, high)
+	}
+	a.high = high
+	return nil
+}
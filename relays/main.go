@@ -4,96 +4,130 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
-	"gobot.io/x/gobot"
-	"gobot.io/x/gobot/drivers/gpio"
-	"gobot.io/x/gobot/platforms/raspi"
+	"github.com/tux-eithel/PIrrigation_system/config"
+	"github.com/tux-eithel/PIrrigation_system/valves"
+	"google.golang.org/grpc"
 )
 
 func main() {
-	r := raspi.NewAdaptor()
 
-	// relayPlus and relayMinus controls the direcotion of current
-	relayPlus := gpio.NewGroveRelayDriver(r, "11")
-	relayMinus := gpio.NewGroveRelayDriver(r, "13")
-
-	// First bi-stable valve
-	valve1 := gpio.NewGroveRelayDriver(r, "15")
+	listen := flag.String("listen", ":50051", "address of the gRPC server")
+	valvePins := flag.String("valves", "1=15", "comma separated list of valves as name=pin")
+	sim := flag.Bool("sim", false, "use a simulated hardware instead of the Raspberry Pi")
+	pulse := flag.Duration("pulse", 500*time.Millisecond, "how long the current is sent to a valve to move it")
+	configPath := flag.String("config", "", "configuration file (see config/example.yaml), flags set on the command line win over it")
+	flag.Parse()
+
+	// The configuration: the file, if any, then the flags set on the command line.
+	cfg := config.Default()
+	var err error
+	if *configPath != "" {
+		cfg, err = config.Load(*configPath)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			cfg.Relays.Listen = *listen
+		case "pulse":
+			cfg.Relays.Pulse = *pulse
+		case "valves":
+			cfg.Zones, err = parseValves(*valvePins)
+		}
+	})
+	if err != nil {
+		log.Fatalln("invalid configuration:", err)
+	}
+	if err = cfg.Validate(); err != nil {
+		log.Fatalln(err)
+	}
 
-	// Prepare the robot
-	r1 := gobot.NewRobot("relays",
-		[]gobot.Connection{r},
-		[]gobot.Device{valve1, relayPlus, relayMinus},
-	)
+	// The hardware: the Raspberry Pi or a simulated one.
+	var hw *hardware
+	if *sim {
+		log.Println("WARNING: simulated hardware")
+		hw = newSimHardware(cfg.Relays, cfg.Zones)
+	} else {
+		hw = newPiHardware(cfg.Relays, cfg.Zones)
+	}
 
 	// Starts all the robots!
 	// We pass "false" as parameter so we can manually stop the robots.
-	robots := gobot.Robots{r1}
-	err := robots.Start(false)
+	err = hw.robots.Start(false)
 	if err != nil {
 		log.Fatalln("Unable to start robots:", err)
 	}
 
-	go func() {
-
-		// Reset the relays.
-		// A release is closed when you set "HIGH" the pin.
-		relayMinus.On()
-		relayPlus.On()
-		valve1.On()
-
-		// After 5 seconds, we try to open all the valves.
-		// This is made for security reason. If we are unable to close valves,
-		// at least water will come out without damage the pomp.
-		<-time.After(5 * time.Second)
-		fmt.Println("try to open valve")
-		valve1.Off()
-		<-time.After(500 * time.Millisecond) // We wait a bit.
-		fmt.Println("should be open")
-		valve1.On()
+	vc := newValveController(hw.relayPlus, hw.relayMinus, hw.valves, cfg.Relays.Pulse)
+	if err = vc.reset(); err != nil {
+		log.Fatalln("Unable to reset the relays:", err)
+	}
 
-		// After 5 second we try to close a valve.
-		// This part simulate a "irrigation program" where user could
-		// choose which part of the garden has to be irrigated.
-		<-time.After(5 * time.Second)
-		fmt.Println("try to close the valve")
-		// Invert the current.
-		relayMinus.Off()
-		relayPlus.Off()
+	// At the start we open all the valves.
+	// This is made for security reason. If we are unable to close valves,
+	// at least water will come out without damage the pomp.
+	fmt.Println("starting procedure... open all the valve")
+	if err = vc.openAll(); err != nil {
+		log.Printf("unable to open all the valves: %v", err)
+	}
 
-		// Activate the valve.
-		valve1.Off()
-		fmt.Println("should be close")
-		<-time.After(500 * time.Millisecond) // We wait a bit.
-		valve1.On()
+	// The gRPC server, used by "pomp" to open and close the valves.
+	lis, err := net.Listen("tcp", cfg.Relays.Listen)
+	if err != nil {
+		log.Fatalln("Unable to listen:", err)
+	}
+	server := grpc.NewServer()
+	valves.RegisterValvesServer(server, &valvesServer{vc: vc})
+	go func() {
+		log.Printf("gRPC server listening on %s", cfg.Relays.Listen)
+		if err := server.Serve(lis); err != nil {
+			log.Printf("gRPC server stopped: %v", err)
+		}
 	}()
 
 	// Wait the ctrl-c signal
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	<-c
+	server.GracefulStop()
 
 	fmt.Println("closing procedure... open all the valve")
-	relayMinus.On()
-	relayPlus.On()
-	valve1.Off()
-	fmt.Println("should be open")
-	<-time.After(500 * time.Millisecond)
-	valve1.On()
+	if err = vc.openAll(); err != nil {
+		log.Printf("unable to open all the valves: %v", err)
+	}
 
 	// Stop all the robots
 	log.Println("wait all robots closes...")
-	err = robots.Stop()
+	err = hw.robots.Stop()
 	if err != nil {
 		log.Fatalln("Unable to stop robots:", err)
 	}
 }
 
-// TODO: function to open all the valve (used at the start and at the end)
-
-// TODO: define protobuf schema for comunication with the main raspberry
+// parseValves parses a comma separated list of name=pin.
+// Pins are checked by config.Validate.
+func parseValves(s string) (map[string]string, error) {
+	pins := make(map[string]string)
+	for _, v := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(v), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid valve '%s', want name=pin", v)
+		}
+		if _, ok := pins[parts[0]]; ok {
+			return nil, fmt.Errorf("duplicate valve '%s'", parts[0])
+		}
+		pins[parts[0]] = parts[1]
+	}
+	return pins, nil
+}
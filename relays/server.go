@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	"github.com/tux-eithel/PIrrigation_system/valves"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// valvesServer implements the gRPC valves.ValvesServer.
+type valvesServer struct {
+	valves.UnimplementedValvesServer
+	vc *valveController
+}
+
+// Open opens the named valves, closing the others if the request is exclusive.
+func (s *valvesServer) Open(ctx context.Context, req *valves.OpenRequest) (*valves.ValvesState, error) {
+	if err := s.vc.openValves(req.GetValves(), req.GetExclusive()); err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to open valves: %v", err)
+	}
+	return s.state(), nil
+}
+
+// Close closes the named valves, all the valves if the list is empty.
+func (s *valvesServer) Close(ctx context.Context, req *valves.CloseRequest) (*valves.ValvesState, error) {
+	if err := s.vc.closeValves(req.GetValves()); err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to close valves: %v", err)
+	}
+	return s.state(), nil
+}
+
+// OpenAll opens all the valves.
+func (s *valvesServer) OpenAll(ctx context.Context, req *valves.OpenAllRequest) (*valves.ValvesState, error) {
+	if err := s.vc.openAll(); err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to open all valves: %v", err)
+	}
+	return s.state(), nil
+}
+
+// State returns the state of all the valves.
+func (s *valvesServer) State(ctx context.Context, req *valves.StateRequest) (*valves.ValvesState, error) {
+	return s.state(), nil
+}
+
+// Health checks that the node is able to move the valves.
+func (s *valvesServer) Health(ctx context.Context, req *valves.HealthRequest) (*valves.HealthResponse, error) {
+	if len(s.vc.state()) == 0 {
+		return &valves.HealthResponse{Ok: false, Message: "no valves configured"}, nil
+	}
+	return &valves.HealthResponse{Ok: true, Message: "ok"}, nil
+}
+
+// state returns the state of the valves, sorted by name.
+func (s *valvesServer) state() *valves.ValvesState {
+	resp := &valves.ValvesState{}
+	for name, open := range s.vc.state() {
+		resp.Valves = append(resp.Valves, &valves.ValveState{Name: name, Open: open})
+	}
+	sort.Slice(resp.Valves, func(i, j int) bool { return resp.Valves[i].Name < resp.Valves[j].Name })
+	return resp
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// valveController moves the bi-stable valves.
+// All the valves share the two relays which control the direction of the current,
+// so only one valve at a time can be moved.
+type valveController struct {
+	// relayPlus and relayMinus controls the direcotion of current
+	relayPlus  actuator
+	relayMinus actuator
+	// valves by name
+	valves map[string]actuator
+	// open keeps the last known state of every valve
+	open map[string]bool
+	// pulse is how long the current is sent to a valve to move it
+	pulse time.Duration
+	sync.Mutex
+}
+
+// newValveController returns a valveController.
+func newValveController(relayPlus, relayMinus actuator, valves map[string]actuator, pulse time.Duration) *valveController {
+	return &valveController{
+		relayPlus:  relayPlus,
+		relayMinus: relayMinus,
+		valves:     valves,
+		open:       make(map[string]bool),
+		pulse:      pulse,
+	}
+}
+
+// reset sets all the relays at rest.
+// A release is closed when you set "HIGH" the pin.
+func (vc *valveController) reset() error {
+	vc.Lock()
+	defer vc.Unlock()
+
+	if err := vc.setDirection(true); err != nil {
+		return err
+	}
+	for name, valve := range vc.valves {
+		if err := valve.On(); err != nil {
+			return fmt.Errorf("unable to reset valve '%s': %v", name, err)
+		}
+	}
+	return nil
+}
+
+// setDirection sets the direction of the current: "open" or "close".
+// The caller must hold the lock.
+func (vc *valveController) setDirection(open bool) error {
+	var err error
+	if open {
+		if err = vc.relayMinus.On(); err == nil {
+			err = vc.relayPlus.On()
+		}
+	} else {
+		if err = vc.relayMinus.Off(); err == nil {
+			err = vc.relayPlus.Off()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("unable to set the direction of the current: %v", err)
+	}
+	return nil
+}
+
+// move opens or closes a valve sending a pulse of current.
+// The caller must hold the lock.
+func (vc *valveController) move(name string, open bool) error {
+	valve, ok := vc.valves[name]
+	if !ok {
+		return fmt.Errorf("unknown valve '%s'", name)
+	}
+
+	if err := vc.setDirection(open); err != nil {
+		return err
+	}
+	// Whatever happens, the direction goes back to rest.
+	defer vc.setDirection(true)
+
+	// Activate the valve.
+	if err := valve.Off(); err != nil {
+		return fmt.Errorf("unable to activate valve '%s': %v", name, err)
+	}
+	<-time.After(vc.pulse) // We wait a bit.
+	if err := valve.On(); err != nil {
+		return fmt.Errorf("unable to release valve '%s': %v", name, err)
+	}
+
+	vc.open[name] = open
+	return nil
+}
+
+// check returns an error if some valve is unknown.
+// The caller must hold the lock.
+func (vc *valveController) check(names []string) error {
+	for _, name := range names {
+		if _, ok := vc.valves[name]; !ok {
+			return fmt.Errorf("unknown valve '%s'", name)
+		}
+	}
+	return nil
+}
+
+// openValves opens the named valves.
+// If exclusive is true, all the other valves are closed.
+// It's thread safe.
+func (vc *valveController) openValves(names []string, exclusive bool) error {
+	vc.Lock()
+	defer vc.Unlock()
+
+	if err := vc.check(names); err != nil {
+		return err
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+		if err := vc.move(name, true); err != nil {
+			return err
+		}
+	}
+
+	if exclusive {
+		for name := range vc.valves {
+			if wanted[name] {
+				continue
+			}
+			if err := vc.move(name, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// closeValves closes the named valves, all the valves if names is empty.
+// It's thread safe.
+func (vc *valveController) closeValves(names []string) error {
+	vc.Lock()
+	defer vc.Unlock()
+
+	if len(names) == 0 {
+		names = vc.names()
+	}
+	if err := vc.check(names); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := vc.move(name, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openAll opens all the valves.
+// This is made for security reason. If we are unable to close valves,
+// at least water will come out without damage the pomp.
+// It's thread safe.
+func (vc *valveController) openAll() error {
+	vc.Lock()
+	defer vc.Unlock()
+
+	for _, name := range vc.names() {
+		if err := vc.move(name, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// state returns the last known state of every valve.
+// It's thread safe.
+func (vc *valveController) state() map[string]bool {
+	vc.Lock()
+	defer vc.Unlock()
+
+	state := make(map[string]bool)
+	for name := range vc.valves {
+		state[name] = vc.open[name]
+	}
+	return state
+}
+
+// names returns the sorted names of the valves.
+// The caller must hold the lock.
+func (vc *valveController) names() []string {
+	names := make([]string, 0, len(vc.valves))
+	for name := range vc.valves {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,4 @@
+// Package valves contains the gRPC protocol used by "pomp" to command the valves of "relays".
+package valves
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative valves.proto
@@ -0,0 +1,471 @@
+// Protocol between "pomp" (the scheduler) and "relays" (the node which controls the valves).
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: valves.proto
+
+package valves
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type OpenRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Valves        []string               `protobuf:"bytes,1,rep,name=valves,proto3" json:"valves,omitempty"`
+	Exclusive     bool                   `protobuf:"varint,2,opt,name=exclusive,proto3" json:"exclusive,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OpenRequest) Reset() {
+	*x = OpenRequest{}
+	mi := &file_valves_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OpenRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OpenRequest) ProtoMessage() {}
+
+func (x *OpenRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_valves_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OpenRequest.ProtoReflect.Descriptor instead.
+func (*OpenRequest) Descriptor() ([]byte, []int) {
+	return file_valves_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *OpenRequest) GetValves() []string {
+	if x != nil {
+		return x.Valves
+	}
+	return nil
+}
+
+func (x *OpenRequest) GetExclusive() bool {
+	if x != nil {
+		return x.Exclusive
+	}
+	return false
+}
+
+type CloseRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Valves        []string               `protobuf:"bytes,1,rep,name=valves,proto3" json:"valves,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CloseRequest) Reset() {
+	*x = CloseRequest{}
+	mi := &file_valves_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CloseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CloseRequest) ProtoMessage() {}
+
+func (x *CloseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_valves_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CloseRequest.ProtoReflect.Descriptor instead.
+func (*CloseRequest) Descriptor() ([]byte, []int) {
+	return file_valves_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CloseRequest) GetValves() []string {
+	if x != nil {
+		return x.Valves
+	}
+	return nil
+}
+
+type OpenAllRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OpenAllRequest) Reset() {
+	*x = OpenAllRequest{}
+	mi := &file_valves_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OpenAllRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OpenAllRequest) ProtoMessage() {}
+
+func (x *OpenAllRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_valves_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OpenAllRequest.ProtoReflect.Descriptor instead.
+func (*OpenAllRequest) Descriptor() ([]byte, []int) {
+	return file_valves_proto_rawDescGZIP(), []int{2}
+}
+
+type StateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StateRequest) Reset() {
+	*x = StateRequest{}
+	mi := &file_valves_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StateRequest) ProtoMessage() {}
+
+func (x *StateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_valves_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StateRequest.ProtoReflect.Descriptor instead.
+func (*StateRequest) Descriptor() ([]byte, []int) {
+	return file_valves_proto_rawDescGZIP(), []int{3}
+}
+
+type ValveState struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Open          bool                   `protobuf:"varint,2,opt,name=open,proto3" json:"open,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ValveState) Reset() {
+	*x = ValveState{}
+	mi := &file_valves_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ValveState) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ValveState) ProtoMessage() {}
+
+func (x *ValveState) ProtoReflect() protoreflect.Message {
+	mi := &file_valves_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ValveState.ProtoReflect.Descriptor instead.
+func (*ValveState) Descriptor() ([]byte, []int) {
+	return file_valves_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ValveState) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ValveState) GetOpen() bool {
+	if x != nil {
+		return x.Open
+	}
+	return false
+}
+
+type ValvesState struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Valves        []*ValveState          `protobuf:"bytes,1,rep,name=valves,proto3" json:"valves,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ValvesState) Reset() {
+	*x = ValvesState{}
+	mi := &file_valves_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ValvesState) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ValvesState) ProtoMessage() {}
+
+func (x *ValvesState) ProtoReflect() protoreflect.Message {
+	mi := &file_valves_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ValvesState.ProtoReflect.Descriptor instead.
+func (*ValvesState) Descriptor() ([]byte, []int) {
+	return file_valves_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ValvesState) GetValves() []*ValveState {
+	if x != nil {
+		return x.Valves
+	}
+	return nil
+}
+
+type HealthRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HealthRequest) Reset() {
+	*x = HealthRequest{}
+	mi := &file_valves_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HealthRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HealthRequest) ProtoMessage() {}
+
+func (x *HealthRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_valves_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HealthRequest.ProtoReflect.Descriptor instead.
+func (*HealthRequest) Descriptor() ([]byte, []int) {
+	return file_valves_proto_rawDescGZIP(), []int{6}
+}
+
+type HealthResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ok            bool                   `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HealthResponse) Reset() {
+	*x = HealthResponse{}
+	mi := &file_valves_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HealthResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HealthResponse) ProtoMessage() {}
+
+func (x *HealthResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_valves_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HealthResponse.ProtoReflect.Descriptor instead.
+func (*HealthResponse) Descriptor() ([]byte, []int) {
+	return file_valves_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *HealthResponse) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+func (x *HealthResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+var File_valves_proto protoreflect.FileDescriptor
+
+const file_valves_proto_rawDesc = "" +
+	"\n" +
+	"\fvalves.proto\x12\x06valves\"C\n" +
+	"\vOpenRequest\x12\x16\n" +
+	"\x06valves\x18\x01 \x03(\tR\x06valves\x12\x1c\n" +
+	"\texclusive\x18\x02 \x01(\bR\texclusive\"&\n" +
+	"\fCloseRequest\x12\x16\n" +
+	"\x06valves\x18\x01 \x03(\tR\x06valves\"\x10\n" +
+	"\x0eOpenAllRequest\"\x0e\n" +
+	"\fStateRequest\"4\n" +
+	"\n" +
+	"ValveState\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04open\x18\x02 \x01(\bR\x04open\"9\n" +
+	"\vValvesState\x12*\n" +
+	"\x06valves\x18\x01 \x03(\v2\x12.valves.ValveStateR\x06valves\"\x0f\n" +
+	"\rHealthRequest\":\n" +
+	"\x0eHealthResponse\x12\x0e\n" +
+	"\x02ok\x18\x01 \x01(\bR\x02ok\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage2\x93\x02\n" +
+	"\x06Valves\x120\n" +
+	"\x04Open\x12\x13.valves.OpenRequest\x1a\x13.valves.ValvesState\x122\n" +
+	"\x05Close\x12\x14.valves.CloseRequest\x1a\x13.valves.ValvesState\x126\n" +
+	"\aOpenAll\x12\x16.valves.OpenAllRequest\x1a\x13.valves.ValvesState\x122\n" +
+	"\x05State\x12\x14.valves.StateRequest\x1a\x13.valves.ValvesState\x127\n" +
+	"\x06Health\x12\x15.valves.HealthRequest\x1a\x16.valves.HealthResponseB1Z/github.com/tux-eithel/PIrrigation_system/valvesb\x06proto3"
+
+var (
+	file_valves_proto_rawDescOnce sync.Once
+	file_valves_proto_rawDescData []byte
+)
+
+func file_valves_proto_rawDescGZIP() []byte {
+	file_valves_proto_rawDescOnce.Do(func() {
+		file_valves_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_valves_proto_rawDesc), len(file_valves_proto_rawDesc)))
+	})
+	return file_valves_proto_rawDescData
+}
+
+var file_valves_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_valves_proto_goTypes = []any{
+	(*OpenRequest)(nil),    // 0: valves.OpenRequest
+	(*CloseRequest)(nil),   // 1: valves.CloseRequest
+	(*OpenAllRequest)(nil), // 2: valves.OpenAllRequest
+	(*StateRequest)(nil),   // 3: valves.StateRequest
+	(*ValveState)(nil),     // 4: valves.ValveState
+	(*ValvesState)(nil),    // 5: valves.ValvesState
+	(*HealthRequest)(nil),  // 6: valves.HealthRequest
+	(*HealthResponse)(nil), // 7: valves.HealthResponse
+}
+var file_valves_proto_depIdxs = []int32{
+	4, // 0: valves.ValvesState.valves:type_name -> valves.ValveState
+	0, // 1: valves.Valves.Open:input_type -> valves.OpenRequest
+	1, // 2: valves.Valves.Close:input_type -> valves.CloseRequest
+	2, // 3: valves.Valves.OpenAll:input_type -> valves.OpenAllRequest
+	3, // 4: valves.Valves.State:input_type -> valves.StateRequest
+	6, // 5: valves.Valves.Health:input_type -> valves.HealthRequest
+	5, // 6: valves.Valves.Open:output_type -> valves.ValvesState
+	5, // 7: valves.Valves.Close:output_type -> valves.ValvesState
+	5, // 8: valves.Valves.OpenAll:output_type -> valves.ValvesState
+	5, // 9: valves.Valves.State:output_type -> valves.ValvesState
+	7, // 10: valves.Valves.Health:output_type -> valves.HealthResponse
+	6, // [6:11] is the sub-list for method output_type
+	1, // [1:6] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_valves_proto_init() }
+func file_valves_proto_init() {
+	if File_valves_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_valves_proto_rawDesc), len(file_valves_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_valves_proto_goTypes,
+		DependencyIndexes: file_valves_proto_depIdxs,
+		MessageInfos:      file_valves_proto_msgTypes,
+	}.Build()
+	File_valves_proto = out.File
+	file_valves_proto_goTypes = nil
+	file_valves_proto_depIdxs = nil
+}
@@ -0,0 +1,297 @@
+// Protocol between "pomp" (the scheduler) and "relays" (the node which controls the valves).
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: valves.proto
+
+package valves
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Valves_Open_FullMethodName    = "/valves.Valves/Open"
+	Valves_Close_FullMethodName   = "/valves.Valves/Close"
+	Valves_OpenAll_FullMethodName = "/valves.Valves/OpenAll"
+	Valves_State_FullMethodName   = "/valves.Valves/State"
+	Valves_Health_FullMethodName  = "/valves.Valves/Health"
+)
+
+// ValvesClient is the client API for Valves service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Valves controls the electric valves of the garden.
+// Every call returns after the valves have been moved, so the response
+// is the acknowledgement of the command.
+type ValvesClient interface {
+	// Open opens the named valves.
+	// If exclusive is true, all the other valves are closed.
+	Open(ctx context.Context, in *OpenRequest, opts ...grpc.CallOption) (*ValvesState, error)
+	// Close closes the named valves, all the valves if the list is empty.
+	Close(ctx context.Context, in *CloseRequest, opts ...grpc.CallOption) (*ValvesState, error)
+	// OpenAll opens all the valves.
+	// It's the safe state: if the pump starts, water comes out without damaging it.
+	OpenAll(ctx context.Context, in *OpenAllRequest, opts ...grpc.CallOption) (*ValvesState, error)
+	// State returns the state of all the valves.
+	State(ctx context.Context, in *StateRequest, opts ...grpc.CallOption) (*ValvesState, error)
+	// Health checks that the node is able to move the valves.
+	Health(ctx context.Context, in *HealthRequest, opts ...grpc.CallOption) (*HealthResponse, error)
+}
+
+type valvesClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewValvesClient(cc grpc.ClientConnInterface) ValvesClient {
+	return &valvesClient{cc}
+}
+
+func (c *valvesClient) Open(ctx context.Context, in *OpenRequest, opts ...grpc.CallOption) (*ValvesState, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ValvesState)
+	err := c.cc.Invoke(ctx, Valves_Open_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *valvesClient) Close(ctx context.Context, in *CloseRequest, opts ...grpc.CallOption) (*ValvesState, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ValvesState)
+	err := c.cc.Invoke(ctx, Valves_Close_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *valvesClient) OpenAll(ctx context.Context, in *OpenAllRequest, opts ...grpc.CallOption) (*ValvesState, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ValvesState)
+	err := c.cc.Invoke(ctx, Valves_OpenAll_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *valvesClient) State(ctx context.Context, in *StateRequest, opts ...grpc.CallOption) (*ValvesState, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ValvesState)
+	err := c.cc.Invoke(ctx, Valves_State_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *valvesClient) Health(ctx context.Context, in *HealthRequest, opts ...grpc.CallOption) (*HealthResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HealthResponse)
+	err := c.cc.Invoke(ctx, Valves_Health_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ValvesServer is the server API for Valves service.
+// All implementations must embed UnimplementedValvesServer
+// for forward compatibility.
+//
+// Valves controls the electric valves of the garden.
+// Every call returns after the valves have been moved, so the response
+// is the acknowledgement of the command.
+type ValvesServer interface {
+	// Open opens the named valves.
+	// If exclusive is true, all the other valves are closed.
+	Open(context.Context, *OpenRequest) (*ValvesState, error)
+	// Close closes the named valves, all the valves if the list is empty.
+	Close(context.Context, *CloseRequest) (*ValvesState, error)
+	// OpenAll opens all the valves.
+	// It's the safe state: if the pump starts, water comes out without damaging it.
+	OpenAll(context.Context, *OpenAllRequest) (*ValvesState, error)
+	// State returns the state of all the valves.
+	State(context.Context, *StateRequest) (*ValvesState, error)
+	// Health checks that the node is able to move the valves.
+	Health(context.Context, *HealthRequest) (*HealthResponse, error)
+	mustEmbedUnimplementedValvesServer()
+}
+
+// UnimplementedValvesServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedValvesServer struct{}
+
+func (UnimplementedValvesServer) Open(context.Context, *OpenRequest) (*ValvesState, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Open not implemented")
+}
+func (UnimplementedValvesServer) Close(context.Context, *CloseRequest) (*ValvesState, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Close not implemented")
+}
+func (UnimplementedValvesServer) OpenAll(context.Context, *OpenAllRequest) (*ValvesState, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method OpenAll not implemented")
+}
+func (UnimplementedValvesServer) State(context.Context, *StateRequest) (*ValvesState, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method State not implemented")
+}
+func (UnimplementedValvesServer) Health(context.Context, *HealthRequest) (*HealthResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Health not implemented")
+}
+func (UnimplementedValvesServer) mustEmbedUnimplementedValvesServer() {}
+func (UnimplementedValvesServer) testEmbeddedByValue()                {}
+
+// UnsafeValvesServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ValvesServer will
+// result in compilation errors.
+type UnsafeValvesServer interface {
+	mustEmbedUnimplementedValvesServer()
+}
+
+func RegisterValvesServer(s grpc.ServiceRegistrar, srv ValvesServer) {
+	// If the following call pancis, it indicates UnimplementedValvesServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Valves_ServiceDesc, srv)
+}
+
+func _Valves_Open_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(OpenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ValvesServer).Open(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Valves_Open_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ValvesServer).Open(ctx, req.(*OpenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Valves_Close_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CloseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ValvesServer).Close(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Valves_Close_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ValvesServer).Close(ctx, req.(*CloseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Valves_OpenAll_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(OpenAllRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ValvesServer).OpenAll(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Valves_OpenAll_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ValvesServer).OpenAll(ctx, req.(*OpenAllRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Valves_State_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ValvesServer).State(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Valves_State_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ValvesServer).State(ctx, req.(*StateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Valves_Health_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HealthRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ValvesServer).Health(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Valves_Health_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ValvesServer).Health(ctx, req.(*HealthRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Valves_ServiceDesc is the grpc.ServiceDesc for Valves service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Valves_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "valves.Valves",
+	HandlerType: (*ValvesServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Open",
+			Handler:    _Valves_Open_Handler,
+		},
+		{
+			MethodName: "Close",
+			Handler:    _Valves_Close_Handler,
+		},
+		{
+			MethodName: "OpenAll",
+			Handler:    _Valves_OpenAll_Handler,
+		},
+		{
+			MethodName: "State",
+			Handler:    _Valves_State_Handler,
+		},
+		{
+			MethodName: "Health",
+			Handler:    _Valves_Health_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "valves.proto",
+}